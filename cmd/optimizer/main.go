@@ -4,27 +4,66 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	_ "net/http/pprof"
 
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
 	"github.com/beepfd/bpf-optimizer/pkg/optimizer"
 )
 
 var (
-	inputFile = flag.String("input", "", "Input BPF object file (.o)")
-	inputDir  = flag.String("input-dir", "", "Input directory of BPF object files (.o)")
-	outputDir = flag.String("output-dir", "", "Output directory of optimized BPF object files (.o)")
-	verbose   = flag.Bool("verbose", false, "Verbose output")
-	stats     = flag.Bool("stats", false, "Show optimization statistics")
-	help      = flag.Bool("help", false, "Show help message")
-	version   = flag.Bool("version", false, "Show version information")
+	inputFile  = flag.String("input", "", "Input BPF object file (.o)")
+	inputDir   = flag.String("input-dir", "", "Input directory of BPF object files (.o)")
+	outputDir  = flag.String("output-dir", "", "Output directory of optimized BPF object files (.o)")
+	outputPath = flag.String("output", "", "Output BPF object file (.o), - to write it to stdout")
+	verbose    = flag.Bool("verbose", false, "Verbose output")
+	quiet      = flag.Bool("quiet", false, "Only print errors and the output asked for (-stats, -report-only, -budget, ...)")
+	stats      = flag.Bool("stats", false, "Show optimization statistics")
+	help       = flag.Bool("help", false, "Show help message")
+	version    = flag.Bool("version", false, "Show version information")
+	reportOnly = flag.Bool("report-only", false, "Only report optimization opportunities without applying them")
+	nopEncode  = flag.String("nop-encoding", "goto", "Filler for removed instructions: goto (goto +0) or mov (r0 = r0)")
+	showCFG    = flag.Bool("cfg", false, "Print the basic blocks, edges, loops and unreachable blocks of each section, without saving")
+	dumpDeps   = flag.String("dump-deps", "", "Write the dependency graph of each section as CSV (e.g. out.csv)")
+	symbolName = flag.String("symbol", "", "Only optimize the function with this symbol name")
+	traceFile  = flag.String("trace-file", "", "Write the passes applied to each section as JSON")
+	replayFile = flag.String("replay", "", "Validate a trace written by -trace-file against a fresh run, without saving")
+	merlinRef  = flag.String("compare-merlin", "", "Compare the output with an object optimized by Merlin (e.g. ref.o), without saving")
+	parityDir  = flag.String("parity", "", "Compare the analysis with the Merlin dumps (analyz_result.csv, dep_nodes, dep_nodes_rev, dep_nodes_len, section_deps) in this directory")
+	paritySec  = flag.String("parity-section", "", "Section compared by -parity, needed when the input has more than one")
+	keepTrail  = flag.Bool("keep-trailing", false, "Keep trailing bytes that are not a whole instruction instead of skipping the section")
+	cacheDir   = flag.String("cache-dir", "", "Cache the dependency analysis of each section in this directory")
+	rangeFlag  = flag.String("range", "", "Only apply optimizations whose instructions all fall in start:end (e.g. 500:520)")
+	passOrder  = flag.String("pass-order", "", "Comma-separated passes to apply in order (default const-prop,compaction,peephole,lddw-dedup,branch-fold,superword,dead-code,dead-def,self-move; map-dedup and tail-merge only run when listed)")
+	fixpoint   = flag.Int("fixpoint", 0, "Rerun the passes, rebuilding the dependencies, until a round changes nothing or this many rounds ran")
+	patchFile  = flag.String("patch", "", "Write the byte changes as a patch file (e.g. out.patch), without saving")
+	applyFile  = flag.String("apply-patch", "", "Apply a patch written by -patch to the input instead of optimizing it")
+	helperArgs = flag.Bool("helper-args", false, "Use the argument counts of common tracing helpers instead of assuming r1-r5 (diverges from Merlin)")
+	nopJumps   = flag.Bool("merge-across-nop-jumps", false, "Let superword merge stores separated by a goto +0 that no branch lands on")
+	aggressive = flag.Bool("aggressive", false, "Let superword merge stack stores with unwritten bytes between them when those bytes are provably dead or zero, and branch folding drop a constant jump guarding a load or store")
+	noRelocOpt = flag.Bool("no-reloc-opt", false, "Never rewrite instructions carrying a relocation (map references, CO-RE accesses)")
+	budget     = flag.Int("budget", 0, "Report active instructions per section against this budget (e.g. 4096 or 1000000)")
 )
 
+// indexRange is the parsed -range flag, nil for the whole section
+var indexRange *optimizer.IndexRange
+
+// passes is the parsed -pass-order flag, nil for the default order
+var passes []string
+
+// logger prints the status lines and errors of the tool, and the optimizer's own debug output
+var logger = &optimizer.Logger{Output: os.Stdout, ErrorOutput: os.Stderr, Level: optimizer.LogInfo}
+
+// objectOutput receives the optimized object for -output -, captured before stdout is redirected
+var objectOutput io.Writer = os.Stdout
+
 const (
 	VERSION     = "1.0.0"
 	DESCRIPTION = "BPF字节码优化器 - Go版本"
@@ -33,6 +72,14 @@ const (
 func main() {
 	flag.Parse()
 
+	switch {
+	case *quiet:
+		logger.Level = optimizer.LogError
+	case *verbose:
+		logger.Level = optimizer.LogDebug
+	}
+	optimizer.SetLogger(logger)
+
 	// add pprof
 	go func() {
 		http.ListenAndServe("0.0.0.0:6060", nil)
@@ -54,17 +101,66 @@ func main() {
 
 	// Validate arguments
 	if *inputFile == "" && *inputDir == "" {
-		fmt.Fprintf(os.Stderr, "错误: 必须指定输入文件或者目录\n")
+		logger.Errorf("错误: 必须指定输入文件或者目录")
 		showUsage()
 		os.Exit(1)
 	}
 
 	if *inputFile != "" && *inputDir != "" {
-		fmt.Fprintf(os.Stderr, "错误: 不能同时指定输入文件和输入目录\n")
+		logger.Errorf("错误: 不能同时指定输入文件和输入目录")
 		showUsage()
 		os.Exit(1)
 	}
 
+	encoding, ok := map[string]string{"goto": bpf.NOP, "mov": bpf.NOPMov}[*nopEncode]
+	if !ok {
+		logger.Errorf("错误: 不支持的 NOP 编码 '%s' (可选: goto, mov)", *nopEncode)
+		showUsage()
+		os.Exit(1)
+	}
+	if err := bpf.SetNOPEncoding(encoding); err != nil {
+		logger.Errorf("错误: %v", err)
+		showUsage()
+		os.Exit(1)
+	}
+
+	if *helperArgs {
+		optimizer.SetHelperArgCounts(optimizer.DefaultHelperArgCounts)
+	}
+
+	if *rangeFlag != "" {
+		r, err := optimizer.ParseIndexRange(*rangeFlag)
+		if err != nil {
+			logger.Errorf("错误: %v", err)
+			os.Exit(1)
+		}
+		indexRange = r
+	}
+
+	if *passOrder != "" {
+		passes = strings.Split(*passOrder, ",")
+		if err := optimizer.ValidatePassOrder(passes); err != nil {
+			logger.Errorf("错误: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if *inputDir != "" && (*traceFile != "" || *replayFile != "" || *merlinRef != "" || *parityDir != "" || *patchFile != "" || *applyFile != "") {
+		logger.Errorf("错误: -trace-file, -replay, -compare-merlin, -parity, -patch 和 -apply-patch 只支持单个输入文件")
+		os.Exit(1)
+	}
+
+	if *inputDir != "" && *outputPath != "" {
+		logger.Errorf("错误: -output 只支持单个输入文件, 目录请使用 -output-dir")
+		os.Exit(1)
+	}
+
+	if *outputPath == "-" {
+		// Everything printed goes to stderr so stdout only carries the object bytes
+		os.Stdout = os.Stderr
+		logger.Output = os.Stderr
+	}
+
 	if *outputDir == "" {
 		// Default output file
 		*outputDir = *inputDir
@@ -73,32 +169,104 @@ func main() {
 	if *inputFile != "" {
 		// Check if input file exists
 		if _, err := os.Stat(*inputFile); os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "错误: 输入文件 '%s' 不存在\n", *inputFile)
+			logger.Errorf("错误: 输入文件 '%s' 不存在", *inputFile)
 			os.Exit(1)
 		}
 
+		if *dumpDeps != "" {
+			if err := dumpDependencies(*inputFile, *dumpDeps); err != nil {
+				logger.Errorf("导出依赖图失败: %v", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if *reportOnly {
+			if err := reportBPF(*inputFile); err != nil {
+				logger.Errorf("分析失败: %v", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if *showCFG {
+			if err := summarizeCFG(*inputFile); err != nil {
+				logger.Errorf("分析失败: %v", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if *replayFile != "" {
+			if err := replayTrace(*inputFile, *replayFile); err != nil {
+				logger.Errorf("回放失败: %v", err)
+				os.Exit(1)
+			}
+			logger.Infof("✓ 回放一致: %s", *replayFile)
+			return
+		}
+
+		if *merlinRef != "" {
+			if err := compareMerlin(*inputFile, *merlinRef); err != nil {
+				logger.Errorf("对比失败: %v", err)
+				os.Exit(1)
+			}
+			logger.Infof("✓ 与 Merlin 输出一致: %s", *merlinRef)
+			return
+		}
+
+		if *parityDir != "" {
+			if err := checkParity(*inputFile, *parityDir, *paritySec); err != nil {
+				logger.Errorf("对比失败: %v", err)
+				os.Exit(1)
+			}
+			logger.Infof("✓ 与 Merlin 分析一致: %s", *parityDir)
+			return
+		}
+
+		if *patchFile != "" {
+			if err := writePatch(*inputFile, *patchFile); err != nil {
+				logger.Errorf("生成补丁失败: %v", err)
+				os.Exit(1)
+			}
+			logger.Infof("✓ 补丁已生成: %s", *patchFile)
+			return
+		}
+
 		outputFile := *outputDir + "/" + filepath.Base(*inputFile)
+		if *outputPath != "" {
+			outputFile = *outputPath
+		}
+
+		if *applyFile != "" {
+			if err := applyPatch(*inputFile, *applyFile, outputFile); err != nil {
+				logger.Errorf("应用补丁失败: %v", err)
+				os.Exit(1)
+			}
+			logger.Infof("✓ 补丁已应用: %s -> %s", *inputFile, outputFile)
+			return
+		}
 
 		// Perform optimization
 		if err := optimizeBPF(*inputFile, outputFile); err != nil {
-			fmt.Fprintf(os.Stderr, "优化失败: %v\n", err)
+			logger.Errorf("优化失败: %v", err)
 			os.Exit(1)
 		}
 
-		fmt.Printf("✓ 优化完成: %s -> %s\n", *inputFile, outputFile)
+		logger.Infof("✓ 优化完成: %s -> %s", *inputFile, outputFile)
 		return
 	}
 
 	if *inputDir != "" {
 		// Check if input directory exists
 		if _, err := os.Stat(*inputDir); os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "错误: 输入目录 '%s' 不存在\n", *inputDir)
+			logger.Errorf("错误: 输入目录 '%s' 不存在", *inputDir)
 			os.Exit(1)
 		}
 
 		files, err := os.ReadDir(*inputDir)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "错误: 读取输入目录失败: %v\n", err)
+			logger.Errorf("错误: 读取输入目录失败: %v", err)
 			os.Exit(1)
 		}
 
@@ -112,15 +280,22 @@ func main() {
 			}
 
 			inputFile := strings.Join([]string{*inputDir, file.Name()}, "/")
+			if *reportOnly {
+				if err := reportBPF(inputFile); err != nil {
+					logger.Errorf("分析失败: %v", err)
+				}
+				continue
+			}
+
 			outputFile := strings.Join([]string{*outputDir, file.Name()}, "/")
 
-			fmt.Printf("start optimize %s\n", inputFile)
+			logger.Infof("start optimize %s", inputFile)
 			if err := optimizeBPF(inputFile, outputFile); err != nil {
-				fmt.Fprintf(os.Stderr, "优化失败: %v\n", err)
+				logger.Errorf("优化失败: %v", err)
 				continue
 			}
 
-			fmt.Printf("✓ optimize done: %s -> %s\n", inputFile, outputFile)
+			logger.Infof("✓ optimize done: %s -> %s", inputFile, outputFile)
 		}
 	}
 
@@ -129,30 +304,36 @@ func main() {
 func optimizeBPF(inputPath, outputPath string) error {
 	startTime := time.Now()
 
-	if *verbose {
-		fmt.Printf("正在加载 BPF 程序: %s\n", inputPath)
-	}
+	logger.Debugf("正在加载 BPF 程序: %s", inputPath)
 
 	// Load BPF program
-	prog, err := optimizer.NewBPFProgram(inputPath)
+	prog, err := optimizer.NewBPFProgramWithOptions(inputPath, programOptions(false))
 	if err != nil {
 		return fmt.Errorf("加载 BPF 程序失败: %v", err)
 	}
 	defer prog.Close()
 
-	if *verbose {
-		fmt.Printf("找到 %d 个代码段\n", len(prog.Sections))
-		for sectionName, section := range prog.Sections {
-			fmt.Printf("  - %s: %d 条指令\n", sectionName, len(section.Instructions))
+	logger.Debugf("找到 %d 个代码段", len(prog.Sections))
+	for sectionName, section := range prog.Sections {
+		logger.Debugf("  - %s: %d 条指令", sectionName, len(section.Instructions))
+	}
+
+	if *traceFile != "" {
+		if err := writeTrace(prog, *traceFile); err != nil {
+			return fmt.Errorf("写入 trace 失败: %v", err)
 		}
 	}
 
 	// Save optimized program
-	if *verbose {
-		fmt.Printf("正在保存优化后的程序: %s\n", outputPath)
-	}
+	logger.Debugf("正在保存优化后的程序: %s", outputPath)
 
-	if err := prog.Save(outputPath); err != nil {
+	if outputPath == "-" {
+		_, err = prog.WriteTo(objectOutput)
+	} else {
+		err = prog.Save(outputPath)
+	}
+	printDiagnostics(prog)
+	if err != nil {
 		return fmt.Errorf("保存优化程序失败: %v", err)
 	}
 
@@ -163,9 +344,321 @@ func optimizeBPF(inputPath, outputPath string) error {
 		showStatistics(prog, duration)
 	}
 
+	if *budget > 0 {
+		showBudget(prog, *budget)
+	}
+
+	return nil
+}
+
+func reportBPF(inputPath string) error {
+	prog, err := optimizer.NewBPFProgramWithOptions(inputPath, programOptions(true))
+	if err != nil {
+		return fmt.Errorf("加载 BPF 程序失败: %v", err)
+	}
+	defer prog.Close()
+	printDiagnostics(prog)
+
+	sectionNames := make([]string, 0, len(prog.Sections))
+	for sectionName := range prog.Sections {
+		sectionNames = append(sectionNames, sectionName)
+	}
+	sort.Strings(sectionNames)
+
+	fmt.Printf("=== 优化机会: %s ===\n", inputPath)
+	for _, sectionName := range sectionNames {
+		section := prog.Sections[sectionName]
+		opportunities := section.FindOpportunities()
+		fmt.Printf("段 %s: %d 个优化机会\n", sectionName, len(opportunities))
+		for _, opportunity := range opportunities {
+			fmt.Printf("  [%s] %v\n", opportunity.Pass, opportunity.Indices)
+			for _, idx := range opportunity.Indices {
+				fmt.Printf("    %d: %s\n", idx, section.Instructions[idx].String())
+			}
+		}
+	}
+
+	return nil
+}
+
+// summarizeCFG prints the control flow graph summary of every section, before optimization
+func summarizeCFG(inputPath string) error {
+	prog, err := optimizer.NewBPFProgramWithOptions(inputPath, programOptions(true))
+	if err != nil {
+		return fmt.Errorf("加载 BPF 程序失败: %v", err)
+	}
+	defer prog.Close()
+	printDiagnostics(prog)
+
+	sectionNames := make([]string, 0, len(prog.Sections))
+	for sectionName := range prog.Sections {
+		sectionNames = append(sectionNames, sectionName)
+	}
+	sort.Strings(sectionNames)
+
+	fmt.Printf("=== 控制流图: %s ===\n", inputPath)
+	for _, sectionName := range sectionNames {
+		summary := prog.Sections[sectionName].SummarizeCFG()
+		fmt.Printf("段 %s:\n", sectionName)
+		fmt.Printf("  基本块: %d\n", summary.Blocks)
+		fmt.Printf("  边: %d\n", summary.Edges)
+		fmt.Printf("  循环: %d %v\n", len(summary.Loops), summary.Loops)
+		fmt.Printf("  不可达块: %d %v\n", len(summary.Unreachable), summary.Unreachable)
+	}
+
+	return nil
+}
+
+func writeTrace(prog *optimizer.BPFProgram, path string) error {
+	data, err := json.MarshalIndent(prog.Trace(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// replayTrace re-runs the optimizer on inputPath and checks it applies the same passes as the recorded trace
+func replayTrace(inputPath, tracePath string) error {
+	data, err := os.ReadFile(tracePath)
+	if err != nil {
+		return fmt.Errorf("读取 trace 失败: %v", err)
+	}
+
+	var recorded []optimizer.TraceEntry
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return fmt.Errorf("解析 trace 失败: %v", err)
+	}
+
+	prog, err := optimizer.NewBPFProgramWithOptions(inputPath, programOptions(false))
+	if err != nil {
+		return fmt.Errorf("加载 BPF 程序失败: %v", err)
+	}
+	defer prog.Close()
+
+	return optimizer.CompareTraces(recorded, prog.Trace())
+}
+
+func writePatch(inputPath, patchPath string) error {
+	prog, err := optimizer.NewBPFProgramWithOptions(inputPath, programOptions(false))
+	if err != nil {
+		return fmt.Errorf("加载 BPF 程序失败: %v", err)
+	}
+	defer prog.Close()
+
+	entries, err := prog.Patch()
+	printDiagnostics(prog)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(patchPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return optimizer.WritePatch(file, entries)
+}
+
+func applyPatch(inputPath, patchPath, outputPath string) error {
+	file, err := os.Open(patchPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries, err := optimizer.ReadPatch(file)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+	if err := optimizer.ApplyPatch(data, entries); err != nil {
+		return err
+	}
+
+	if outputPath == "-" {
+		_, err = objectOutput.Write(data)
+		return err
+	}
+	return os.WriteFile(outputPath, data, 0644)
+}
+
+// printDiagnostics reports the problems the optimizer recovered from on stderr,
+// which stays clean of the object when it is written to stdout
+func printDiagnostics(prog *optimizer.BPFProgram) {
+	for _, d := range prog.Diagnostics {
+		if d.Severity == optimizer.SeverityError {
+			logger.Errorf("%s", d)
+		} else {
+			logger.Warnf("%s", d)
+		}
+	}
+}
+
+// programOptions returns the load options selected on the command line
+func programOptions(skipOptimization bool) optimizer.ProgramOptions {
+	return optimizer.ProgramOptions{
+		SkipOptimization: skipOptimization,
+		Symbol:           *symbolName,
+		KeepTrailingData: *keepTrail,
+		Range:            indexRange,
+		CacheDir:         *cacheDir,
+		PassOrder:        passes,
+
+		ProtectRelocations:  *noRelocOpt,
+		MergeAcrossNopJumps: *nopJumps,
+		AggressiveMerge:     *aggressive,
+		AggressiveFold:      *aggressive,
+
+		FixpointIterations: *fixpoint,
+	}
+}
+
+// compareMerlin runs the passes Merlin implements on inputPath and reports every instruction
+// that differs from the Merlin-optimized refPath
+func compareMerlin(inputPath, refPath string) error {
+	divergences, err := optimizer.CompareMerlin(inputPath, refPath, programOptions(false))
+	if err != nil {
+		return err
+	}
+
+	for _, d := range divergences {
+		fmt.Printf("段 %s #%d:\n", d.Section, d.Index)
+		fmt.Printf("  Go:     %s\n", describeInstruction(d.Got))
+		fmt.Printf("  Merlin: %s\n", describeInstruction(d.Want))
+	}
+
+	if len(divergences) > 0 {
+		return fmt.Errorf("%d 条指令与 Merlin 不一致", len(divergences))
+	}
 	return nil
 }
 
+// checkParity compares the analysis of one section of inputPath with the Merlin dumps in dir
+// and reports the first divergence in each dump
+func checkParity(inputPath, dir, sectionName string) error {
+	prog, err := optimizer.NewBPFProgramWithOptions(inputPath, programOptions(true))
+	if err != nil {
+		return fmt.Errorf("加载 BPF 程序失败: %v", err)
+	}
+	defer prog.Close()
+
+	names := make([]string, 0, len(prog.Sections))
+	for name := range prog.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if sectionName == "" {
+		if len(names) != 1 {
+			return fmt.Errorf("请用 -parity-section 指定要对比的段: %s", strings.Join(names, ", "))
+		}
+		sectionName = names[0]
+	}
+	section, ok := prog.Sections[sectionName]
+	if !ok {
+		return fmt.Errorf("段 %s 不存在: %s", sectionName, strings.Join(names, ", "))
+	}
+
+	divergences, err := section.MerlinParity(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range divergences {
+		fmt.Printf("%s #%d:\n", d.Dump, d.Index)
+		fmt.Printf("  Go:     %s\n", d.Got)
+		fmt.Printf("  Merlin: %s\n", d.Want)
+		for _, line := range d.Context {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+
+	if len(divergences) > 0 {
+		return fmt.Errorf("%d 个文件与 Merlin 不一致", len(divergences))
+	}
+	return nil
+}
+
+func describeInstruction(inst *bpf.Instruction) string {
+	if inst == nil {
+		return "<缺失>"
+	}
+	return inst.String()
+}
+
+// dumpDependencies writes one CSV per section, named <output>_<section>.csv
+func dumpDependencies(inputPath, outputPath string) error {
+	prog, err := optimizer.NewBPFProgramWithOptions(inputPath, programOptions(true))
+	if err != nil {
+		return fmt.Errorf("加载 BPF 程序失败: %v", err)
+	}
+	defer prog.Close()
+
+	base := strings.TrimSuffix(outputPath, ".csv")
+	for sectionName, section := range prog.Sections {
+		sectionPath := fmt.Sprintf("%s_%s.csv", base, strings.NewReplacer("/", "_", ".", "_").Replace(sectionName))
+		f, err := os.Create(sectionPath)
+		if err != nil {
+			return fmt.Errorf("创建文件失败: %v", err)
+		}
+
+		err = section.WriteDependencyCSV(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("写入段 %s 失败: %v", sectionName, err)
+		}
+
+		logger.Debugf("段 %s -> %s", sectionName, sectionPath)
+	}
+
+	return nil
+}
+
+// techniques are the optimization techniques the help lists, with the pass implementing each
+var techniques = []struct {
+	pass string
+	name string
+}{
+	{optimizer.PassConstantPropagation, "常量传播 (Constant Propagation)"},
+	{optimizer.PassCompaction, "代码紧凑化 (Code Compaction)"},
+	{optimizer.PassPeephole, "窥孔优化 (Peephole Optimization)"},
+	{optimizer.PassSuperword, "超字合并 (Superword-level Merge)"},
+}
+
+// showEliminated prints the instructions each technique removed, then those of the other passes that removed any
+func showEliminated(eliminated map[string]int) {
+	fmt.Println("\n=== 按优化技术统计 ===")
+
+	total := 0
+	listed := make(map[string]bool)
+	for _, technique := range techniques {
+		fmt.Printf("%6d  %s\n", eliminated[technique.pass], technique.name)
+		total += eliminated[technique.pass]
+		listed[technique.pass] = true
+	}
+
+	passes := make([]string, 0, len(eliminated))
+	for pass := range eliminated {
+		if !listed[pass] && eliminated[pass] != 0 {
+			passes = append(passes, pass)
+		}
+	}
+	sort.Strings(passes)
+	for _, pass := range passes {
+		fmt.Printf("%6d  %s\n", eliminated[pass], pass)
+		total += eliminated[pass]
+	}
+
+	fmt.Printf("%6d  合计\n", total)
+}
+
 func showStatistics(prog *optimizer.BPFProgram, duration time.Duration) {
 	stats := prog.GetOptimizationStats()
 
@@ -182,6 +675,10 @@ func showStatistics(prog *optimizer.BPFProgram, duration time.Duration) {
 			fmt.Printf("  总指令数: %d\n", sStats["total"])
 			fmt.Printf("  活动指令: %d\n", sStats["active"])
 			fmt.Printf("  NOP指令: %d\n", sStats["nops"])
+			fmt.Printf("  输入中原有的NOP指令: %d\n", sStats["input_nops"])
+			fmt.Printf("  栈深度: %d 字节\n", sStats["stack_depth"])
+			fmt.Printf("  指令数: %d -> %d\n", sStats["instructions_before"], sStats["instructions_after"])
+			fmt.Printf("  分支数: %d -> %d\n", sStats["branches_before"], sStats["branches_after"])
 			if sStats["total"] > 0 {
 				optimizationRatio := float64(sStats["nops"]) / float64(sStats["total"]) * 100
 				fmt.Printf("  优化率: %.1f%%\n", optimizationRatio)
@@ -196,11 +693,31 @@ func showStatistics(prog *optimizer.BPFProgram, duration time.Duration) {
 		fmt.Printf("总指令数: %v\n", summary["total_instructions"])
 		fmt.Printf("优化指令数: %v\n", summary["optimized_instructions"])
 		fmt.Printf("NOP指令数: %v\n", summary["nop_instructions"])
+		fmt.Printf("指令数: %v -> %v\n", summary["instructions_before"], summary["instructions_after"])
+		fmt.Printf("分支数: %v -> %v\n", summary["branches_before"], summary["branches_after"])
 		if ratio, ok := summary["optimization_ratio"].(float64); ok {
 			fmt.Printf("总体优化率: %.1f%%\n", ratio*100)
 		}
 		fmt.Printf("处理耗时: %v\n", duration)
 
+		if eliminated, ok := summary["eliminated"].(map[string]int); ok {
+			showEliminated(eliminated)
+		}
+
+		// Candidates the passes applied, and those a safety guard kept them from applying
+		if candidates, ok := summary["candidates"].(optimizer.PassCandidates); ok && len(candidates) > 0 {
+			passes := make([]string, 0, len(candidates))
+			for pass := range candidates {
+				passes = append(passes, pass)
+			}
+			sort.Strings(passes)
+
+			fmt.Println("\n=== 优化候选 ===")
+			for _, pass := range passes {
+				fmt.Printf("%s: %s\n", pass, candidates[pass])
+			}
+		}
+
 		if *verbose {
 			fmt.Println("\n详细统计 (JSON):")
 			jsonData, _ := json.MarshalIndent(stats, "", "  ")
@@ -209,14 +726,25 @@ func showStatistics(prog *optimizer.BPFProgram, duration time.Duration) {
 	}
 }
 
+func showBudget(prog *optimizer.BPFProgram, budget int) {
+	fmt.Printf("\n=== 指令预算 (%d) ===\n", budget)
+	for _, usage := range prog.CheckBudget(budget) {
+		verdict := "PASS"
+		if usage.Exceeded() {
+			verdict = "FAIL"
+		}
+		fmt.Printf("段 %s: %d / %d (%.1f%%) %s\n", usage.Section, usage.Active, usage.Budget,
+			float64(usage.Active)/float64(usage.Budget)*100, verdict)
+	}
+}
+
 func showHelp() {
 	fmt.Printf("%s %s\n\n", DESCRIPTION, VERSION)
 
 	fmt.Println("这是一个 BPF 字节码优化器，实现了以下优化技术：")
-	fmt.Println("  • 常量传播 (Constant Propagation)")
-	fmt.Println("  • 代码紧凑化 (Code Compaction)")
-	fmt.Println("  • 窥孔优化 (Peephole Optimization)")
-	fmt.Println("  • 超字合并 (Superword-level Merge)")
+	for _, technique := range techniques {
+		fmt.Printf("  • %s\n", technique.name)
+	}
 	fmt.Println()
 
 	showUsage()
@@ -225,9 +753,47 @@ func showHelp() {
 	fmt.Println("  # 基本优化")
 	fmt.Println("  bpf-optimizer -input program.o -output program_opt.o")
 	fmt.Println()
+	fmt.Println("  # 输出到 stdout, 统计等信息写到 stderr")
+	fmt.Println("  bpf-optimizer -input program.o -output - -stats | gzip > program_opt.o.gz")
+	fmt.Println()
 	fmt.Println("  # 显示优化统计")
 	fmt.Println("  bpf-optimizer -input program.o -stats")
 	fmt.Println()
+	fmt.Println("  # 检查优化后每个段是否在旧内核的 4096 条指令限制内")
+	fmt.Println("  bpf-optimizer -input program.o -budget 4096")
+	fmt.Println()
+	fmt.Println("  # 只报告优化机会, 不修改程序")
+	fmt.Println("  bpf-optimizer -input program.o -report-only")
+	fmt.Println()
+	fmt.Println("  # 只优化指定函数")
+	fmt.Println("  bpf-optimizer -input program.o -symbol handle_tracepoint")
+	fmt.Println()
+	fmt.Println("  # 记录每个 pass 的改动, 之后用 -replay 校验")
+	fmt.Println("  bpf-optimizer -input program.o -trace-file trace.json")
+	fmt.Println("  bpf-optimizer -input program.o -replay trace.json")
+	fmt.Println()
+	fmt.Println("  # 只应用落在指令 500 到 520 之间的优化, 用于二分定位问题")
+	fmt.Println("  bpf-optimizer -input program.o -range 500:520")
+	fmt.Println()
+	fmt.Println("  # 先合并存储再做常量传播, 对比 pass 顺序的影响")
+	fmt.Println("  bpf-optimizer -input program.o -pass-order superword,const-prop,compaction,peephole -stats")
+	fmt.Println()
+	fmt.Println("  # 导出字节级补丁供审查, 之后再应用")
+	fmt.Println("  bpf-optimizer -input program.o -patch program.patch")
+	fmt.Println("  bpf-optimizer -input program.o -apply-patch program.patch -output program_opt.o")
+	fmt.Println()
+	fmt.Println("  # 与 Merlin 优化后的目标文件逐条对比")
+	fmt.Println("  bpf-optimizer -input program.o -compare-merlin program_merlin.o")
+	fmt.Println()
+	fmt.Println("  # 与 Merlin 导出的分析中间文件 (analyz_result.csv, dep_nodes 等) 对比")
+	fmt.Println("  bpf-optimizer -input program.o -parity merlin_dump/ -parity-section xdp")
+	fmt.Println()
+	fmt.Println("  # 查看每个段的基本块, 边, 循环和不可达块")
+	fmt.Println("  bpf-optimizer -input program.o -cfg")
+	fmt.Println()
+	fmt.Println("  # 导出依赖图 (每个段一个 CSV)")
+	fmt.Println("  bpf-optimizer -input program.o -dump-deps deps.csv")
+	fmt.Println()
 	fmt.Println("  # 详细输出")
 	fmt.Println("  bpf-optimizer -input program.o -verbose")
 	fmt.Println()
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the tool itself when the test binary is re-executed by runOptimizer
+func TestMain(m *testing.M) {
+	if args := os.Getenv("BPF_OPTIMIZER_ARGS"); args != "" {
+		os.Args = append([]string{"bpf-optimizer"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runOptimizer runs the tool with args and returns its stdout and stderr
+func runOptimizer(t *testing.T, args ...string) (string, string) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "BPF_OPTIMIZER_ARGS="+strings.Join(args, "\n"))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("bpf-optimizer %v: %v\nstderr: %s", args, err, stderr.String())
+	}
+	return stdout.String(), stderr.String()
+}
+
+func TestQuiet(t *testing.T) {
+	input := "../../testdata/bpf_map_reference.o"
+	output := filepath.Join(t.TempDir(), "out.o")
+
+	stdout, _ := runOptimizer(t, "-input", input, "-output", output)
+	if stdout == "" {
+		t.Errorf("expected status output without -quiet")
+	}
+
+	stdout, stderr := runOptimizer(t, "-input", input, "-output", output, "-quiet")
+	if stdout != "" || stderr != "" {
+		t.Errorf("expected no output with -quiet, got stdout %q, stderr %q", stdout, stderr)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("expected %s to be written: %v", output, err)
+	}
+
+	// Output asked for is still printed
+	stdout, _ = runOptimizer(t, "-input", input, "-output", output, "-quiet", "-stats")
+	if !strings.Contains(stdout, "优化统计") {
+		t.Errorf("expected -stats output with -quiet, got %q", stdout)
+	}
+}
+
+func TestStatsByTechnique(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "out.o")
+	stdout, _ := runOptimizer(t, "-input", "../../testdata/bpf_generic_uprobe_v61.o", "-output", output, "-stats")
+
+	// Every technique of the help is listed with the instructions it removed
+	for _, want := range []string{
+		"    94  常量传播 (Constant Propagation)",
+		"    50  代码紧凑化 (Code Compaction)",
+		"     3  窥孔优化 (Peephole Optimization)",
+		"   143  超字合并 (Superword-level Merge)",
+		"     2  dead-def",
+		"   292  合计",
+	} {
+		if !strings.Contains(stdout, want+"\n") {
+			t.Errorf("-stats output is missing %q:\n%s", want, stdout)
+		}
+	}
+}
+
+func TestInvalidNOPEncoding(t *testing.T) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "BPF_OPTIMIZER_ARGS="+strings.Join([]string{
+		"-input", "../../testdata/bpf_map_reference.o", "-output", filepath.Join(t.TempDir(), "out.o"), "-nop-encoding", "nop",
+	}, "\n"))
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err == nil {
+		t.Fatalf("expected an unsupported -nop-encoding to fail")
+	}
+	if !strings.Contains(output.String(), "-nop-encoding") {
+		t.Errorf("expected the usage message, got %q", output.String())
+	}
+}
@@ -3,3 +3,7 @@ module github.com/beepfd/bpf-optimizer
 go 1.23.0
 
 toolchain go1.23.4
+
+require github.com/cilium/ebpf v0.19.0
+
+require golang.org/x/sys v0.31.0 // indirect
@@ -1,9 +1,15 @@
 package bpf
 
 import (
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"strings"
 )
 
+// InstructionSize is the encoded size of a single BPF instruction slot in bytes
+const InstructionSize = 8
+
 // Instruction represents a BPF instruction (16 bytes)
 type Instruction struct {
 	Raw    string // 16-byte hex string representation
@@ -14,12 +20,14 @@ type Instruction struct {
 	Imm    int32
 }
 
-// NewInstruction creates a new instruction from hex string
+// NewInstruction creates a new instruction from hex string.
+// Raw is normalized to lowercase, which the pattern matches on Raw rely on.
 func NewInstruction(hexStr string) (*Instruction, error) {
 	if len(hexStr) != 16 {
 		return nil, fmt.Errorf("instruction must be 16 hex characters, got %d", len(hexStr))
 	}
 
+	hexStr = strings.ToLower(hexStr)
 	inst := &Instruction{Raw: hexStr}
 
 	// eBPF 指令格式
@@ -58,11 +66,67 @@ func NewInstruction(hexStr string) (*Instruction, error) {
 	return inst, nil
 }
 
+// NewInstructionFromFields creates a new instruction from decoded fields, deriving Raw from them
+func NewInstructionFromFields(opcode, dstReg, srcReg uint8, offset int16, imm int32) (*Instruction, error) {
+	inst := &Instruction{
+		Opcode: opcode,
+		DstReg: dstReg,
+		SrcReg: srcReg,
+		Offset: offset,
+		Imm:    imm,
+	}
+
+	data, err := inst.AppendBytes(make([]byte, 0, InstructionSize))
+	if err != nil {
+		return nil, err
+	}
+	inst.Raw = hex.EncodeToString(data)
+
+	return inst, nil
+}
+
+// CheckIntegrity re-parses Raw and reports the first decoded field it disagrees with.
+// Passes rewriting Raw and code setting the fields directly can leave the two apart,
+// and ToHex and AppendBytes would then encode different instructions.
+func (inst *Instruction) CheckIntegrity() error {
+	parsed, err := NewInstruction(inst.Raw)
+	if err != nil {
+		return fmt.Errorf("raw %q does not parse: %v", inst.Raw, err)
+	}
+
+	switch {
+	case parsed.Opcode != inst.Opcode:
+		return fmt.Errorf("raw %s has opcode 0x%02x, field is 0x%02x", inst.Raw, parsed.Opcode, inst.Opcode)
+	case parsed.DstReg != inst.DstReg:
+		return fmt.Errorf("raw %s has dst r%d, field is r%d", inst.Raw, parsed.DstReg, inst.DstReg)
+	case parsed.SrcReg != inst.SrcReg:
+		return fmt.Errorf("raw %s has src r%d, field is r%d", inst.Raw, parsed.SrcReg, inst.SrcReg)
+	case parsed.Offset != inst.Offset:
+		return fmt.Errorf("raw %s has offset %d, field is %d", inst.Raw, parsed.Offset, inst.Offset)
+	case parsed.Imm != inst.Imm:
+		return fmt.Errorf("raw %s has imm %d, field is %d", inst.Raw, parsed.Imm, inst.Imm)
+	}
+
+	return nil
+}
+
 // ToHex converts instruction back to hex string
 func (inst *Instruction) ToHex() string {
 	return inst.Raw
 }
 
+// AppendBytes appends the 8-byte little-endian encoding of the decoded fields to buf
+func (inst *Instruction) AppendBytes(buf []byte) ([]byte, error) {
+	if inst.DstReg > 0x0F || inst.SrcReg > 0x0F {
+		return buf, fmt.Errorf("register out of range: dst r%d, src r%d", inst.DstReg, inst.SrcReg)
+	}
+
+	buf = append(buf, inst.Opcode, inst.SrcReg<<4|inst.DstReg)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(inst.Offset))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(inst.Imm))
+	return buf, nil
+}
+
 // GetInstructionClass returns the BPF instruction class
 func (inst *Instruction) GetInstructionClass() uint8 {
 	return inst.Opcode & 0x07
@@ -73,20 +137,90 @@ func (inst *Instruction) GetALUOp() uint8 {
 	return inst.Opcode & 0xF0
 }
 
+// IsJump checks if this is a jump carrying a branch target, i.e. a JMP/JMP32 op other than call and exit
+func (inst *Instruction) IsJump() bool {
+	class := inst.GetInstructionClass()
+	if class != BPF_JMP && class != BPF_JMP32 {
+		return false
+	}
+
+	op := inst.GetALUOp()
+	return op != JMP_CALL && op != JMP_EXIT
+}
+
+// IsPseudoCall checks if this is a BPF-to-BPF call, whose imm is the offset of the callee
+func (inst *Instruction) IsPseudoCall() bool {
+	return inst.Opcode == BPF_JMP|JMP_CALL && inst.SrcReg == BPF_PSEUDO_CALL
+}
+
+// IsTailCall checks if this is a call to bpf_tail_call
+func (inst *Instruction) IsTailCall() bool {
+	return inst.Opcode == BPF_JMP|JMP_CALL && inst.SrcReg == 0 && inst.Imm == BPF_FUNC_tail_call
+}
+
 // IsLoadImm64 checks if this is a 64-bit immediate load instruction
 func (inst *Instruction) IsLoadImm64() bool {
 	return inst.Opcode == 0x18
 }
 
-// IsNOP checks if this instruction is a NOP
+// Width returns the encoded size in bytes of the instruction starting with this slot:
+// an lddw spans two slots, everything else one
+func (inst *Instruction) Width() int {
+	if inst.IsLoadImm64() {
+		return 2 * InstructionSize
+	}
+	return InstructionSize
+}
+
+// IsPseudoLoadImm64 checks if this is an lddw whose src_reg asks the loader for something other
+// than the constant (map fd, map value, variable or function address), so imm is not its value
+func (inst *Instruction) IsPseudoLoadImm64() bool {
+	return inst.IsLoadImm64() && inst.SrcReg != 0
+}
+
+// CombineLoadImm64 returns the 64-bit constant of an lddw from its two slots:
+// the first imm holds the low 32 bits, the second the high 32 bits
+func CombineLoadImm64(first, second *Instruction) uint64 {
+	return uint64(uint32(first.Imm)) | uint64(uint32(second.Imm))<<32
+}
+
+// nopEncoding is the filler written over removed instructions
+var nopEncoding = NOP
+
+// SetNOPEncoding selects the filler used for removed instructions.
+// Only NOP (goto +0, the default) and NOPMov (r0 = r0) are accepted.
+func SetNOPEncoding(raw string) error {
+	if raw != NOP && raw != NOPMov {
+		return fmt.Errorf("unsupported NOP encoding %q", raw)
+	}
+	nopEncoding = raw
+	return nil
+}
+
+// NOPEncoding returns the filler currently used for removed instructions
+func NOPEncoding() string {
+	return nopEncoding
+}
+
+// IsNOP checks if this instruction is a NOP, judging `goto +0` by its decoded fields
+// (the register nibbles are ignored by JA, so stray bits still count), or is the
+// configured filler, e.g. `r0 = r0` under the mov encoding
 func (inst *Instruction) IsNOP() bool {
-	return inst.Raw == NOP
+	if inst.Opcode == BPF_JMP|JMP_A {
+		return inst.Offset == 0 && inst.Imm == 0
+	}
+	return inst.IsSyntheticNOP()
+}
+
+// IsSyntheticNOP checks if this instruction is exactly the filler written by SetAsNOP
+func (inst *Instruction) IsSyntheticNOP() bool {
+	return inst.Raw == nopEncoding
 }
 
 // SetAsNOP marks this instruction as NOP
 func (inst *Instruction) SetAsNOP() {
-	inst.Raw = NOP
-	inst.Opcode = 0x05
+	inst.Raw = nopEncoding
+	inst.Opcode, _ = parseOpcode(nopEncoding)
 	inst.DstReg = 0
 	inst.SrcReg = 0
 	inst.Offset = 0
@@ -0,0 +1,35 @@
+package bpf
+
+import (
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+func FuzzNewInstruction(f *testing.F) {
+	for _, seed := range []string{NOP, NOPMov, "18010000ffffffff", "62011000ffffffff", "8510000005000000"} {
+		f.Add(seed)
+	}
+	if data, err := os.ReadFile("../../testdata/section_data"); err == nil {
+		for i := 0; i+16 <= len(data) && i < 64*16; i += 16 {
+			f.Add(string(data[i : i+16]))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, hexStr string) {
+		inst, err := NewInstruction(hexStr)
+		if err != nil {
+			return
+		}
+
+		// Re-encoding the decoded fields must give back the input bytes
+		data, err := inst.AppendBytes(nil)
+		if err != nil {
+			t.Fatalf("AppendBytes() error = %v for %q", err, hexStr)
+		}
+		if got := hex.EncodeToString(data); !strings.EqualFold(got, hexStr) {
+			t.Errorf("AppendBytes() = %s, want %s", got, hexStr)
+		}
+	})
+}
@@ -1,7 +1,9 @@
 package bpf
 
 import (
+	"encoding/hex"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -37,6 +39,16 @@ func TestNewInstruction(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "uppercase hex is normalized",
+			args: args{
+				hexStr: "07010000D0FEFFFF",
+			},
+			want: []*Instruction{
+				{Raw: "07010000d0feffff", Opcode: 7, DstReg: 1, SrcReg: 0, Offset: 0, Imm: -304},
+			},
+			wantErr: false,
+		},
 		{
 			name: "multiple instructions",
 			// 00000000000009f8 <LBB7_53>:
@@ -103,3 +115,231 @@ func TestNewInstruction(t *testing.T) {
 		})
 	}
 }
+
+func TestSetNOPEncoding(t *testing.T) {
+	defer SetNOPEncoding(NOP)
+
+	if err := SetNOPEncoding("0000000000000000"); err == nil {
+		t.Errorf("SetNOPEncoding() expected error for unsupported encoding")
+	}
+
+	for _, encoding := range []string{NOPMov, NOP} {
+		if err := SetNOPEncoding(encoding); err != nil {
+			t.Fatalf("SetNOPEncoding(%s) error = %v", encoding, err)
+		}
+
+		inst, err := NewInstruction("b701000001000000")
+		if err != nil {
+			t.Fatalf("NewInstruction() error = %v", err)
+		}
+		if inst.IsNOP() {
+			t.Errorf("mov r1, 1 should not be a NOP with encoding %s", encoding)
+		}
+
+		inst.SetAsNOP()
+		if inst.Raw != encoding {
+			t.Errorf("SetAsNOP() Raw = %s, want %s", inst.Raw, encoding)
+		}
+		if !inst.IsNOP() {
+			t.Errorf("IsNOP() = false after SetAsNOP() with encoding %s", encoding)
+		}
+
+		want, _ := NewInstruction(encoding)
+		if !reflect.DeepEqual(inst, want) {
+			t.Errorf("SetAsNOP() fields = %v, want %v", inst, want)
+		}
+	}
+}
+
+func TestIsNOPFieldBased(t *testing.T) {
+	tests := []struct {
+		name      string
+		bytes     []byte
+		nop       bool
+		synthetic bool
+	}{
+		{"goto +0", []byte{0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, true, true},
+		{"goto +0 with stray dst nibble", []byte{0x05, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, true, false},
+		{"goto +0 with stray src nibble", []byte{0x05, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, true, false},
+		{"goto +1", []byte{0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}, false, false},
+		{"goto +0 with imm", []byte{0x05, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}, false, false},
+		{"r3 = r3 is a move", []byte{0xbf, 0x33, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, false, false},
+		{"r0 = r0 is a move under the goto encoding", []byte{0xbf, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, false, false},
+		{"r3 = r1", []byte{0xbf, 0x13, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, false, false},
+		{"w3 = w3 zero-extends", []byte{0xbc, 0x33, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inst, err := NewInstruction(hex.EncodeToString(tt.bytes))
+			if err != nil {
+				t.Fatalf("NewInstruction() error = %v", err)
+			}
+			if got := inst.IsNOP(); got != tt.nop {
+				t.Errorf("IsNOP() = %v, want %v", got, tt.nop)
+			}
+			if got := inst.IsSyntheticNOP(); got != tt.synthetic {
+				t.Errorf("IsSyntheticNOP() = %v, want %v", got, tt.synthetic)
+			}
+		})
+	}
+}
+
+func TestIsJump(t *testing.T) {
+	tests := []struct {
+		raw  string
+		jump bool
+	}{
+		{"0500010000000000", true},  // goto +1
+		{"1501020000000000", true},  // if r1 == 0 goto +2
+		{"a601020005000000", true},  // if w1 < 5 goto +2
+		{"0600000010000000", true},  // gotol +16
+		{"8500000001000000", false}, // call 1
+		{"9500000000000000", false}, // exit
+		{"b701000000000000", false}, // r1 = 0
+	}
+
+	for _, tt := range tests {
+		inst, err := NewInstruction(tt.raw)
+		if err != nil {
+			t.Fatalf("NewInstruction(%s) error = %v", tt.raw, err)
+		}
+		if got := inst.IsJump(); got != tt.jump {
+			t.Errorf("IsJump(%s) = %v, want %v", tt.raw, got, tt.jump)
+		}
+	}
+}
+
+func TestIsPseudoCall(t *testing.T) {
+	tests := []struct {
+		raw    string
+		pseudo bool
+	}{
+		{"8510000005000000", true},  // call pc+5
+		{"8500000005000000", false}, // call bpf_probe_read (helper 5)
+		{"8520000005000000", false}, // call kfunc
+		{"1510000005000000", false}, // if r0 == 5 goto, src_reg 1
+	}
+
+	for _, tt := range tests {
+		inst, err := NewInstruction(tt.raw)
+		if err != nil {
+			t.Fatalf("NewInstruction(%s) error = %v", tt.raw, err)
+		}
+		if got := inst.IsPseudoCall(); got != tt.pseudo {
+			t.Errorf("IsPseudoCall(%s) = %v, want %v", tt.raw, got, tt.pseudo)
+		}
+	}
+}
+
+func TestIsTailCall(t *testing.T) {
+	tests := []struct {
+		raw  string
+		tail bool
+	}{
+		{"850000000c000000", true},  // call bpf_tail_call
+		{"851000000c000000", false}, // call pc+12
+		{"8500000005000000", false}, // call bpf_probe_read (helper 5)
+		{"150000000c000000", false}, // if r0 == 12 goto +0
+	}
+
+	for _, tt := range tests {
+		inst, err := NewInstruction(tt.raw)
+		if err != nil {
+			t.Fatalf("NewInstruction(%s) error = %v", tt.raw, err)
+		}
+		if got := inst.IsTailCall(); got != tt.tail {
+			t.Errorf("IsTailCall(%s) = %v, want %v", tt.raw, got, tt.tail)
+		}
+	}
+}
+
+func TestIsPseudoLoadImm64(t *testing.T) {
+	tests := []struct {
+		raw    string
+		pseudo bool
+	}{
+		{"1801000078563412", false}, // r1 = 0x12345678 ll
+		{"1811000000000000", true},  // r1 = map_fd(0) ll
+		{"1821000000000000", true},  // r1 = map_value(0) ll
+		{"1831000000000000", true},  // r1 = btf_var ll
+		{"b711000000000000", false}, // not an lddw
+	}
+
+	for _, tt := range tests {
+		inst, err := NewInstruction(tt.raw)
+		if err != nil {
+			t.Fatalf("NewInstruction(%s) error = %v", tt.raw, err)
+		}
+		if got := inst.IsPseudoLoadImm64(); got != tt.pseudo {
+			t.Errorf("IsPseudoLoadImm64(%s) = %v, want %v", tt.raw, got, tt.pseudo)
+		}
+	}
+}
+
+func TestMnemonic(t *testing.T) {
+	tests := []struct {
+		raw      string
+		mnemonic string
+	}{
+		{"1801000078563412", "lddw"},
+		{"7910080000000000", "ldxdw"}, // r0 = *(u64 *)(r1 + 8)
+		{"9110080000000000", "ldxsb"}, // r0 = *(s8 *)(r1 + 8)
+		{"7a0af8ff01000000", "stdw"},  // *(u64 *)(r10 - 8) = 1
+		{"7b1af0ff00000000", "stxdw"}, // *(u64 *)(r10 - 16) = r1
+		{"c310000001000000", "atomic_fetch_add32"},
+		{"db100000f1000000", "cmpxchg64"},
+		{"0701000001000000", "add64"},   // r1 += 1
+		{"6701000020000000", "lsh64"},   // r1 <<= 32
+		{"7701000020000000", "rsh64"},   // r1 >>= 32
+		{"bc10000000000000", "mov32"},   // w0 = w1
+		{"bf10080000000000", "movsx64"}, // r0 = (s8)r1
+		{"3f10010000000000", "sdiv64"},  // r0 s/= r1
+		{"dc01000010000000", "be16"},    // r1 = be16 r1
+		{"d701000020000000", "bswap32"}, // r1 = bswap32 r1
+		{"1501000000000000", "jeq"},     // if r1 == 0 goto +0
+		{"1601000000000000", "jeq32"},   // if w1 == 0 goto +0
+		{"0600000005000000", "gotol"},   // gotol +5
+		{"8500000005000000", "call"},    // call bpf_probe_read
+		{"9500000000000000", "exit"},
+		{"ff00000000000000", "unknown_0xff"},
+	}
+
+	for _, tt := range tests {
+		inst, err := NewInstruction(tt.raw)
+		if err != nil {
+			t.Fatalf("NewInstruction(%s) error = %v", tt.raw, err)
+		}
+		if got := inst.Mnemonic(); got != tt.mnemonic {
+			t.Errorf("Mnemonic(%s) = %s, want %s", tt.raw, got, tt.mnemonic)
+		}
+	}
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	inst, err := NewInstruction("b701000001000000") // r1 = 1
+	if err != nil {
+		t.Fatalf("NewInstruction() error = %v", err)
+	}
+	if err := inst.CheckIntegrity(); err != nil {
+		t.Errorf("CheckIntegrity() = %v, want nil", err)
+	}
+
+	// Setting a field leaves Raw stale
+	inst.Imm = 2
+	if err := inst.CheckIntegrity(); err == nil || !strings.Contains(err.Error(), "imm") {
+		t.Errorf("CheckIntegrity() = %v, want an imm mismatch", err)
+	}
+
+	// So does rewriting Raw
+	inst, _ = NewInstruction("b701000001000000")
+	inst.Raw = "bf12000000000000"
+	if err := inst.CheckIntegrity(); err == nil || !strings.Contains(err.Error(), "opcode") {
+		t.Errorf("CheckIntegrity() = %v, want an opcode mismatch", err)
+	}
+
+	inst.Raw = ""
+	if err := inst.CheckIntegrity(); err == nil {
+		t.Errorf("CheckIntegrity() with an empty Raw = nil, want an error")
+	}
+}
@@ -0,0 +1,165 @@
+package bpf
+
+import "fmt"
+
+// aluMnemonics names the ALU operations, suffixed with 32 or 64 by Mnemonic
+var aluMnemonics = map[uint8]string{
+	ALU_ADD:  "add",
+	ALU_SUB:  "sub",
+	ALU_MUL:  "mul",
+	ALU_DIV:  "div",
+	ALU_OR:   "or",
+	ALU_AND:  "and",
+	ALU_LSH:  "lsh",
+	ALU_RSH:  "rsh",
+	ALU_NEG:  "neg",
+	ALU_MOD:  "mod",
+	ALU_XOR:  "xor",
+	ALU_MOV:  "mov",
+	ALU_ARSH: "arsh",
+}
+
+// jumpMnemonics names the jump operations, JMP32 ones get a 32 suffix
+var jumpMnemonics = map[uint8]string{
+	JMP_A:    "ja",
+	JMP_EQ:   "jeq",
+	JMP_GT:   "jgt",
+	JMP_GE:   "jge",
+	JMP_SET:  "jset",
+	JMP_NE:   "jne",
+	JMP_SGT:  "jsgt",
+	JMP_SGE:  "jsge",
+	JMP_CALL: "call",
+	JMP_EXIT: "exit",
+	JMP_LT:   "jlt",
+	JMP_LE:   "jle",
+	JMP_SLT:  "jslt",
+	JMP_SLE:  "jsle",
+}
+
+// sizeSuffixes names the memory access sizes as in ldxw, stdw
+var sizeSuffixes = map[uint8]string{
+	SIZE_W:  "w",
+	SIZE_H:  "h",
+	SIZE_B:  "b",
+	SIZE_DW: "dw",
+}
+
+// Mnemonic returns the opcode name in the style of the table in opcodes.go (lddw, ldxw, stdw,
+// add64, jeq32, ...), without operands. Signed div/mod and movsx are told apart by the offset,
+// atomics by the imm. Opcodes outside the instruction set give unknown_0x<opcode>.
+func (inst *Instruction) Mnemonic() string {
+	class := inst.GetInstructionClass()
+	size := sizeSuffixes[inst.Opcode&0x18]
+	mode := inst.Opcode & 0xE0
+
+	switch class {
+	case BPF_LD:
+		switch mode {
+		case BPF_IMM:
+			if inst.Opcode == BPF_LDDW {
+				return "lddw"
+			}
+		case BPF_ABS:
+			return "ldabs" + size
+		case BPF_IND:
+			return "ldind" + size
+		}
+
+	case BPF_LDX:
+		switch mode {
+		case BPF_MEM:
+			return "ldx" + size
+		case BPF_MEMSX:
+			return "ldxs" + size
+		}
+
+	case BPF_ST:
+		if mode == BPF_MEM {
+			return "st" + size
+		}
+
+	case BPF_STX:
+		switch mode {
+		case BPF_MEM:
+			return "stx" + size
+		case BPF_ATOMIC:
+			return atomicMnemonic(inst)
+		}
+
+	case BPF_ALU, BPF_ALU64:
+		bits := "32"
+		if class == BPF_ALU64 {
+			bits = "64"
+		}
+
+		op := inst.GetALUOp()
+		if op == ALU_END {
+			// Byte swaps name the width they swap, held in imm
+			if class == BPF_ALU64 {
+				return fmt.Sprintf("bswap%d", inst.Imm)
+			}
+			if inst.Opcode&BPF_TO_BE != 0 {
+				return fmt.Sprintf("be%d", inst.Imm)
+			}
+			return fmt.Sprintf("le%d", inst.Imm)
+		}
+
+		name, ok := aluMnemonics[op]
+		if !ok {
+			break
+		}
+		switch {
+		case (op == ALU_DIV || op == ALU_MOD) && inst.Offset == 1:
+			name = "s" + name
+		case op == ALU_MOV && inst.Opcode&BPF_X != 0 && inst.Offset != 0:
+			name = "movsx"
+		}
+		return name + bits
+
+	case BPF_JMP, BPF_JMP32:
+		name, ok := jumpMnemonics[inst.GetALUOp()]
+		if !ok {
+			break
+		}
+		if class == BPF_JMP32 {
+			switch inst.GetALUOp() {
+			case JMP_A:
+				return "gotol"
+			case JMP_CALL, JMP_EXIT:
+				// Only defined in the JMP class
+			default:
+				return name + "32"
+			}
+			break
+		}
+		return name
+	}
+
+	return fmt.Sprintf("unknown_0x%02x", inst.Opcode)
+}
+
+// atomicMnemonic names an atomic read-modify-write from the operation in its imm
+func atomicMnemonic(inst *Instruction) string {
+	bits := "32"
+	if inst.Opcode&0x18 == SIZE_DW {
+		bits = "64"
+	}
+
+	switch uint8(inst.Imm) {
+	case ATOMIC_XCHG:
+		return "xchg" + bits
+	case ATOMIC_CMPXCHG:
+		return "cmpxchg" + bits
+	}
+
+	op := uint8(inst.Imm) &^ ATOMIC_FETCH
+	name, ok := aluMnemonics[op]
+	if !ok || (op != ATOMIC_ADD && op != ATOMIC_OR && op != ATOMIC_AND && op != ATOMIC_XOR) {
+		return fmt.Sprintf("unknown_0x%02x", inst.Opcode)
+	}
+	if uint8(inst.Imm)&ATOMIC_FETCH != 0 {
+		return "atomic_fetch_" + name + bits
+	}
+	return "atomic_" + name + bits
+}
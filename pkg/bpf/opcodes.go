@@ -30,8 +30,8 @@ const (
 	ALU_SDIV  = 0x30
 	ALU_OR    = 0x40
 	ALU_AND   = 0x50
-	ALU_RSH   = 0x60
-	ALU_LSH   = 0x70
+	ALU_LSH   = 0x60
+	ALU_RSH   = 0x70
 	ALU_NEG   = 0x80
 	ALU_MOD   = 0x90
 	ALU_SMOD  = 0x90
@@ -102,9 +102,22 @@ const (
 	ATOMIC_CMPXCHG = 0xf1
 )
 
+// BPF_PSEUDO_CALL in src_reg marks a BPF-to-BPF call whose imm is a relative offset
+const BPF_PSEUDO_CALL = 0x01
+
+// BPF_FUNC_tail_call is the helper id of bpf_tail_call, which only returns when the tail call fails
+const BPF_FUNC_tail_call = 12
+
+// BPF_GOTOL is `gotol`, the JMP32-class unconditional jump whose offset is the 32-bit imm
+const BPF_GOTOL = BPF_JMP32 | JMP_A
+
 // NOP instruction (jump 0) - used to replace removed instructions
 const NOP = "0500000000000000"
 
+// NOPMov is the alternative filler `r0 = r0`, a true no-op that disassemblers
+// and verifiers do not count as a branch
+const NOPMov = "bf00000000000000"
+
 // 0x18	lddw dst, imm	dst = imm
 // 0x20	ldabsw src, dst, imm	See kernel documentation
 // 0x28	ldabsh src, dst, imm	...
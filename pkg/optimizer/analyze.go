@@ -1,7 +1,10 @@
 package optimizer
 
 import (
+	"fmt"
 	"math"
+	"sort"
+	"strings"
 
 	"github.com/beepfd/bpf-optimizer/pkg/bpf"
 )
@@ -14,6 +17,7 @@ func (a *InstructionAnalysis) ALU(opcode uint8, dst int, src int) {
 		a.UsedReg = []int{dst}
 	case bpf.ALU_MOV: // move
 		a.UpdatedReg = dst
+		// The self move w = w from compaction reads dst as well, since src == dst
 		if opcode&bpf.BPF_X == bpf.BPF_X {
 			a.UsedReg = []int{src}
 		}
@@ -35,8 +39,23 @@ func (a *InstructionAnalysis) JMP(opcode uint8, dst int, src int, off int16, imm
 		return
 	}
 
+	// gotol keeps its offset in imm, which does not fit the 16-bit Offset
+	if opcode == bpf.BPF_GOTOL {
+		a.UsedReg = []int{}
+		return
+	}
+
 	switch msb {
 	case bpf.JMP_CALL:
+		// BPF-to-BPF calls carry a relative offset in imm, not a helper id.
+		// The callee saves r6-r9 per the ABI, so only r1-r5 are clobbered like a helper call.
+		if src == bpf.BPF_PSEUDO_CALL {
+			a.UsedReg = []int{1, 2, 3, 4, 5}
+			a.UpdatedReg = 0
+			a.IsCall = true
+			return
+		}
+
 		// Handle different BPF helper functions
 		switch imm {
 		case 12: // tail call
@@ -58,7 +77,7 @@ func (a *InstructionAnalysis) JMP(opcode uint8, dst int, src int, off int16, imm
 			a.UsedReg = []int{1, 2, 3, 4, 5}
 			a.UpdatedReg = 0
 		default:
-			a.UsedReg = []int{1, 2, 3, 4, 5}
+			a.UsedReg = helperArgRegs(imm)
 			a.UpdatedReg = 0
 			a.IsCall = true
 		}
@@ -71,6 +90,70 @@ func (a *InstructionAnalysis) JMP(opcode uint8, dst int, src int, off int16, imm
 	}
 }
 
+// DefaultHelperArgCounts holds the number of arguments of common tracing helpers, by helper id
+var DefaultHelperArgCounts = map[int32]int{
+	14:  0, // get_current_pid_tgid
+	15:  0, // get_current_uid_gid
+	16:  2, // get_current_comm
+	25:  5, // perf_event_output
+	27:  3, // get_stackid
+	35:  0, // get_current_task
+	45:  3, // probe_read_str
+	67:  4, // get_stack
+	80:  0, // get_current_cgroup_id
+	112: 3, // probe_read_user
+	113: 3, // probe_read_kernel
+	114: 3, // probe_read_user_str
+	115: 3, // probe_read_kernel_str
+	125: 0, // ktime_get_boot_ns
+	130: 4, // ringbuf_output
+	131: 3, // ringbuf_reserve
+	132: 2, // ringbuf_submit
+	133: 2, // ringbuf_discard
+	134: 2, // ringbuf_query
+	158: 0, // get_current_task_btf
+	160: 0, // ktime_get_coarse_ns
+}
+
+// helperArgCounts is the table selected by SetHelperArgCounts, nil to match Merlin
+var helperArgCounts map[int32]int
+
+// SetHelperArgCounts selects the argument counts of the helpers without a fixed case in the analysis,
+// e.g. DefaultHelperArgCounts. Helpers missing from counts are assumed to read all of r1-r5,
+// which is what Merlin does for every one of them and what a nil table restores.
+func SetHelperArgCounts(counts map[int32]int) {
+	helperArgCounts = counts
+}
+
+// helperArgRegs returns the argument registers read by the helper with the given id
+func helperArgRegs(id int32) []int {
+	count, ok := helperArgCounts[id]
+	if !ok {
+		count = 5
+	}
+
+	regs := make([]int, count)
+	for i := range regs {
+		regs[i] = i + 1
+	}
+	return regs
+}
+
+// helperArgCountsKey identifies the selected table, so cached analyses made with another one are not reused
+func helperArgCountsKey() string {
+	ids := make([]int, 0, len(helperArgCounts))
+	for id := range helperArgCounts {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%d:%d", id, helperArgCounts[int32(id)])
+	}
+	return strings.Join(parts, ",")
+}
+
 // 计算BPF指令的size（以位为单位）
 func calculateSizeBits(opcode uint8) int {
 	sizeField := opcode & 0x18
@@ -114,6 +197,13 @@ func (a *InstructionAnalysis) LDX(opcode uint8, dst int, src int, off int16, imm
 	}
 }
 
+// isLegacyPacketLoad checks for ld_abs/ld_ind, which read the packet through the context in r6,
+// clobber r0-r5 and may end the program on an out-of-bounds access
+func isLegacyPacketLoad(inst *bpf.Instruction) bool {
+	mode := inst.Opcode & 0xE0
+	return inst.GetInstructionClass() == bpf.BPF_LD && (mode == bpf.BPF_ABS || mode == bpf.BPF_IND)
+}
+
 func (a *InstructionAnalysis) LD(opcode uint8, dst int, src int, off int16, imm int32) {
 	msb := opcode & 0xE0
 	switch msb {
@@ -156,6 +156,20 @@ func TestAnalyzeInstruction(t *testing.T) {
 			wantError: false,
 		},
 
+		{
+			name:   "ALU32 self move zero-extends",
+			hexStr: "bc11000000000000",
+			want: &InstructionAnalysis{
+				UpdatedReg:   1,
+				UpdatedStack: []int16{},
+				UsedReg:      []int{1},
+				UsedStack:    []int16{},
+				Offset:       0,
+				IsCall:       false,
+				IsExit:       false,
+			},
+			wantError: false,
+		},
 		{
 			name:   "JMP opcode 5",
 			hexStr: "05005d0000000000",
@@ -187,3 +201,40 @@ func TestAnalyzeInstruction(t *testing.T) {
 		})
 	}
 }
+
+func TestHelperArgCounts(t *testing.T) {
+	tests := []struct {
+		name        string
+		hexStr      string
+		wantDefault []int
+		wantTable   []int
+	}{
+		{name: "get_current_pid_tgid", hexStr: "850000000e000000", wantDefault: []int{1, 2, 3, 4, 5}, wantTable: []int{}},
+		{name: "get_current_comm", hexStr: "8500000010000000", wantDefault: []int{1, 2, 3, 4, 5}, wantTable: []int{1, 2}},
+		{name: "probe_read_kernel", hexStr: "8500000071000000", wantDefault: []int{1, 2, 3, 4, 5}, wantTable: []int{1, 2, 3}},
+		{name: "ringbuf_output", hexStr: "8500000082000000", wantDefault: []int{1, 2, 3, 4, 5}, wantTable: []int{1, 2, 3, 4}},
+		{name: "unknown helper", hexStr: "85000000e7030000", wantDefault: []int{1, 2, 3, 4, 5}, wantTable: []int{1, 2, 3, 4, 5}},
+		{name: "fixed case", hexStr: "8500000004000000", wantDefault: []int{1, 2, 3}, wantTable: []int{1, 2, 3}},
+	}
+
+	defer SetHelperArgCounts(nil)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inst, err := bpf.NewInstruction(tt.hexStr)
+			if err != nil {
+				t.Fatalf("NewInstruction() error = %v", err)
+			}
+
+			SetHelperArgCounts(nil)
+			if got := analyzeInstruction(inst).UsedReg; !equalIntSlice(got, tt.wantDefault) {
+				t.Errorf("without a table UsedReg = %v, want %v", got, tt.wantDefault)
+			}
+
+			SetHelperArgCounts(DefaultHelperArgCounts)
+			if got := analyzeInstruction(inst).UsedReg; !equalIntSlice(got, tt.wantTable) {
+				t.Errorf("with DefaultHelperArgCounts UsedReg = %v, want %v", got, tt.wantTable)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package optimizer
+
+import (
+	"sort"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// BranchCandidate is a conditional jump whose direction is known statically
+type BranchCandidate struct {
+	Index int  // the conditional jump
+	Taken bool // whether the jump is always taken
+}
+
+// findBranchFoldingCandidates finds conditional jumps comparing constants. A register only
+// counts as known when an immediate move to it sits in the straight-line code leading to the
+// jump with nothing in between that may change it, so no other path can bring in another value.
+// For a BPF_X compare the source register has to be known the same way.
+func (s *Section) findBranchFoldingCandidates() []BranchCandidate {
+	candidates := make([]BranchCandidate, 0)
+	blockStarts := s.blockStarts()
+
+	for i, inst := range s.Instructions {
+		if !isConditionalJump(inst) || inst.Offset == 0 {
+			continue
+		}
+
+		blockStart := s.straightLineStart(blockStarts, i)
+		dst, ok := s.knownRegisterValue(inst.DstReg, blockStart, i)
+		if !ok {
+			continue
+		}
+
+		// A 64-bit compare sign-extends its imm
+		src := uint64(int64(inst.Imm))
+		if inst.Opcode&bpf.BPF_X != 0 {
+			if src, ok = s.knownRegisterValue(inst.SrcReg, blockStart, i); !ok {
+				continue
+			}
+		}
+
+		taken, ok := evaluateBranch(inst.GetALUOp(), dst, src, inst.GetInstructionClass() == bpf.BPF_JMP32)
+		if !ok {
+			continue
+		}
+		if !s.AggressiveFold && s.guardsMemoryAccess(blockStarts, i) {
+			s.countBlocked(BlockedGuard)
+			continue
+		}
+		candidates = append(candidates, BranchCandidate{Index: i, Taken: taken})
+	}
+
+	return candidates
+}
+
+// applyBranchFolding turns always-taken conditional jumps into a goto and drops never-taken ones,
+// removing the dead edge from the control flow graph. Dependencies are left as they are:
+// they still cover every path, so later passes stay correct, just less aggressive.
+func (s *Section) applyBranchFolding() {
+	for _, candidate := range s.findBranchFoldingCandidates() {
+		if !s.inRange(candidate.Index) {
+			continue
+		}
+
+		idx := candidate.Index
+		target := idx + int(s.Instructions[idx].Offset) + 1
+		dead := target
+		if candidate.Taken {
+			s.Instructions[idx], _ = bpf.NewInstructionFromFields(bpf.BPF_JMP|bpf.JMP_A, 0, 0, s.Instructions[idx].Offset, 0)
+			dead = idx + 1
+		} else {
+			s.Instructions[idx].SetAsNOP()
+		}
+
+		if s.ControlFlowGraph != nil {
+			s.ControlFlowGraph.removeEdge(idx, dead)
+		}
+		s.countApplied()
+	}
+}
+
+// guardsMemoryAccess checks whether the conditional jump at i dominates a load or store through
+// a pointer other than r10. Such a jump may be the bounds check the verifier needs to accept
+// the access: even when the compare is constant here, the verifier may not track the value
+// as precisely. Blocks count as dominated once all their predecessors are, so a loop entered
+// behind the jump is missed; accesses before the jump in its own block are not guarded by it.
+func (s *Section) guardsMemoryAccess(blockStarts []int, i int) bool {
+	if s.ControlFlowGraph == nil {
+		return false
+	}
+
+	pos := sort.SearchInts(blockStarts, i+1) - 1
+	if pos < 0 {
+		return false
+	}
+	dominated := map[int]bool{blockStarts[pos]: true}
+
+	for grown := true; grown; {
+		grown = false
+		for n, start := range blockStarts {
+			preds := s.ControlFlowGraph.NodesRev[start]
+			if dominated[start] || len(preds) == 0 {
+				continue
+			}
+			all := true
+			for _, pred := range preds {
+				all = all && dominated[pred]
+			}
+			if !all {
+				continue
+			}
+
+			dominated[start] = true
+			grown = true
+
+			end := len(s.Instructions)
+			if n+1 < len(blockStarts) {
+				end = blockStarts[n+1]
+			}
+			for k := start; k < end; k++ {
+				if isPointerAccess(s.Instructions[k]) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// isPointerAccess checks whether inst loads or stores through a register other than the frame pointer
+func isPointerAccess(inst *bpf.Instruction) bool {
+	switch inst.GetInstructionClass() {
+	case bpf.BPF_LDX:
+		return inst.SrcReg != 10
+	case bpf.BPF_ST, bpf.BPF_STX:
+		return inst.DstReg != 10
+	}
+	return false
+}
+
+// straightLineStart returns the first instruction of the code that always runs right before i.
+// It walks back over block boundaries whose only predecessor is the block just before them;
+// a conditional jump is a block of its own, so this steps over the not-taken side of jumps.
+func (s *Section) straightLineStart(blockStarts []int, i int) int {
+	pos := sort.SearchInts(blockStarts, i+1) - 1
+	if pos < 0 {
+		return 0
+	}
+
+	for pos > 0 && s.ControlFlowGraph != nil {
+		preds := s.ControlFlowGraph.NodesRev[blockStarts[pos]]
+		if len(preds) != 1 || preds[0] != blockStarts[pos-1] {
+			break
+		}
+		pos--
+	}
+	return blockStarts[pos]
+}
+
+// knownRegisterValue returns the constant held by reg right before end, found by walking back
+// to start. It gives up at the first instruction that may change reg other than an immediate move.
+func (s *Section) knownRegisterValue(reg uint8, start, end int) (uint64, bool) {
+	for k := end - 1; k >= start; k-- {
+		inst := s.Instructions[k]
+		if inst.DstReg == reg && inst.Offset == 0 && !s.Relocations[k] && !s.CORERelocations[k] {
+			switch inst.Opcode {
+			case bpf.BPF_ALU64 | bpf.ALU_MOV | bpf.BPF_K:
+				return uint64(int64(inst.Imm)), true
+			case bpf.BPF_ALU | bpf.ALU_MOV | bpf.BPF_K:
+				return uint64(uint32(inst.Imm)), true
+			}
+		}
+
+		if !s.isRegisterPreserved(reg, k, k+1) {
+			return 0, false
+		}
+	}
+
+	return 0, false
+}
+
+// evaluateBranch reports whether a conditional jump with the given operation is taken.
+// JMP32 compares only the lower 32 bits. It returns false for operations it does not know.
+func evaluateBranch(op uint8, dst, src uint64, is32 bool) (bool, bool) {
+	sdst, ssrc := int64(dst), int64(src)
+	if is32 {
+		dst, src = uint64(uint32(dst)), uint64(uint32(src))
+		sdst, ssrc = int64(int32(dst)), int64(int32(src))
+	}
+
+	switch op {
+	case bpf.JMP_EQ:
+		return dst == src, true
+	case bpf.JMP_NE:
+		return dst != src, true
+	case bpf.JMP_SET:
+		return dst&src != 0, true
+	case bpf.JMP_GT:
+		return dst > src, true
+	case bpf.JMP_GE:
+		return dst >= src, true
+	case bpf.JMP_LT:
+		return dst < src, true
+	case bpf.JMP_LE:
+		return dst <= src, true
+	case bpf.JMP_SGT:
+		return sdst > ssrc, true
+	case bpf.JMP_SGE:
+		return sdst >= ssrc, true
+	case bpf.JMP_SLT:
+		return sdst < ssrc, true
+	case bpf.JMP_SLE:
+		return sdst <= ssrc, true
+	}
+
+	return false, false
+}
@@ -0,0 +1,190 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+func TestApplyBranchFolding(t *testing.T) {
+	tests := []struct {
+		name       string
+		hex        []string
+		expected   []string
+		dead       int          // successor of instruction 1 dropped from the CFG, -1 for none
+		reloc      map[int]bool // instructions patched by a CO-RE relocation
+		aggressive bool
+	}{
+		{
+			name: "provably taken",
+			hex: []string{
+				"b701000005000000", // r1 = 5
+				"1501020005000000", // if r1 == 5 goto +2
+				"b700000001000000", // r0 = 1
+				"9500000000000000", // exit
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+			expected: []string{"b701000005000000", "0500020000000000"},
+			dead:     2,
+		},
+		{
+			name: "provably not taken",
+			hex: []string{
+				"b701000005000000", // r1 = 5
+				"2501020009000000", // if r1 > 9 goto +2
+				"b700000001000000", // r0 = 1
+				"9500000000000000", // exit
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+			expected: []string{"b701000005000000", bpf.NOP},
+			dead:     4,
+		},
+		{
+			name: "signed compare against register",
+			hex: []string{
+				"b7010000ffffffff", // r1 = -1
+				"b702000000000000", // r2 = 0
+				"cd21020000000000", // if r1 s< r2 goto +2
+				"b700000001000000", // r0 = 1
+				"9500000000000000", // exit
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+			expected: []string{"b7010000ffffffff", "b702000000000000", "0500020000000000"},
+			dead:     -1,
+		},
+		{
+			name: "32-bit compare ignores the upper half",
+			hex: []string{
+				"b7010000ffffffff", // r1 = -1
+				"1601020000000000", // if w1 == 0 goto +2
+				"b700000001000000", // r0 = 1
+				"9500000000000000", // exit
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+			expected: []string{"b7010000ffffffff", bpf.NOP},
+			dead:     4,
+		},
+		{
+			name: "register from the context is not known",
+			hex: []string{
+				"1501020005000000", // if r1 == 5 goto +2
+				"b700000001000000", // r0 = 1
+				"9500000000000000", // exit
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+			expected: []string{"1501020005000000"},
+			dead:     -1,
+		},
+		{
+			name: "value defined in another block is not known",
+			hex: []string{
+				"b701000005000000", // r1 = 5
+				"1502010000000000", // if r2 == 0 goto +1
+				"b701000007000000", // r1 = 7
+				"1501020005000000", // if r1 == 5 goto +2
+				"b700000001000000", // r0 = 1
+				"9500000000000000", // exit
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+			expected: []string{"b701000005000000", "1502010000000000", "b701000007000000", "1501020005000000"},
+			dead:     -1,
+		},
+		{
+			name: "constant patched by a CO-RE relocation is not known",
+			hex: []string{
+				"b701000001000000", // r1 = 1 (field exists)
+				"1501020000000000", // if r1 == 0 goto +2
+				"b700000001000000", // r0 = 1
+				"9500000000000000", // exit
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+			expected: []string{"b701000001000000", "1501020000000000"},
+			dead:     -1,
+			reloc:    map[int]bool{0: true},
+		},
+		{
+			name: "helper call clobbers the constant",
+			hex: []string{
+				"b701000005000000", // r1 = 5
+				"8500000001000000", // call 1
+				"1501020005000000", // if r1 == 5 goto +2
+				"b700000001000000", // r0 = 1
+				"9500000000000000", // exit
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+			expected: []string{"b701000005000000", "8500000001000000", "1501020005000000"},
+			dead:     -1,
+		},
+		{
+			name: "bounds check guarding a load is kept",
+			hex: []string{
+				"b702000008000000", // r2 = 8
+				"b703000004000000", // r3 = 4
+				"2d23030000000000", // if r3 > r2 goto +3
+				"7110000000000000", // r0 = *(u8 *)(r1 + 0)
+				"9500000000000000", // exit
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+			expected: []string{"b702000008000000", "b703000004000000", "2d23030000000000"},
+			dead:     -1,
+		},
+		{
+			name: "bounds check guarding a load folds when aggressive",
+			hex: []string{
+				"b702000008000000", // r2 = 8
+				"b703000004000000", // r3 = 4
+				"2d23030000000000", // if r3 > r2 goto +3
+				"7110000000000000", // r0 = *(u8 *)(r1 + 0)
+				"9500000000000000", // exit
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+			expected:   []string{"b702000008000000", "b703000004000000", bpf.NOP},
+			dead:       -1,
+			aggressive: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section, err := NewSection(strings.Join(tt.hex, ""), "test", true)
+			if err != nil {
+				t.Fatalf("NewSection() error = %v", err)
+			}
+			section.CORERelocations = tt.reloc
+			section.AggressiveFold = tt.aggressive
+
+			section.applyBranchFolding()
+
+			for i, want := range tt.expected {
+				if got := section.Instructions[i].Raw; got != want {
+					t.Errorf("instruction %d = %s, expected %s", i, got, want)
+				}
+			}
+
+			if tt.dead >= 0 {
+				for _, succ := range section.ControlFlowGraph.Nodes[1] {
+					if succ == tt.dead {
+						t.Errorf("CFG still has the edge 1 -> %d: %v", tt.dead, section.ControlFlowGraph.Nodes[1])
+					}
+				}
+				if len(section.ControlFlowGraph.Nodes[1]) != 1 {
+					t.Errorf("CFG successors of 1 = %v, expected one", section.ControlFlowGraph.Nodes[1])
+				}
+			}
+			if err := section.Verify(); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+		})
+	}
+}
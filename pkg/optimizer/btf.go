@@ -0,0 +1,156 @@
+package optimizer
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// btfMagic starts both the .BTF and the .BTF.ext headers
+const btfMagic = 0xeb9f
+
+// btfSectionNames are the sections carrying BTF. Save patches instructions in place,
+// so they must reach the output byte for byte.
+var btfSectionNames = []string{".BTF", ".BTF.ext"}
+
+// sectionContents maps a section name to its bytes in the original file
+type sectionContents map[string]sectionBytes
+
+type sectionBytes struct {
+	offset uint64
+	data   []byte
+}
+
+// btfSnapshot copies the BTF sections out of the ELF file contents
+func btfSnapshot(data []byte, elfFile *elf.File) sectionContents {
+	return snapshotSections(data, elfFile, btfSectionNames)
+}
+
+// snapshotSections copies the named sections that have file contents out of data
+func snapshotSections(data []byte, elfFile *elf.File, names []string) sectionContents {
+	snapshot := make(sectionContents)
+	for _, name := range names {
+		section := elfFile.Section(name)
+		if section == nil || section.Type == elf.SHT_NOBITS || section.Offset+section.Size > uint64(len(data)) {
+			continue
+		}
+		snapshot[name] = sectionBytes{
+			offset: section.Offset,
+			data:   append([]byte(nil), data[section.Offset:section.Offset+section.Size]...),
+		}
+	}
+	return snapshot
+}
+
+// verify checks that the sections in data still hold the snapshotted bytes
+func (snapshot sectionContents) verify(data []byte) error {
+	for name, r := range snapshot {
+		if !bytes.Equal(data[r.offset:r.offset+uint64(len(r.data))], r.data) {
+			return fmt.Errorf("section %s was modified while writing the optimized instructions", name)
+		}
+	}
+	return nil
+}
+
+// parseCORERelocations returns, per code section name, the byte offsets of the instructions
+// patched by CO-RE relocations in .BTF.ext. The loader rewrites their imm or offset
+// (field offsets and sizes, field/type/enum existence), so the constants the instructions
+// hold in the object are not the ones that run. An object without CO-RE relocations gives nil.
+func parseCORERelocations(elfFile *elf.File) (map[string][]uint64, error) {
+	btfSection, extSection := elfFile.Section(".BTF"), elfFile.Section(".BTF.ext")
+	if btfSection == nil || extSection == nil {
+		return nil, nil
+	}
+
+	btf, err := btfSection.Data()
+	if err != nil {
+		return nil, err
+	}
+	ext, err := extSection.Data()
+	if err != nil {
+		return nil, err
+	}
+	order := elfFile.ByteOrder
+
+	// btf_ext_header: magic, version, flags, hdr_len, func_info_off/len, line_info_off/len,
+	// then core_relo_off/len when hdr_len covers them
+	if len(ext) < 8 || order.Uint16(ext) != btfMagic {
+		return nil, fmt.Errorf(".BTF.ext has no valid header")
+	}
+	hdrLen := uint64(order.Uint32(ext[4:]))
+	if hdrLen < 32 || uint64(len(ext)) < hdrLen {
+		return nil, nil
+	}
+	start := hdrLen + uint64(order.Uint32(ext[24:]))
+	end := start + uint64(order.Uint32(ext[28:]))
+	if start == end {
+		return nil, nil
+	}
+	if end > uint64(len(ext)) || end-start < 4 {
+		return nil, fmt.Errorf(".BTF.ext CO-RE relocations [%d, %d) are out of bounds", start, end)
+	}
+
+	// btf_header: magic, version, flags, hdr_len, type_off/len, str_off/len
+	if len(btf) < 24 || order.Uint16(btf) != btfMagic {
+		return nil, fmt.Errorf(".BTF has no valid header")
+	}
+	strStart := uint64(order.Uint32(btf[4:])) + uint64(order.Uint32(btf[16:]))
+	strEnd := strStart + uint64(order.Uint32(btf[20:]))
+	if strEnd > uint64(len(btf)) {
+		return nil, fmt.Errorf(".BTF strings [%d, %d) are out of bounds", strStart, strEnd)
+	}
+	strs := btf[strStart:strEnd]
+
+	// rec_size, then per section: sec_name_off, num_info and num_info records starting with insn_off
+	data := ext[start:end]
+	recSize := uint64(order.Uint32(data))
+	if recSize < 4 {
+		return nil, fmt.Errorf(".BTF.ext CO-RE record size %d is too small", recSize)
+	}
+
+	relocations := make(map[string][]uint64)
+	for pos := uint64(4); pos < uint64(len(data)); {
+		if pos+8 > uint64(len(data)) {
+			return nil, fmt.Errorf(".BTF.ext CO-RE section header at %d is truncated", pos)
+		}
+		nameOff := uint64(order.Uint32(data[pos:]))
+		numInfo := uint64(order.Uint32(data[pos+4:]))
+		pos += 8
+
+		if nameOff >= uint64(len(strs)) || pos+numInfo*recSize > uint64(len(data)) {
+			return nil, fmt.Errorf(".BTF.ext CO-RE section at %d is out of bounds", pos-8)
+		}
+		name := cString(strs[nameOff:])
+
+		for i := uint64(0); i < numInfo; i++ {
+			relocations[name] = append(relocations[name], uint64(order.Uint32(data[pos:])))
+			pos += recSize
+		}
+	}
+
+	return relocations, nil
+}
+
+// coreRelocatedInstructions returns the indices, relative to offset, of the instructions
+// of the ELF section name patched by a CO-RE relocation
+func (prog *BPFProgram) coreRelocatedInstructions(name string, offset, size uint64) map[int]bool {
+	relocated := make(map[int]bool)
+	for _, relOffset := range prog.coreRelocations[name] {
+		if relOffset >= offset && relOffset < offset+size {
+			relocated[int((relOffset-offset)/bpf.InstructionSize)] = true
+		}
+	}
+	return relocated
+}
+
+// cString returns the NUL-terminated string at the start of data
+func cString(data []byte) string {
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i])
+		}
+	}
+	return string(data)
+}
@@ -0,0 +1,86 @@
+package optimizer
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCORERelocations(t *testing.T) {
+	elfFile, err := elf.Open(testObjectFile)
+	if err != nil {
+		t.Fatalf("elf.Open() error = %v", err)
+	}
+	defer elfFile.Close()
+
+	relocations, err := parseCORERelocations(elfFile)
+	if err != nil {
+		t.Fatalf("parseCORERelocations() error = %v", err)
+	}
+
+	wantCounts := map[string]int{".text": 6, "uprobe": 245, "uprobe/generic_uprobe": 53}
+	for name, want := range wantCounts {
+		if got := len(relocations[name]); got != want {
+			t.Errorf("section %s has %d CO-RE relocations, expected %d", name, got, want)
+		}
+	}
+
+	// r1 = sizeof field (FIELD_BYTE_SIZE) and r1 = field exists (FIELD_EXISTS)
+	prog, err := NewBPFProgramWithOptions(testObjectFile, ProgramOptions{SkipOptimization: true})
+	if err != nil {
+		t.Fatalf("NewBPFProgramWithOptions() error = %v", err)
+	}
+	defer prog.Close()
+
+	for _, idx := range []int{1237, 1325} {
+		if !prog.Sections["uprobe"].CORERelocations[idx] {
+			t.Errorf("instruction %d of uprobe is not marked as relocated", idx)
+		}
+	}
+}
+
+func TestSavePreservesBTF(t *testing.T) {
+	prog, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	outputPath := filepath.Join(t.TempDir(), "out.o")
+	if err := prog.Save(outputPath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	for _, name := range []string{".BTF", ".BTF.ext", ".rel.BTF", ".rel.BTF.ext"} {
+		want := readSectionData(t, testObjectFile, name)
+		if got := readSectionData(t, outputPath, name); !bytes.Equal(got, want) {
+			t.Errorf("section %s changed in Save() output", name)
+		}
+	}
+}
+
+func TestBTFSnapshotDetectsChanges(t *testing.T) {
+	data, err := os.ReadFile(testObjectFile)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	elfFile, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("elf.NewFile() error = %v", err)
+	}
+
+	snapshot := btfSnapshot(data, elfFile)
+	if len(snapshot) != len(btfSectionNames) {
+		t.Fatalf("btfSnapshot() found %d sections, expected %d", len(snapshot), len(btfSectionNames))
+	}
+	if err := snapshot.verify(data); err != nil {
+		t.Errorf("verify() on unchanged data error = %v", err)
+	}
+
+	data[elfFile.Section(".BTF.ext").Offset] ^= 0xff
+	if err := snapshot.verify(data); err == nil {
+		t.Errorf("verify() accepted a modified .BTF.ext")
+	}
+}
@@ -0,0 +1,141 @@
+package optimizer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// dependencyCacheVersion is bumped whenever the analysis or the cached format changes,
+// so entries written by another version are recomputed
+const dependencyCacheVersion = 2
+
+// controlFlowGraphBuilds counts buildControlFlowGraph calls, letting tests observe cache hits
+var controlFlowGraphBuilds atomic.Int64
+
+// dependencyCacheEntry is the on-disk form of a section's dependency analysis.
+// NodeStats is only needed while the graph is built and is not stored.
+type dependencyCacheEntry struct {
+	Version      int              `json:"version"`
+	Hash         string           `json:"hash"`
+	Helpers      string           `json:"helpers,omitempty"` // helperArgCountsKey the analysis was made with
+	Functions    []int            `json:"functions"`         // FunctionStarts the entry points were seeded from, null without symbols
+	NOPEncoding  string           `json:"nop_encoding"`      // bpf.NOPEncoding, whose fillers the analysis skips
+	Nodes        map[int][]int    `json:"nodes"`
+	NodesRev     map[int][]int    `json:"nodes_rev"`
+	NodesLen     map[int]int      `json:"nodes_len"`
+	Calls        map[int]int      `json:"calls"`
+	EntryPoints  []int            `json:"entry_points"`
+	Dependencies []DependencyInfo `json:"dependencies"`
+}
+
+// sectionHash returns the sha256 of the instruction bytes, used as the cache key
+func sectionHash(hexData string) string {
+	sum := sha256.Sum256([]byte(hexData))
+	return hex.EncodeToString(sum[:])
+}
+
+// Hash returns the sha256 of the current instruction bytes, trailing data excluded.
+// Taken before optimization it is the key the dependency cache stores the section under.
+func (s *Section) Hash() string {
+	var b strings.Builder
+	for _, inst := range s.Instructions {
+		b.WriteString(inst.Raw)
+	}
+	return sectionHash(b.String())
+}
+
+// Hash returns the sha256 over the name and Hash of every section, ordered by name
+func (prog *BPFProgram) Hash() string {
+	names := make([]string, 0, len(prog.Sections))
+	for name := range prog.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name + "\x00" + prog.Sections[name].Hash() + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// loadDependencyCache restores the dependency analysis cached under dir for hash.
+// It reports false on a miss, a version bump, another helper table, other function symbols,
+// another NOP encoding or an entry that does not fit the section.
+func (s *Section) loadDependencyCache(dir, hash string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, hash+".json"))
+	if err != nil {
+		return false
+	}
+
+	var entry dependencyCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return false
+	}
+	if entry.Version != dependencyCacheVersion || entry.Hash != hash || entry.Helpers != helperArgCountsKey() ||
+		!reflect.DeepEqual(entry.Functions, s.FunctionStarts) || entry.NOPEncoding != bpf.NOPEncoding() ||
+		len(entry.Dependencies) != len(s.Instructions) {
+		return false
+	}
+
+	s.ControlFlowGraph = &ControlFlowGraph{
+		Nodes:     entry.Nodes,
+		NodesRev:  entry.NodesRev,
+		NodesLen:  entry.NodesLen,
+		NodeStats: make(map[int]*RegisterState),
+		Calls:     entry.Calls,
+	}
+	s.EntryPoints = entry.EntryPoints
+	s.Dependencies = entry.Dependencies
+	return true
+}
+
+// saveDependencyCache stores the dependency analysis under dir for hash.
+// The entry is written to a temporary file first so a concurrent reader never sees half of it.
+func (s *Section) saveDependencyCache(dir, hash string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	cfg := s.ControlFlowGraph
+	data, err := json.Marshal(dependencyCacheEntry{
+		Version:      dependencyCacheVersion,
+		Hash:         hash,
+		Helpers:      helperArgCountsKey(),
+		Functions:    s.FunctionStarts,
+		NOPEncoding:  bpf.NOPEncoding(),
+		Nodes:        cfg.Nodes,
+		NodesRev:     cfg.NodesRev,
+		NodesLen:     cfg.NodesLen,
+		Calls:        cfg.Calls,
+		EntryPoints:  s.EntryPoints,
+		Dependencies: s.Dependencies,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, hash+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, hash+".json"))
+}
@@ -0,0 +1,175 @@
+package optimizer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+func TestDependencyCacheHit(t *testing.T) {
+	hexData, err := os.ReadFile("../../testdata/section_data")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	data := strings.TrimSpace(string(hexData))
+	opts := SectionOptions{CacheDir: t.TempDir()}
+
+	want, err := NewSectionWithOptions(data, ".text", opts)
+	if err != nil {
+		t.Fatalf("NewSectionWithOptions() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(opts.CacheDir, sectionHash(data)+".json")); err != nil {
+		t.Fatalf("Expected a cache entry after the first build: %v", err)
+	}
+
+	builds := controlFlowGraphBuilds.Load()
+	got, err := NewSectionWithOptions(data, ".text", opts)
+	if err != nil {
+		t.Fatalf("NewSectionWithOptions() error = %v", err)
+	}
+	if n := controlFlowGraphBuilds.Load() - builds; n != 0 {
+		t.Errorf("Expected a cache hit to skip buildControlFlowGraph, it ran %d times", n)
+	}
+
+	if !reflect.DeepEqual(got.Dependencies, want.Dependencies) {
+		t.Errorf("Cached dependencies differ from the computed ones")
+	}
+	if !reflect.DeepEqual(got.EntryPoints, want.EntryPoints) {
+		t.Errorf("Cached entry points = %v, expected %v", got.EntryPoints, want.EntryPoints)
+	}
+	if !reflect.DeepEqual(got.ControlFlowGraph.Nodes, want.ControlFlowGraph.Nodes) ||
+		!reflect.DeepEqual(got.ControlFlowGraph.NodesRev, want.ControlFlowGraph.NodesRev) ||
+		!reflect.DeepEqual(got.ControlFlowGraph.NodesLen, want.ControlFlowGraph.NodesLen) {
+		t.Errorf("Cached control flow graph differs from the computed one")
+	}
+	if !bytes.Equal(got.Dump(), want.Dump()) {
+		t.Errorf("Optimizing with cached dependencies gave different instructions")
+	}
+}
+
+func TestDependencyCacheInvalidation(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000000000000", // r1 = 0
+		"bf10000000000000", // r0 = r1
+		"9500000000000000", // exit
+	}, "")
+
+	tests := []struct {
+		name   string
+		modify func(entry *dependencyCacheEntry)
+	}{
+		{
+			name:   "format version bump",
+			modify: func(entry *dependencyCacheEntry) { entry.Version = dependencyCacheVersion - 1 },
+		},
+		{
+			name:   "hash mismatch",
+			modify: func(entry *dependencyCacheEntry) { entry.Hash = sectionHash("") },
+		},
+		{
+			name:   "helper table mismatch",
+			modify: func(entry *dependencyCacheEntry) { entry.Helpers = "14:0" },
+		},
+		{
+			name:   "NOP encoding mismatch",
+			modify: func(entry *dependencyCacheEntry) { entry.NOPEncoding = bpf.NOPMov },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := SectionOptions{SkipOptimization: true, CacheDir: t.TempDir()}
+			if _, err := NewSectionWithOptions(hexData, "test", opts); err != nil {
+				t.Fatalf("NewSectionWithOptions() error = %v", err)
+			}
+
+			path := filepath.Join(opts.CacheDir, sectionHash(hexData)+".json")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			var entry dependencyCacheEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			tt.modify(&entry)
+			data, _ = json.Marshal(entry)
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			builds := controlFlowGraphBuilds.Load()
+			section, err := NewSectionWithOptions(hexData, "test", opts)
+			if err != nil {
+				t.Fatalf("NewSectionWithOptions() error = %v", err)
+			}
+			if n := controlFlowGraphBuilds.Load() - builds; n != 1 {
+				t.Errorf("Expected a stale entry to be recomputed, buildControlFlowGraph ran %d times", n)
+			}
+			if !section.FoundDependency(1, 0) {
+				t.Errorf("Expected instruction 1 to depend on 0, got %v", section.Dependencies[1])
+			}
+		})
+	}
+}
+
+func TestSectionHash(t *testing.T) {
+	hexData, err := os.ReadFile("../../testdata/section_data")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	section, err := NewSection(string(hexData), ".text", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	hash := section.Hash()
+	if hash != sectionHash(string(hexData)) {
+		t.Errorf("Hash() = %s, want the dependency cache key %s", hash, sectionHash(string(hexData)))
+	}
+
+	// Rebuilding the section from its dump must give the same hash
+	reloaded, err := NewSection(hex.EncodeToString(section.Dump()), ".text", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	if got := reloaded.Hash(); got != hash {
+		t.Errorf("Hash() after Dump = %s, want %s", got, hash)
+	}
+
+	reloaded.Instructions[0].SetAsNOP()
+	if reloaded.Hash() == hash {
+		t.Errorf("Hash() did not change after rewriting instruction 0")
+	}
+}
+
+func TestProgramHash(t *testing.T) {
+	first, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer first.Close()
+
+	second, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer second.Close()
+
+	if first.Hash() != second.Hash() {
+		t.Fatalf("Hash() differs between two runs: %s, %s", first.Hash(), second.Hash())
+	}
+
+	second.Sections["uprobe"].Instructions[0].SetAsNOP()
+	if first.Hash() == second.Hash() {
+		t.Errorf("Hash() did not change after rewriting an instruction of uprobe")
+	}
+}
@@ -0,0 +1,126 @@
+package optimizer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Reasons a pass blocks a candidate it found, reported in CandidateStats
+const (
+	BlockedBarrier        = "intervening jump or load"  // superword: a jump, load or ld_abs/ld_ind between the stores
+	BlockedNotConsecutive = "non-consecutive"           // superword: stores to the same base leave a gap
+	BlockedMisaligned     = "misaligned"                // superword: the merged store would not be aligned to its size
+	BlockedOverflow       = "overflow"                  // superword: the merged immediate does not fit in imm
+	BlockedSignExtension  = "sign extension"            // a 64-bit store would sign-extend a negative imm
+	BlockedMultipleDeps   = "multiple deps"             // const-prop: a store reads a register some other instruction may define
+	BlockedAtomic         = "atomic"                    // const-prop: the value is used by an atomic operation
+	BlockedPacketLoad     = "ld_abs/ld_ind in between"  // const-prop: a legacy packet load may end the program first
+	BlockedClobbered      = "register clobbered"        // lddw-dedup, map-dedup: the register holding the value changed since
+	BlockedRelocation     = "relocation or pseudo-load" // dead-code: the block holds an instruction the loader patches
+	BlockedGuard          = "guards memory access"      // branch-fold: the jump may be a bounds check the verifier relies on
+)
+
+// CandidateStats counts the candidates of a pass: those applied and those a safety guard
+// blocked, by reason. Candidates outside the section Range count for neither.
+type CandidateStats struct {
+	Applied int            `json:"applied"`
+	Blocked map[string]int `json:"blocked"`
+}
+
+// Found returns the number of candidates applied or blocked
+func (c *CandidateStats) Found() int {
+	found := c.Applied
+	for _, count := range c.Blocked {
+		found += count
+	}
+	return found
+}
+
+// BlockedCount returns the number of blocked candidates
+func (c *CandidateStats) BlockedCount() int {
+	return c.Found() - c.Applied
+}
+
+// Add adds the counts of other
+func (c *CandidateStats) Add(other *CandidateStats) {
+	if c.Blocked == nil {
+		c.Blocked = make(map[string]int)
+	}
+
+	c.Applied += other.Applied
+	for reason, count := range other.Blocked {
+		c.Blocked[reason] += count
+	}
+}
+
+// String summarizes the counts, e.g. "12 candidates, 4 applied, 8 blocked (5 intervening jump
+// or load, 3 non-consecutive)", the most frequent reason first
+func (c *CandidateStats) String() string {
+	s := fmt.Sprintf("%d candidates, %d applied, %d blocked", c.Found(), c.Applied, c.BlockedCount())
+	if len(c.Blocked) == 0 {
+		return s
+	}
+
+	reasons := make([]string, 0, len(c.Blocked))
+	for reason := range c.Blocked {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if c.Blocked[reasons[i]] != c.Blocked[reasons[j]] {
+			return c.Blocked[reasons[i]] > c.Blocked[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+
+	parts := make([]string, len(reasons))
+	for i, reason := range reasons {
+		parts[i] = fmt.Sprintf("%d %s", c.Blocked[reason], reason)
+	}
+	return s + " (" + strings.Join(parts, ", ") + ")"
+}
+
+// PassCandidates maps pass names to their candidate counts
+type PassCandidates map[string]*CandidateStats
+
+// Add adds the counts of other, pass by pass
+func (p PassCandidates) Add(other PassCandidates) {
+	for pass, stats := range other {
+		if _, ok := p[pass]; !ok {
+			p[pass] = &CandidateStats{Blocked: make(map[string]int)}
+		}
+		p[pass].Add(stats)
+	}
+}
+
+// candidateStats returns the counts of the pass ApplyPass is running, nil outside ApplyPass
+// so that finding candidates for a report counts nothing
+func (s *Section) candidateStats() *CandidateStats {
+	if s.activePass == "" {
+		return nil
+	}
+
+	if s.Candidates == nil {
+		s.Candidates = make(PassCandidates)
+	}
+	stats, ok := s.Candidates[s.activePass]
+	if !ok {
+		stats = &CandidateStats{Blocked: make(map[string]int)}
+		s.Candidates[s.activePass] = stats
+	}
+	return stats
+}
+
+// countApplied counts a candidate the running pass applied
+func (s *Section) countApplied() {
+	if stats := s.candidateStats(); stats != nil {
+		stats.Applied++
+	}
+}
+
+// countBlocked counts a candidate the running pass rejected for reason
+func (s *Section) countBlocked(reason string) {
+	if stats := s.candidateStats(); stats != nil {
+		stats.Blocked[reason]++
+	}
+}
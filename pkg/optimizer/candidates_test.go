@@ -0,0 +1,158 @@
+package optimizer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSectionCandidates(t *testing.T) {
+	prog, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	tests := []struct {
+		section string
+		pass    string
+		want    CandidateStats
+	}{
+		{".text", PassConstantPropagation, CandidateStats{Applied: 9, Blocked: map[string]int{BlockedMultipleDeps: 5}}},
+		{".text", PassSuperword, CandidateStats{Applied: 0, Blocked: map[string]int{BlockedBarrier: 2}}},
+		{"uprobe", PassConstantPropagation, CandidateStats{Applied: 78, Blocked: map[string]int{BlockedMultipleDeps: 15, BlockedAtomic: 1}}},
+		{"uprobe", PassSuperword, CandidateStats{Applied: 21, Blocked: map[string]int{BlockedNotConsecutive: 8, BlockedBarrier: 5}}},
+	}
+
+	for _, tt := range tests {
+		got, ok := prog.Sections[tt.section].Candidates[tt.pass]
+		if !ok {
+			t.Errorf("%s: no candidates recorded for %s", tt.section, tt.pass)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s %s candidates = %s, want %s", tt.section, tt.pass, got, &tt.want)
+		}
+	}
+
+	summary := prog.GetOptimizationStats()["summary"].(map[string]interface{})
+	total := summary["candidates"].(PassCandidates)[PassSuperword]
+	if want := "36 candidates, 21 applied, 15 blocked (8 non-consecutive, 7 intervening jump or load)"; total.String() != want {
+		t.Errorf("superword summary = %q, want %q", total, want)
+	}
+}
+
+func TestFindOpportunitiesCountsNoCandidates(t *testing.T) {
+	section := createTestSection([]string{
+		"b701000001000000", // r1 = 1
+		"7b1af8ff00000000", // *(u64 *)(r10 - 8) = r1
+		"9500000000000000", // exit
+	})
+	section.FindOpportunities()
+	if section.Candidates != nil {
+		t.Errorf("FindOpportunities() recorded candidates %v", section.Candidates)
+	}
+
+	section.ApplyPass(PassConstantPropagation)
+	if got := section.Candidates[PassConstantPropagation]; got == nil || got.Applied != 1 {
+		t.Errorf("const-prop candidates = %v, want 1 applied", got)
+	}
+}
+
+func TestPassesCountApplied(t *testing.T) {
+	tests := []struct {
+		name string
+		pass string
+		hex  []string
+	}{
+		{"const-prop", PassConstantPropagation, []string{
+			"b701000001000000", // r1 = 1
+			"7b1af8ff00000000", // *(u64 *)(r10 - 8) = r1
+			"9500000000000000", // exit
+		}},
+		{"compaction", PassCompaction, []string{
+			"6701000020000000", // r1 <<= 32
+			"7701000020000000", // r1 >>= 32
+			"9500000000000000", // exit
+		}},
+		{"mask", PassPeephole, []string{
+			"b702000005000000", "bf20000000000000", // r2 = 5; r0 = r2
+			"18010000ffffffff", "0000000000000000", // r1 = 0xffffffff ll
+			"5f10000000000000", // r0 &= r1
+			"7700000008000000", // r0 >>= 8
+			"9500000000000000", // exit
+		}},
+		{"all-ones mask", PassPeephole, []string{
+			"54010000ffffffff", // w1 &= 0xffffffff
+			"9500000000000000", // exit
+		}},
+		{"zero-extension", PassPeephole, []string{
+			"0401000001000000", // w1 += 1
+			"bc11000000000000", // w1 = w1
+			"9500000000000000", // exit
+		}},
+		{"no-op jump", PassPeephole, []string{
+			"1501000000000000", // if r1 == 0 goto +0
+			"9500000000000000", // exit
+		}},
+		{"lddw-dedup", PassLoadImm64Dedup, []string{
+			"1801000088776655", "0000000044332211", // r1 = 0x1122334455667788 ll
+			"1802000088776655", "0000000044332211", // r2 = 0x1122334455667788 ll
+			"bf10000000000000", // r0 = r1
+			"0f20000000000000", // r0 += r2
+			"9500000000000000", // exit
+		}},
+		{"branch-fold", PassBranchFolding, []string{
+			"b701000000000000", // r1 = 0
+			"1501010000000000", // if r1 == 0 goto +1
+			"b700000001000000", // r0 = 1
+			"9500000000000000", // exit
+		}},
+		{"superword", PassSuperword, []string{
+			"720af8ff01000000", // *(u8 *)(r10 - 8) = 1
+			"720af9ff02000000", // *(u8 *)(r10 - 7) = 2
+			"9500000000000000", // exit
+		}},
+		{"dead-code", PassDeadCode, []string{
+			"b700000000000000", // r0 = 0
+			"9500000000000000", // exit
+			"b700000001000000", // r0 = 1, unreachable
+			"9500000000000000", // exit
+		}},
+		{"dead-def", PassRedundantDef, []string{
+			"b701000001000000", // r1 = 1, overwritten
+			"b701000002000000", // r1 = 2
+			"bf10000000000000", // r0 = r1
+			"9500000000000000", // exit
+		}},
+		{"self-move", PassSelfMove, []string{
+			"bf11000000000000", // r1 = r1
+			"9500000000000000", // exit
+		}},
+	}
+
+	passes := make(map[string]bool)
+	for _, tt := range tests {
+		passes[tt.pass] = true
+
+		section, err := NewSectionWithOptions(strings.Join(tt.hex, ""), "test", SectionOptions{SkipOptimization: true, FunctionStarts: []int{0}})
+		if err != nil {
+			t.Fatalf("%s: NewSectionWithOptions() error = %v", tt.name, err)
+		}
+		if tt.pass == PassSuperword {
+			section.FindStoreCandidates()
+		}
+		if err := section.ApplyPass(tt.pass); err != nil {
+			t.Fatalf("%s: ApplyPass() error = %v", tt.name, err)
+		}
+		if got := section.Candidates[tt.pass]; got == nil || got.Applied == 0 {
+			t.Errorf("%s: %s candidates = %v, want some applied", tt.name, tt.pass, got)
+		}
+	}
+
+	for _, pass := range defaultPassOrder {
+		if !passes[pass] {
+			t.Errorf("no case for default pass %s", pass)
+		}
+	}
+}
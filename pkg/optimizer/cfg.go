@@ -0,0 +1,60 @@
+package optimizer
+
+import "sort"
+
+// CFGSummary describes the shape of a section's control flow graph
+type CFGSummary struct {
+	Blocks      int   // basic blocks
+	Edges       int   // edges between basic blocks, jumps and fall-through
+	Loops       []int // sorted loop heads
+	Unreachable []int // sorted blocks no entry point reaches
+}
+
+// SummarizeCFG counts the blocks, edges, loops and unreachable blocks of the section.
+// Every function entry point is a root, so subprograms are not reported as unreachable.
+func (s *Section) SummarizeCFG() CFGSummary {
+	if s.ControlFlowGraph == nil {
+		return CFGSummary{Loops: []int{}, Unreachable: []int{}}
+	}
+
+	entries := s.EntryPoints
+	if len(entries) == 0 {
+		entries = []int{0}
+	}
+	return s.summarizeCFG(s.ControlFlowGraph, entries)
+}
+
+func (s *Section) summarizeCFG(cfg *ControlFlowGraph, entries []int) CFGSummary {
+	summary := CFGSummary{
+		Blocks: len(cfg.NodesLen),
+		Loops:  s.loopHeads(cfg),
+	}
+
+	// A block ending in a conditional jump points at the jump instruction, which holds the edges
+	for node := range cfg.NodesLen {
+		for _, succ := range cfg.Nodes[node] {
+			if _, isBlock := cfg.NodesLen[succ]; isBlock {
+				summary.Edges++
+			} else {
+				summary.Edges += len(cfg.Nodes[succ])
+			}
+		}
+	}
+
+	reachable := make(map[int]bool)
+	for _, entry := range entries {
+		for block := range cfg.ReachableBlocks(entry) {
+			reachable[block] = true
+		}
+	}
+
+	summary.Unreachable = make([]int, 0)
+	for node := range cfg.NodesLen {
+		if !reachable[node] {
+			summary.Unreachable = append(summary.Unreachable, node)
+		}
+	}
+	sort.Ints(summary.Unreachable)
+
+	return summary
+}
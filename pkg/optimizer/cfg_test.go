@@ -0,0 +1,29 @@
+package optimizer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSummarizeCFG(t *testing.T) {
+	section := &Section{Name: "test"}
+
+	got := section.summarizeCFG(buildTestControlFlowGraph(), []int{0})
+	want := CFGSummary{Blocks: 8, Edges: 10, Loops: []int{}, Unreachable: []int{}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summarizeCFG() = %+v, expected %+v", got, want)
+	}
+
+	// Turn the end of block 32 into a conditional jump back to 12 and add a block nothing jumps to
+	cfg := buildTestControlFlowGraph()
+	cfg.Nodes[32] = []int{34}
+	cfg.Nodes[34] = []int{12, 35}
+	cfg.Nodes[36] = []int{35}
+	cfg.NodesLen[36] = 2
+
+	got = section.summarizeCFG(cfg, []int{0})
+	want = CFGSummary{Blocks: 9, Edges: 12, Loops: []int{12}, Unreachable: []int{36}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summarizeCFG() = %+v, expected %+v", got, want)
+	}
+}
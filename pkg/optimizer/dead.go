@@ -0,0 +1,89 @@
+package optimizer
+
+import (
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// UselessDefinitions returns the instructions defining a register that no later instruction reads,
+// according to the dependency graph. Calls, ld_abs/ld_ind and no-ops are left out since they are
+// kept for their side effects or define nothing, as is the second slot of an lddw.
+func (s *Section) UselessDefinitions() []int {
+	useless := make([]int, 0)
+
+	for i, inst := range s.Instructions {
+		if inst.Opcode == 0 || inst.IsNOP() || inst.Opcode == bpf.BPF_JMP|bpf.JMP_CALL || isLegacyPacketLoad(inst) {
+			continue
+		}
+
+		if analyzeInstruction(inst).UpdatedReg < 0 {
+			continue
+		}
+
+		if i < len(s.Dependencies) && len(s.Dependencies[i].DependedBy) == 0 {
+			useless = append(useless, i)
+		}
+	}
+
+	return useless
+}
+
+// findRedundantDefCandidates finds useless definitions whose register is written again
+// before any jump, exit or call, so no path can read the value. The dependency graph does not
+// see the implicit reads of atomics (r0 for cmpxchg), the registers a callee or helper reads
+// or the base register of a store, so the scan stops there. Relocated instructions are kept, their patch would land on the NOP,
+// and so are pseudo-loads, which the loader resolves even when no relocation was seen.
+func (s *Section) findRedundantDefCandidates() []int {
+	candidates := make([]int, 0)
+
+	for _, i := range s.UselessDefinitions() {
+		if s.Relocations[i] || s.CORERelocations[i] || s.Instructions[i].IsPseudoLoadImm64() {
+			continue
+		}
+
+		inst := s.Instructions[i]
+		reg := analyzeInstruction(inst).UpdatedReg
+		next := i + 1
+		if inst.IsLoadImm64() {
+			next++
+		}
+
+		for k := next; k < len(s.Instructions); k++ {
+			later := s.Instructions[k]
+			class := later.GetInstructionClass()
+			if class == bpf.BPF_JMP || class == bpf.BPF_JMP32 || isLegacyPacketLoad(later) ||
+				(class == bpf.BPF_STX && later.Opcode&0xE0 == bpf.BPF_ATOMIC) {
+				break
+			}
+
+			// Stores address memory through dst, which the analysis does not list as used
+			analysis := analyzeInstruction(later)
+			if contains(analysis.UsedReg, reg) || ((class == bpf.BPF_ST || class == bpf.BPF_STX) && int(later.DstReg) == reg) {
+				break
+			}
+			if analysis.UpdatedReg == reg {
+				candidates = append(candidates, i)
+				break
+			}
+		}
+	}
+
+	return candidates
+}
+
+// applyRedundantDefElimination NOPs register definitions overwritten before being read
+func (s *Section) applyRedundantDefElimination() {
+	for _, idx := range s.findRedundantDefCandidates() {
+		indices := []int{idx}
+		if s.Instructions[idx].IsLoadImm64() {
+			indices = append(indices, idx+1)
+		}
+		if !s.inRange(indices...) {
+			continue
+		}
+
+		for _, i := range indices {
+			s.Instructions[i].SetAsNOP()
+		}
+		s.countApplied()
+	}
+}
@@ -0,0 +1,101 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUselessDefinitions(t *testing.T) {
+	section, err := NewSection(strings.Join([]string{
+		"b703000005000000", // 0: r3 = 5, never read
+		"b702000007000000", // 1: r2 = 7
+		"632afcff00000000", // 2: *(u32 *)(r10 - 4) = r2
+		"1801000001000000", // 3: r1 = 1 ll
+		"0000000000000000", // 4
+		"b700000001000000", // 5: r0 = 1
+		"bf14000000000000", // 6: r4 = r1, never read
+		"8500000005000000", // 7: call bpf_ktime_get_ns, result unused
+		"b700000000000000", // 8: r0 = 0
+		"9500000000000000", // 9: exit
+	}, ""), "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	// r0 = 1 is overwritten by the call before exit reads r0
+	expected := []int{0, 5, 6}
+	if got := section.UselessDefinitions(); !equalIntSlice(got, expected) {
+		t.Errorf("UselessDefinitions() = %v, expected %v", got, expected)
+	}
+}
+
+func TestApplyRedundantDefElimination(t *testing.T) {
+	tests := []struct {
+		name     string
+		insts    []string
+		expected []string
+	}{
+		{
+			name: "overwritten before read",
+			insts: []string{
+				"b703000005000000", // r3 = 5, dead
+				"b703000007000000", // r3 = 7
+				"633afcff00000000", // *(u32 *)(r10 - 4) = r3
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+			expected: []string{
+				"0500000000000000",
+				"b703000007000000",
+				"633afcff00000000",
+				"b700000000000000",
+				"9500000000000000",
+			},
+		},
+		{
+			name: "read before redefinition",
+			insts: []string{
+				"b703000005000000", // r3 = 5
+				"633afcff00000000", // *(u32 *)(r10 - 4) = r3
+				"b703000007000000", // r3 = 7
+				"633af8ff00000000", // *(u32 *)(r10 - 8) = r3
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+		},
+		{
+			name: "used as the base of a store",
+			insts: []string{
+				"bf13000000000000", // r3 = r1
+				"6203000001000000", // *(u32 *)(r3 + 0) = 1
+				"b703000007000000", // r3 = 7
+				"633af8ff00000000", // *(u32 *)(r10 - 8) = r3
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section, err := NewSection(strings.Join(tt.insts, ""), "test", true)
+			if err != nil {
+				t.Fatalf("NewSection() error = %v", err)
+			}
+
+			if err := section.ApplyPass(PassRedundantDef); err != nil {
+				t.Fatalf("ApplyPass() error = %v", err)
+			}
+
+			expected := tt.expected
+			if expected == nil {
+				expected = tt.insts
+			}
+			for i, want := range expected {
+				if got := section.Instructions[i].Raw; got != want {
+					t.Errorf("instruction %d = %s, expected %s", i, got, want)
+				}
+			}
+		})
+	}
+}
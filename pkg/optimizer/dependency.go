@@ -30,6 +30,7 @@ type ControlFlowGraph struct {
 	NodesRev  map[int][]int          // node -> predecessor nodes
 	NodesLen  map[int]int            // node -> length of basic block
 	NodeStats map[int]*RegisterState // node -> register/stack state
+	Calls     map[int]int            // BPF-to-BPF call instruction -> callee entry
 }
 
 // Clone creates a deep copy of the ControlFlowGraph
@@ -39,6 +40,11 @@ func (cfg *ControlFlowGraph) Clone() *ControlFlowGraph {
 		NodesRev:  make(map[int][]int),
 		NodesLen:  make(map[int]int),
 		NodeStats: make(map[int]*RegisterState),
+		Calls:     make(map[int]int),
+	}
+
+	for site, callee := range cfg.Calls {
+		newCfg.Calls[site] = callee
 	}
 
 	// Copy Nodes
@@ -170,11 +176,14 @@ func analyzeInstruction(inst *bpf.Instruction) *InstructionAnalysis {
 // buildControlFlowGraph builds the control flow graph
 // This corresponds to the first part of Python's build_dependency method
 func (s *Section) buildControlFlowGraph() *ControlFlowGraph {
+	controlFlowGraphBuilds.Add(1)
+
 	cfg := &ControlFlowGraph{
 		Nodes:     make(map[int][]int),
 		NodesRev:  make(map[int][]int),
 		NodesLen:  make(map[int]int),
 		NodeStats: make(map[int]*RegisterState),
+		Calls:     make(map[int]int),
 	}
 
 	// Build forward mapping
@@ -202,6 +211,11 @@ func (s *Section) updateDependencies(cfg *ControlFlowGraph, base int, state *Reg
 		nodesDone = make(map[int]bool)
 	}
 
+	// OptimizeContext reports the cancellation once the analysis unwinds
+	if s.ctx != nil && s.ctx.Err() != nil {
+		return state
+	}
+
 	nodeLen, exists := cfg.NodesLen[base]
 	if !exists {
 		return state
@@ -3,6 +3,7 @@ package optimizer
 import (
 	"debug/elf"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -68,9 +69,9 @@ func buildInstruction(elfPath string) ([]*Section, error) {
 	}
 	defer elfFile.Close()
 
-	// Get symbol table
+	// Get symbol table, stripped objects have none
 	symbols, err := elfFile.Symbols()
-	if err != nil {
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
 		return nil, fmt.Errorf("failed to read symbols: %v", err)
 	}
 
@@ -89,32 +90,30 @@ func buildInstruction(elfPath string) ([]*Section, error) {
 				continue
 			}
 
-			// Convert to hex string and create optimized section
-			hexData := hex.EncodeToString(sectionData)
-			if len(hexData)%16 != 0 {
-				return nil, fmt.Errorf("bytecode section length must be a multiple of 16")
+			// 使用符号名称而不是 section 名称
+			optimizedSection, err := parseRawSection(getSymbolName(elfFile, symbol), sectionData)
+			if err != nil {
+				return nil, err
 			}
+			sections = append(sections, optimizedSection)
+		}
+	}
 
-			// 使用符号名称而不是 section 名称
-			symbolName := getSymbolName(elfFile, symbol)
+	// Without function symbols fall back to the code sections, named after the section
+	if len(sections) == 0 {
+		for _, section := range elfFile.Sections {
+			if !isCodeSection(section) {
+				continue
+			}
 
-			optimizedSection := &Section{
-				Name:         symbolName, // 使用函数名而不是 section 名
-				Instructions: make([]*bpf.Instruction, 0),
-				Dependencies: make([]DependencyInfo, 0),
+			sectionData, err := section.Data()
+			if err != nil || len(sectionData) == 0 {
+				continue
 			}
 
-			// Parse instructions (16 hex chars each)
-			for i := 0; i < len(hexData); i += 16 {
-				inst, err := bpf.NewInstruction(hexData[i : i+16])
-				if err != nil {
-					return nil, fmt.Errorf("failed to parse instruction at %d: %v", i/16, err)
-				}
-				optimizedSection.Instructions = append(optimizedSection.Instructions, inst)
-				optimizedSection.Dependencies = append(optimizedSection.Dependencies, DependencyInfo{
-					Dependencies: make([]int, 0),
-					DependedBy:   make([]int, 0),
-				})
+			optimizedSection, err := parseRawSection(section.Name, sectionData)
+			if err != nil {
+				return nil, err
 			}
 			sections = append(sections, optimizedSection)
 		}
@@ -123,6 +122,36 @@ func buildInstruction(elfPath string) ([]*Section, error) {
 	return sections, nil
 }
 
+// parseRawSection decodes the instructions of data without building dependencies
+func parseRawSection(name string, data []byte) (*Section, error) {
+	// Convert to hex string and create optimized section
+	hexData := hex.EncodeToString(data)
+	if len(hexData)%16 != 0 {
+		return nil, fmt.Errorf("bytecode section length must be a multiple of 16")
+	}
+
+	optimizedSection := &Section{
+		Name:         name,
+		Instructions: make([]*bpf.Instruction, 0),
+		Dependencies: make([]DependencyInfo, 0),
+	}
+
+	// Parse instructions (16 hex chars each)
+	for i := 0; i < len(hexData); i += 16 {
+		inst, err := bpf.NewInstruction(hexData[i : i+16])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse instruction at %d: %v", i/16, err)
+		}
+		optimizedSection.Instructions = append(optimizedSection.Instructions, inst)
+		optimizedSection.Dependencies = append(optimizedSection.Dependencies, DependencyInfo{
+			Dependencies: make([]int, 0),
+			DependedBy:   make([]int, 0),
+		})
+	}
+
+	return optimizedSection, nil
+}
+
 // 辅助函数：获取符号名称
 func getSymbolName(elfFile *elf.File, symbol elf.Symbol) string {
 	// 如果符号有名称，返回名称
@@ -1217,3 +1246,48 @@ nodes_stats = None
 
 	t.Logf("成功处理包含 nodes_stats = None 的完整解析")
 }
+
+func TestStackDependenciesOverlap(t *testing.T) {
+	hexData := strings.Join([]string{
+		"7a0af8ff01000000", // 0: *(u64 *)(r10 - 8) = 1
+		"620af4ff02000000", // 1: *(u32 *)(r10 - 12) = 2
+		"79a1f8ff00000000", // 2: r1 = *(u64 *)(r10 - 8)
+		"61a2fcff00000000", // 3: r2 = *(u32 *)(r10 - 4)
+		"69a3faff00000000", // 4: r3 = *(u16 *)(r10 - 6)
+		"79a4f0ff00000000", // 5: r4 = *(u64 *)(r10 - 16)
+		"61a5e8ff00000000", // 6: r5 = *(u32 *)(r10 - 24)
+		"b700000000000000", // 7: r0 = 0
+		"9500000000000000", // 8: exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		load  int
+		store int
+		want  bool
+	}{
+		{name: "exact slot", load: 2, store: 0, want: true},
+		{name: "upper half of a double word", load: 3, store: 0, want: true},
+		{name: "middle of a double word", load: 4, store: 0, want: true},
+		{name: "double word covering a word store", load: 5, store: 1, want: true},
+		{name: "double word next to another", load: 5, store: 0, want: false},
+		{name: "exact slot of another size", load: 2, store: 1, want: false},
+		{name: "untouched slot", load: 6, store: 0, want: false},
+		{name: "untouched slot of the word store", load: 6, store: 1, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := section.FoundDependency(tt.load, tt.store); got != tt.want {
+			t.Errorf("%s: instruction %d depends on %d = %v, expected %v (deps %v)",
+				tt.name, tt.load, tt.store, got, tt.want, section.Dependencies[tt.load].Dependencies)
+		}
+		if got := section.FoundDependedBy(tt.store, tt.load); got != tt.want {
+			t.Errorf("%s: instruction %d depended on by %d = %v, expected %v", tt.name, tt.store, tt.load, got, tt.want)
+		}
+	}
+}
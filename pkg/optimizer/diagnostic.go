@@ -0,0 +1,34 @@
+package optimizer
+
+import "fmt"
+
+// Severity tells how much of the program a diagnostic affects
+type Severity string
+
+const (
+	SeverityWarning Severity = "warning" // the output is still complete, e.g. a failed cache write
+	SeverityError   Severity = "error"   // a section was skipped or left unoptimized
+)
+
+// Diagnostic is a problem met while loading or saving a program that did not abort it
+type Diagnostic struct {
+	Section  string // section concerned, empty for the whole program
+	Message  string
+	Severity Severity
+}
+
+func (d Diagnostic) String() string {
+	if d.Section == "" {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: section %s: %s", d.Severity, d.Section, d.Message)
+}
+
+// diagnose records a diagnostic for a section, or the whole program when section is empty
+func (prog *BPFProgram) diagnose(severity Severity, section, format string, args ...any) {
+	prog.Diagnostics = append(prog.Diagnostics, Diagnostic{
+		Section:  section,
+		Message:  fmt.Sprintf(format, args...),
+		Severity: severity,
+	})
+}
@@ -0,0 +1,108 @@
+package optimizer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// WriteDependencyCSV writes one line per instruction with its analysis and dependency edges.
+// The columns follow the `/`-separated Merlin fixture format (analyz_result.csv):
+// hex/updated_reg/updated_stack/used_reg/used_stack/offset/is_call/is_exit,
+// followed by the Dependencies and DependedBy sets in Python notation as in section_deps.
+func (s *Section) WriteDependencyCSV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for i, inst := range s.Instructions {
+		row := formatAnalysisRow(inst, analyzeInstruction(inst))
+
+		deps := DependencyInfo{}
+		if i < len(s.Dependencies) {
+			deps = s.Dependencies[i].Deduplication()
+		}
+
+		if _, err := fmt.Fprintf(bw, "%s/%s/%s\n", row, formatPySet(deps.Dependencies), formatPySet(deps.DependedBy)); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// WriteAnalysisCSV writes one line per instruction with its analysis in the analyz_result.csv
+// format Merlin dumps, hex/updated_reg/updated_stack/used_reg/used_stack/offset/is_call/is_exit,
+// so fixtures can be generated from the Go analysis
+func (s *Section) WriteAnalysisCSV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for _, inst := range s.Instructions {
+		if _, err := fmt.Fprintln(bw, formatAnalysisRow(inst, analyzeInstruction(inst))); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// formatAnalysisRow renders an instruction analysis in the analyz_result.csv format
+func formatAnalysisRow(inst *bpf.Instruction, analysis *InstructionAnalysis) string {
+	// Python reports no offset for anything but jumps
+	offset := "None"
+	if inst.IsJump() {
+		offset = strconv.Itoa(int(analysis.Offset))
+	}
+
+	return strings.Join([]string{
+		inst.Raw,
+		strconv.Itoa(analysis.UpdatedReg),
+		formatPyList(int16sToInts(analysis.UpdatedStack)),
+		formatPyList(analysis.UsedReg),
+		formatPyList(int16sToInts(analysis.UsedStack)),
+		offset,
+		formatPyBool(analysis.IsCall),
+		formatPyBool(analysis.IsExit),
+	}, "/")
+}
+
+func int16sToInts(values []int16) []int {
+	result := make([]int, len(values))
+	for i, v := range values {
+		result[i] = int(v)
+	}
+	return result
+}
+
+// formatPyList renders values like Python's str(list), e.g. [1, 2]
+func formatPyList(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// formatPySet renders values like Python's str(set), e.g. {1, 2} or set()
+func formatPySet(values []int) string {
+	if len(values) == 0 {
+		return "set()"
+	}
+
+	sorted := make([]int, len(values))
+	copy(sorted, values)
+	sort.Ints(sorted)
+
+	list := formatPyList(sorted)
+	return "{" + list[1:len(list)-1] + "}"
+}
+
+func formatPyBool(value bool) string {
+	if value {
+		return "True"
+	}
+	return "False"
+}
@@ -0,0 +1,112 @@
+package optimizer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteDependencyCSV(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000001000000", // 0: r1 = 1
+		"7b1af8ff00000000", // 1: *(u64 *)(r10 - 8) = r1
+		"1501010000000000", // 2: if r1 == 0 goto +1
+		"b700000000000000", // 3: r0 = 0
+		"9500000000000000", // 4: exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := section.WriteDependencyCSV(&buf); err != nil {
+		t.Fatalf("WriteDependencyCSV() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(section.Instructions) {
+		t.Fatalf("Expected %d lines, got %d", len(section.Instructions), len(lines))
+	}
+
+	expected := []string{
+		"b701000001000000/1/[]/[]/[]/None/False/False/set()/{1, 2}",
+		"7b1af8ff00000000/-1/[-8, 64]/[1]/[]/None/False/False/{0}/set()",
+		"1501010000000000/-1/[]/[1, 0]/[]/1/False/False/{0}/set()",
+	}
+	for i, want := range expected {
+		if lines[i] != want {
+			t.Errorf("line %d = %q, expected %q", i, lines[i], want)
+		}
+	}
+
+	// The analysis columns must round-trip through the fixture loader
+	path := filepath.Join(t.TempDir(), "deps.csv")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	insns, analysis := loadAnalysisFromFile(path)
+	for i, inst := range insns {
+		if inst.Raw != section.Instructions[i].Raw {
+			t.Errorf("instruction %d = %s, expected %s", i, inst.Raw, section.Instructions[i].Raw)
+		}
+		if want := analyzeInstruction(section.Instructions[i]); !reflect.DeepEqual(analysis[i], want) {
+			t.Errorf("analysis %d = %+v, expected %+v", i, analysis[i], want)
+		}
+	}
+}
+
+func TestWriteAnalysisCSV(t *testing.T) {
+	hexData, err := os.ReadFile("../../testdata/section_data")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	section, err := NewSection(string(hexData), ".text", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := section.WriteAnalysisCSV(&buf); err != nil {
+		t.Fatalf("WriteAnalysisCSV() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "analyz_result.csv")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	insns, analysis := loadAnalysisFromFile(path)
+	if len(insns) != len(section.Instructions) {
+		t.Fatalf("read back %d instructions, want %d", len(insns), len(section.Instructions))
+	}
+	for i, inst := range insns {
+		if inst.Raw != section.Instructions[i].Raw {
+			t.Errorf("instruction %d = %s, expected %s", i, inst.Raw, section.Instructions[i].Raw)
+		}
+		if want := analyzeInstruction(section.Instructions[i]); !reflect.DeepEqual(analysis[i], want) {
+			t.Errorf("analysis %d = %+v, expected %+v", i, analysis[i], want)
+		}
+	}
+
+	// Written from the instructions of the Merlin fixture, the rows are the fixture's
+	fixture, err := os.ReadFile("../../testdata/analyz_result.csv")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	fixtureInsns, _ := loadAnalysisFromFile("../../testdata/analyz_result.csv")
+	golden := &Section{Instructions: fixtureInsns}
+
+	buf.Reset()
+	if err := golden.WriteAnalysisCSV(&buf); err != nil {
+		t.Fatalf("WriteAnalysisCSV() error = %v", err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(buf.Bytes()), bytes.TrimSpace(fixture)) {
+		t.Errorf("WriteAnalysisCSV() does not reproduce analyz_result.csv")
+	}
+}
@@ -0,0 +1,75 @@
+package optimizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// ToEbpfInstructions converts the instruction stream to cilium/ebpf instructions.
+// Each lddw becomes a single wide instruction holding the 64-bit constant, so the result
+// has one entry less per lddw. Relocations are not carried over: map references keep
+// the immediate found in the object and must be resolved by the caller.
+func (s *Section) ToEbpfInstructions() (asm.Instructions, error) {
+	insns := make(asm.Instructions, 0, len(s.Instructions))
+
+	for i := 0; i < len(s.Instructions); i++ {
+		inst := s.Instructions[i]
+		ins := asm.Instruction{
+			OpCode:   asm.OpCode(inst.Opcode),
+			Dst:      asm.Register(inst.DstReg),
+			Src:      asm.Register(inst.SrcReg),
+			Offset:   inst.Offset,
+			Constant: int64(inst.Imm),
+		}
+
+		if inst.IsLoadImm64() {
+			if i+1 >= len(s.Instructions) || s.Instructions[i+1].Opcode != 0 {
+				return nil, fmt.Errorf("lddw at %d is missing its second slot", i)
+			}
+			ins.Constant = int64(bpf.CombineLoadImm64(inst, s.Instructions[i+1]))
+			i++
+		}
+
+		insns = append(insns, ins)
+	}
+
+	return insns, nil
+}
+
+// NewSectionFromEbpf creates a section from cilium/ebpf instructions and optimizes it.
+// Wide lddw instructions are expanded back into their two slots, and function references
+// are resolved into call offsets. Instructions carrying a symbol reference or an associated
+// map are recorded in Relocations, as the ELF relocations would be.
+func NewSectionFromEbpf(insts asm.Instructions, name string) (*Section, error) {
+	// Marshal resolves function references in place, keep the caller's instructions intact
+	insts = slices.Clone(insts)
+
+	var buf bytes.Buffer
+	if err := insts.Marshal(&buf, binary.LittleEndian); err != nil {
+		return nil, err
+	}
+
+	section, err := NewSectionWithOptions(hex.EncodeToString(buf.Bytes()), name, SectionOptions{SkipOptimization: true})
+	if err != nil {
+		return nil, err
+	}
+
+	section.Relocations = make(map[int]bool)
+	slot := 0
+	for _, ins := range insts {
+		if ins.Reference() != "" || ins.Map() != nil {
+			section.Relocations[slot] = true
+		}
+		slot += int(ins.Size() / asm.InstructionSize)
+	}
+
+	section.applyOptimizations(nil, 0)
+	return section, nil
+}
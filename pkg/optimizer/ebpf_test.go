@@ -0,0 +1,144 @@
+package optimizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+)
+
+func TestToEbpfInstructions(t *testing.T) {
+	hexData := strings.Join([]string{
+		"1801000078563412", // r1 = 0xfffffffe12345678 ll
+		"00000000feffffff",
+		"7b1af8ff00000000", // *(u64 *)(r10 - 8) = r1
+		"b7020000ffffffff", // r2 = -1
+		"1502010000000000", // if r2 == 0 goto +1
+		"0500000000000000", // goto +0
+		"9500000000000000", // exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	insns, err := section.ToEbpfInstructions()
+	if err != nil {
+		t.Fatalf("ToEbpfInstructions() error = %v", err)
+	}
+
+	expected := []asm.Instruction{
+		{OpCode: asm.OpCode(0x18), Dst: asm.R1, Constant: -0x1edcba988},
+		{OpCode: asm.OpCode(0x7b), Dst: asm.R10, Src: asm.R1, Offset: -8},
+		{OpCode: asm.OpCode(0xb7), Dst: asm.R2, Constant: -1},
+		{OpCode: asm.OpCode(0x15), Dst: asm.R2, Offset: 1},
+		{OpCode: asm.OpCode(0x05)},
+		{OpCode: asm.OpCode(0x95)},
+	}
+
+	if len(insns) != len(expected) {
+		t.Fatalf("got %d instructions, expected %d", len(insns), len(expected))
+	}
+	for i, want := range expected {
+		got := insns[i]
+		if got.OpCode != want.OpCode || got.Dst != want.Dst || got.Src != want.Src ||
+			got.Offset != want.Offset || got.Constant != want.Constant {
+			t.Errorf("instruction %d = %v, expected %v", i, got, want)
+		}
+	}
+
+	// Marshalling must give back the section bytes, lddw included
+	var buf bytes.Buffer
+	if err := insns.Marshal(&buf, binary.LittleEndian); err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if got := hex.EncodeToString(buf.Bytes()); got != hexData {
+		t.Errorf("Marshal() = %s, expected %s", got, hexData)
+	}
+}
+
+func TestToEbpfInstructionsTruncatedLoadImm64(t *testing.T) {
+	section, err := NewSection("1801000001000000", "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	if _, err := section.ToEbpfInstructions(); err == nil {
+		t.Error("ToEbpfInstructions() expected an error for an lddw without its second slot")
+	}
+}
+
+func TestNewSectionFromEbpfRoundTrip(t *testing.T) {
+	// Nothing here can be optimized: every definition is used and no lddw repeats
+	insts := asm.Instructions{
+		asm.LoadImm(asm.R6, 0x1122334455667788, asm.DWord),
+		asm.Mov.Reg(asm.R1, asm.R10),
+		asm.Add.Imm(asm.R1, -8),
+		asm.StoreMem(asm.R1, 0, asm.R6, asm.DWord),
+		asm.FnKtimeGetNs.Call(),
+		asm.JEq.Imm(asm.R0, 0, "out"),
+		asm.Mov.Imm(asm.R0, 1),
+		asm.Return().WithSymbol("out"),
+	}
+
+	placeholder := insts[5].Offset
+
+	section, err := NewSectionFromEbpf(insts, "test")
+	if err != nil {
+		t.Fatalf("NewSectionFromEbpf() error = %v", err)
+	}
+
+	if got := len(section.Instructions); got != 9 {
+		t.Fatalf("got %d slots, expected 9 with the lddw expanded", got)
+	}
+
+	exported, err := section.ToEbpfInstructions()
+	if err != nil {
+		t.Fatalf("ToEbpfInstructions() error = %v", err)
+	}
+	if len(exported) != len(insts) {
+		t.Fatalf("got %d instructions back, expected %d", len(exported), len(insts))
+	}
+
+	for i, want := range insts {
+		got := exported[i]
+		if got.OpCode != want.OpCode || got.Dst != want.Dst || got.Src != want.Src || got.Constant != want.Constant {
+			t.Errorf("instruction %d = %v, expected %v", i, got, want)
+		}
+	}
+
+	// The jump offset is only known once the reference is resolved
+	if got := exported[5].Offset; got != 1 {
+		t.Errorf("jump offset = %d, expected 1", got)
+	}
+	if insts[5].Offset != placeholder {
+		t.Errorf("NewSectionFromEbpf() modified the caller's instructions")
+	}
+}
+
+func TestNewSectionFromEbpfMapReference(t *testing.T) {
+	insts := asm.Instructions{
+		asm.LoadMapPtr(asm.R1, 0).WithReference("events"),
+		asm.LoadMapPtr(asm.R2, 0).WithReference("events"),
+		asm.Mov.Imm(asm.R0, 0),
+		asm.Return(),
+	}
+
+	section, err := NewSectionFromEbpf(insts, "test")
+	if err != nil {
+		t.Fatalf("NewSectionFromEbpf() error = %v", err)
+	}
+
+	if !section.Relocations[0] || !section.Relocations[2] {
+		t.Errorf("got relocations %v, expected both map loads", section.Relocations)
+	}
+	for i := 0; i < 4; i++ {
+		if section.Instructions[i].IsNOP() {
+			t.Errorf("map load slot %d was removed", i)
+		}
+	}
+}
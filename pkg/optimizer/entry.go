@@ -0,0 +1,51 @@
+package optimizer
+
+import (
+	"sort"
+)
+
+// findEntryPoints finds the first instruction of every function in the section.
+// Besides instruction 0, a function starts at a function symbol (FunctionStarts)
+// or at the target of a BPF-to-BPF call. Without symbols the analysis starts at
+// instruction 0 only, as Merlin's does, and every other block is reached from there.
+func (s *Section) findEntryPoints(cfg *ControlFlowGraph) []int {
+	entries := map[int]bool{0: true}
+	if s.FunctionStarts == nil {
+		return []int{0}
+	}
+
+	for _, start := range s.FunctionStarts {
+		if _, exists := cfg.NodesLen[start]; exists {
+			entries[start] = true
+		}
+	}
+
+	for _, callee := range cfg.Calls {
+		entries[callee] = true
+	}
+
+	result := make([]int, 0, len(entries))
+	for entry := range entries {
+		result = append(result, entry)
+	}
+	sort.Ints(result)
+
+	return result
+}
+
+// isEntryPoint checks if a basic block starts a function
+func (s *Section) isEntryPoint(node int) bool {
+	idx := sort.SearchInts(s.EntryPoints, node)
+	return idx < len(s.EntryPoints) && s.EntryPoints[idx] == node
+}
+
+// subprogramEntryState returns the register state at the entry of a function other than the main one:
+// r1-r5 hold the caller's arguments and r10 the new frame pointer
+func subprogramEntryState() *RegisterState {
+	state := NewRegisterState()
+	for i := 1; i <= 5; i++ {
+		state.Registers[i] = []int{-1}
+	}
+	state.Registers[10] = []int{-1}
+	return state
+}
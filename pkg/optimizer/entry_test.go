@@ -0,0 +1,50 @@
+package optimizer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMultipleEntryPoints(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b700000000000000", // 0: r0 = 0
+		"9500000000000000", // 1: exit
+		"bf10000000000000", // 2: second function: r0 = r1
+		"bf21000000000000", // 3: r1 = r2
+		"9500000000000000", // 4: exit
+	}, "")
+
+	// Without symbols the analysis starts at instruction 0 only
+	section, err := NewSection(hexData, ".text", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	if want := []int{0}; !reflect.DeepEqual(section.EntryPoints, want) {
+		t.Errorf("EntryPoints without symbols = %v, want %v", section.EntryPoints, want)
+	}
+	if section.FoundDependency(2, -1) {
+		t.Errorf("Expected instruction 2 not to depend on the entry state without symbols, got %v", section.Dependencies[2])
+	}
+
+	section, err = NewSectionWithOptions(hexData, ".text", SectionOptions{SkipOptimization: true, FunctionStarts: []int{0, 2}})
+	if err != nil {
+		t.Fatalf("NewSectionWithOptions() error = %v", err)
+	}
+
+	if want := []int{0, 2}; !reflect.DeepEqual(section.EntryPoints, want) {
+		t.Errorf("EntryPoints = %v, want %v", section.EntryPoints, want)
+	}
+
+	// Arguments of the second function come from its caller
+	for _, idx := range []int{2, 3} {
+		if !section.FoundDependency(idx, -1) {
+			t.Errorf("Expected instruction %d to depend on the entry state, got %v", idx, section.Dependencies[idx])
+		}
+	}
+
+	// The exit depends on the second function's own r0 definition
+	if got := section.Dependencies[4].Deduplication().Dependencies; !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("Dependencies[4] = %v, want [2]", got)
+	}
+}
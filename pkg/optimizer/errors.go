@@ -0,0 +1,194 @@
+package optimizer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// ErrSectionLengthNotMultiple reports section data that is not a whole number of instructions
+type ErrSectionLengthNotMultiple struct {
+	Length int // length of the hex data in characters
+}
+
+func (e *ErrSectionLengthNotMultiple) Error() string {
+	return fmt.Sprintf("bytecode section length must be a multiple of 16, got %d", e.Length)
+}
+
+// ErrMalformedInstruction reports an instruction that could not be decoded
+type ErrMalformedInstruction struct {
+	Index int
+	Raw   string
+	Err   error
+}
+
+func (e *ErrMalformedInstruction) Error() string {
+	return fmt.Sprintf("failed to parse instruction at %d (%s): %v", e.Index, e.Raw, e.Err)
+}
+
+func (e *ErrMalformedInstruction) Unwrap() error {
+	return e.Err
+}
+
+// ErrUnsupportedOpcode reports an instruction whose opcode is not part of the BPF instruction set
+type ErrUnsupportedOpcode struct {
+	Opcode uint8
+	Index  int
+}
+
+func (e *ErrUnsupportedOpcode) Error() string {
+	return fmt.Sprintf("unsupported opcode 0x%02x at %d", e.Opcode, e.Index)
+}
+
+// isSupportedOpcode checks the opcode against the operations and modes defined for its class
+func isSupportedOpcode(opcode uint8) bool {
+	op := opcode & 0xF0
+	mode := opcode & 0xE0
+
+	switch opcode & 0x07 {
+	case bpf.BPF_ALU, bpf.BPF_ALU64:
+		return op <= bpf.ALU_END
+	case bpf.BPF_JMP:
+		return op <= bpf.JMP_SLE
+	case bpf.BPF_JMP32:
+		return op <= bpf.JMP_SLE && op != bpf.JMP_CALL && op != bpf.JMP_EXIT
+	case bpf.BPF_LD:
+		// opcode 0x00 is the second slot of lddw
+		return opcode == bpf.BPF_LDDW || opcode == 0x00 || mode == bpf.BPF_ABS || mode == bpf.BPF_IND
+	case bpf.BPF_LDX:
+		return mode == bpf.BPF_MEM || mode == bpf.BPF_MEMSX
+	case bpf.BPF_ST:
+		return mode == bpf.BPF_MEM
+	case bpf.BPF_STX:
+		return mode == bpf.BPF_MEM || mode == bpf.BPF_ATOMIC
+	}
+
+	return false
+}
+
+// ErrReadOnlyRegisterWrite reports an instruction that defines the read-only frame pointer r10
+type ErrReadOnlyRegisterWrite struct {
+	Index int
+	Raw   string
+}
+
+func (e *ErrReadOnlyRegisterWrite) Error() string {
+	return fmt.Sprintf("instruction at %d (%s) writes the read-only frame pointer r10", e.Index, e.Raw)
+}
+
+// ErrInvalidRegister reports an instruction naming a register past r10
+type ErrInvalidRegister struct {
+	Index int
+	Raw   string
+	Reg   uint8
+}
+
+func (e *ErrInvalidRegister) Error() string {
+	return fmt.Sprintf("instruction at %d (%s) uses invalid register r%d", e.Index, e.Raw, e.Reg)
+}
+
+// ErrUnusedOffset reports an ALU instruction with an offset its operation does not use
+type ErrUnusedOffset struct {
+	Index  int
+	Raw    string
+	Offset int16
+}
+
+func (e *ErrUnusedOffset) Error() string {
+	return fmt.Sprintf("ALU instruction at %d (%s) has offset %d, which its operation does not use", e.Index, e.Raw, e.Offset)
+}
+
+// ErrInconsistentInstruction reports an instruction whose Raw encodes other fields than the decoded ones
+type ErrInconsistentInstruction struct {
+	Index int
+	Raw   string
+	Err   error
+}
+
+func (e *ErrInconsistentInstruction) Error() string {
+	return fmt.Sprintf("instruction at %d (%s) is inconsistent: %v", e.Index, e.Raw, e.Err)
+}
+
+func (e *ErrInconsistentInstruction) Unwrap() error {
+	return e.Err
+}
+
+// ErrJumpOutOfBounds reports a jump or branch whose target is outside the section
+type ErrJumpOutOfBounds struct {
+	Index  int
+	Raw    string
+	Target int
+}
+
+func (e *ErrJumpOutOfBounds) Error() string {
+	return fmt.Sprintf("instruction at %d (%s) jumps to %d, outside the section", e.Index, e.Raw, e.Target)
+}
+
+// ErrNotELF reports an input file that does not start with the ELF magic number
+type ErrNotELF struct {
+	Path string
+}
+
+func (e *ErrNotELF) Error() string {
+	return fmt.Sprintf("input %s is not an ELF object (expected 0x7f ELF)", e.Path)
+}
+
+// ErrNoBPFSections reports an ELF object without any code section to optimize
+type ErrNoBPFSections struct {
+	Path string
+}
+
+func (e *ErrNoBPFSections) Error() string {
+	return fmt.Sprintf("ELF object %s has no BPF code sections", e.Path)
+}
+
+// ErrSectionsFailed reports an ELF object whose code sections all failed to process.
+// Errors holds the error of each section, so errors.As finds e.g. an *ErrSectionPanic.
+type ErrSectionsFailed struct {
+	Path   string
+	Errors []error
+}
+
+func (e *ErrSectionsFailed) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("no code section of ELF object %s could be processed: %s", e.Path, strings.Join(messages, "; "))
+}
+
+func (e *ErrSectionsFailed) Unwrap() []error {
+	return e.Errors
+}
+
+// ErrUnknownPass reports a pass name that does not match any optimization
+type ErrUnknownPass struct {
+	Name string
+}
+
+func (e *ErrUnknownPass) Error() string {
+	return fmt.Sprintf("unknown optimization pass %q", e.Name)
+}
+
+// ErrSectionPanic reports a section whose analysis or optimization panicked.
+// Stack holds the innermost frames below the panic, outside the runtime.
+type ErrSectionPanic struct {
+	Section string
+	Value   interface{}
+	Stack   []string
+}
+
+func (e *ErrSectionPanic) Error() string {
+	return fmt.Sprintf("panic while processing section %s: %v (at %s)", e.Section, e.Value, strings.Join(e.Stack, " <- "))
+}
+
+// ErrPatchMismatch reports a patch entry whose old bytes are not found at its offset
+type ErrPatchMismatch struct {
+	Section string
+	Offset  uint64
+}
+
+func (e *ErrPatchMismatch) Error() string {
+	return fmt.Sprintf("patch for section %s does not match the input at offset %d", e.Section, e.Offset)
+}
@@ -6,8 +6,9 @@ import (
 	"github.com/beepfd/bpf-optimizer/pkg/bpf"
 )
 
-// applyConstantPropagation implements constant propagation optimization
-func (s *Section) applyConstantPropagation() []int {
+// findConstantPropagationCandidates finds immediate loads whose every use is a store that can take the immediate.
+// It returns the load indices and the stores depending on them.
+func (s *Section) findConstantPropagationCandidates() ([]int, []int) {
 	candidates := make([]int, 0)
 	storeCandidates := make([]int, 0)
 
@@ -15,18 +16,39 @@ func (s *Section) applyConstantPropagation() []int {
 		// Look for immediate load instructions (MOV with immediate)
 		if inst.Opcode == 0xB7 || inst.Opcode == 0xB4 {
 			canPropagate := true
+			blocked := ""
 
 			// Check if all dependent instructions can be optimized
 			for _, depIdx := range s.Dependencies[i].DependedBy {
 				depInst := s.Instructions[depIdx]
-				if depInst.GetInstructionClass() != bpf.BPF_STX ||
-					len(s.Dependencies[depIdx].Dependencies) != 1 ||
-					depInst.Opcode == 0xDB || depInst.Opcode == 0xC3 {
-					canPropagate = false
+				if depInst.GetInstructionClass() != bpf.BPF_STX {
+					// Not a candidate at all, whatever the other uses are
+					canPropagate, blocked = false, ""
 					break
 				}
+				if !canPropagate {
+					continue
+				}
+
+				switch {
+				case len(s.Dependencies[depIdx].Dependencies) != 1:
+					blocked = BlockedMultipleDeps
+				case depInst.Opcode == 0xDB || depInst.Opcode == 0xC3:
+					blocked = BlockedAtomic
+				case s.hasLegacyPacketLoadBetween(i, depIdx):
+					// Stay clear of ld_abs/ld_ind between the load and its store, as for the loads superword stops at
+					blocked = BlockedPacketLoad
+				case inst.Opcode == 0xB4 && inst.Imm < 0 && depInst.Opcode&0x18 == bpf.SIZE_DW:
+					// mov32 zero-extends into the register, but a 64-bit store sign-extends its imm,
+					// so a negative 32-bit constant would change the upper half of the stored value
+					blocked = BlockedSignExtension
+				}
+				canPropagate = blocked == ""
 			}
 
+			if blocked != "" {
+				s.countBlocked(blocked)
+			}
 			if canPropagate {
 				candidates = append(candidates, i)
 				storeCandidates = append(storeCandidates, s.Dependencies[i].DependedBy...)
@@ -34,8 +56,34 @@ func (s *Section) applyConstantPropagation() []int {
 		}
 	}
 
+	return candidates, storeCandidates
+}
+
+// hasLegacyPacketLoadBetween checks for an ld_abs/ld_ind strictly between two instruction indices
+func (s *Section) hasLegacyPacketLoadBetween(a, b int) bool {
+	if a > b {
+		a, b = b, a
+	}
+	for k := a + 1; k < b; k++ {
+		if isLegacyPacketLoad(s.Instructions[k]) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyConstantPropagation implements constant propagation optimization
+func (s *Section) applyConstantPropagation() []int {
+	candidates, _ := s.findConstantPropagationCandidates()
+	storeCandidates := make([]int, 0)
+
 	// Apply constant propagation
 	for _, candIdx := range candidates {
+		if !s.inRange(candIdx) || !s.inRange(s.Dependencies[candIdx].DependedBy...) {
+			continue
+		}
+		storeCandidates = append(storeCandidates, s.Dependencies[candIdx].DependedBy...)
+
 		inst := s.Instructions[candIdx]
 
 		// Replace dependent store instructions with immediate stores
@@ -48,6 +96,7 @@ func (s *Section) applyConstantPropagation() []int {
 				depInst.Raw[3:8], inst.Raw[8:])
 			newInst, _ := bpf.NewInstruction(newHex)
 			s.Instructions[depIdx] = newInst
+			s.recordOrigins(depIdx, candIdx, depIdx)
 
 			// Clear dependencies
 			s.Dependencies[depIdx].Dependencies = make([]int, 0)
@@ -56,13 +105,14 @@ func (s *Section) applyConstantPropagation() []int {
 		// Mark original instruction as NOP
 		s.Instructions[candIdx].SetAsNOP()
 		s.Dependencies[candIdx].DependedBy = make([]int, 0)
+		s.countApplied()
 	}
 
 	return storeCandidates
 }
 
-// applyCompaction implements code compaction optimization
-func (s *Section) applyCompaction() {
+// findCompactionCandidates finds `lsh 32` + `rsh 32` pairs on the same register
+func (s *Section) findCompactionCandidates() []int {
 	candidates := make([]int, 0)
 
 	for i := 0; i < len(s.Instructions)-1; i++ {
@@ -81,14 +131,24 @@ func (s *Section) applyCompaction() {
 		}
 	}
 
+	return candidates
+}
+
+// applyCompaction implements code compaction optimization
+func (s *Section) applyCompaction() {
+	candidates := s.findCompactionCandidates()
+
 	// Apply compaction
 	for _, candIdx := range candidates {
-		targetReg := s.Instructions[candIdx].Raw[3:4]
-		newHex := fmt.Sprintf("bc%s%s000000000000", targetReg, targetReg)
-		newInst, _ := bpf.NewInstruction(newHex)
+		if !s.inRange(candIdx, candIdx+1) {
+			continue
+		}
 
-		s.Instructions[candIdx] = newInst
+		targetReg := s.Instructions[candIdx].DstReg
+		s.Instructions[candIdx] = newMov32(targetReg, targetReg)
 		s.Instructions[candIdx+1].SetAsNOP()
+		s.recordOrigins(candIdx, candIdx, candIdx+1)
+		s.countApplied()
 	}
 }
 
@@ -102,10 +162,37 @@ func (s *Section) applyPeepholeOptimization() {
 
 	// Apply peephole optimization
 	applyPeepholeOptimization(s, candidates)
+
+	// Rewrite ANDs with 0xffffffff, before a `w = w` they leave is checked for redundancy
+	s.applyAllOnesMaskRewrite()
+
+	// Drop zero-extensions made redundant by a preceding 32-bit ALU op
+	s.applyZeroExtensionElimination()
+
+	// Drop conditional jumps that land on the next instruction either way
+	s.applyNoOpJumpElimination()
+}
+
+// FindStoreCandidates scans the section for immediate stores and populates StoreCandidates.
+// Only BPF_ST stores are collected: superword merge concatenates immediates, which a
+// register store (BPF_STX) does not have until constant propagation rewrote it into BPF_ST.
+func (s *Section) FindStoreCandidates() []int {
+	s.StoreCandidates = make([]int, 0)
+
+	for i, inst := range s.Instructions {
+		if inst.GetInstructionClass() == bpf.BPF_ST && inst.Opcode&0xe0 == bpf.BPF_MEM {
+			s.StoreCandidates = append(s.StoreCandidates, i)
+		}
+	}
+
+	return s.StoreCandidates
 }
 
 // applySuperwordMerge implements superword-level merge optimization
-func (s *Section) applySuperwordMerge(storeCandidates []int) {
+func (s *Section) applySuperwordMerge() {
 	merger := NewSuperwordMerger(s)
-	merger.ApplySuperwordMergeWithCandidates(storeCandidates)
+	merger.ApplySuperwordMergeWithCandidates(s.StoreCandidates)
+	if s.AggressiveMerge {
+		merger.applyGapMerges()
+	}
 }
@@ -1,6 +1,7 @@
 package optimizer
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/beepfd/bpf-optimizer/pkg/bpf"
@@ -180,6 +181,78 @@ func TestApplyConstantPropagation(t *testing.T) {
 			},
 			expectedNOPs: []int{0},
 		},
+		{
+			name: "negative immediate value",
+			instructions: []string{
+				"b7010000ffffffff", // mov r1, -1
+				"7b01100000000000", // stxdw [r1+16], r0
+			},
+			dependencies: []DependencyInfo{
+				{
+					Dependencies: []int{},
+					DependedBy:   []int{1},
+				},
+				{
+					Dependencies: []int{0},
+					DependedBy:   []int{},
+				},
+			},
+			expectedInsts: []string{
+				"0500000000000000", // NOP
+				"7a011000ffffffff", // stdw [r1+16], -1
+			},
+			expectedNOPs: []int{0},
+		},
+		{
+			name: "negative 32-bit immediate into 64-bit store",
+			instructions: []string{
+				"b4010000ffffffff", // w1 = -1, upper half zeroed
+				"7b01100000000000", // stxdw [r1+16], r0
+			},
+			dependencies: []DependencyInfo{
+				{
+					Dependencies: []int{},
+					DependedBy:   []int{1},
+				},
+				{
+					Dependencies: []int{0},
+					DependedBy:   []int{},
+				},
+			},
+			expectedInsts: []string{
+				"b4010000ffffffff", // unchanged, stdw would sign-extend to -1
+				"7b01100000000000", // unchanged
+			},
+			expectedNOPs: []int{},
+		},
+		{
+			name: "ld_abs between load and store - should not propagate",
+			instructions: []string{
+				"b707000005000000", // mov r7, 5
+				"3000000017000000", // ld_abs r0 = skb[23], may end the program
+				"637afcff00000000", // stx [r10-4], r7
+			},
+			dependencies: []DependencyInfo{
+				{
+					Dependencies: []int{},
+					DependedBy:   []int{2},
+				},
+				{
+					Dependencies: []int{},
+					DependedBy:   []int{},
+				},
+				{
+					Dependencies: []int{0},
+					DependedBy:   []int{},
+				},
+			},
+			expectedInsts: []string{
+				"b707000005000000", // unchanged
+				"3000000017000000", // unchanged
+				"637afcff00000000", // unchanged
+			},
+			expectedNOPs: []int{},
+		},
 	}
 
 	for _, tt := range tests {
@@ -429,3 +502,93 @@ func TestApplyCompaction(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyCompactionWithMovNOPEncoding(t *testing.T) {
+	if err := bpf.SetNOPEncoding(bpf.NOPMov); err != nil {
+		t.Fatalf("SetNOPEncoding() error = %v", err)
+	}
+	defer bpf.SetNOPEncoding(bpf.NOP)
+
+	section := createTestSection([]string{
+		"6701000020000000", // lsh r1, 32
+		"7701000020000000", // rsh r1, 32
+		"0500000000000000", // goto +0 (original, not a filler)
+	})
+	section.applyCompaction()
+
+	if got := section.Instructions[1].Raw; got != bpf.NOPMov {
+		t.Errorf("Instruction 1: expected %s, got %s", bpf.NOPMov, got)
+	}
+	if !section.Instructions[1].IsNOP() {
+		t.Errorf("Instruction 1 should be NOP")
+	}
+	if section.Instructions[2].IsSyntheticNOP() {
+		t.Errorf("Instruction 2 should not be the filler with the mov encoding")
+	}
+	if !section.Instructions[2].IsNOP() {
+		t.Errorf("Instruction 2 is goto +0 and should still be a NOP")
+	}
+
+	prog := &BPFProgram{Sections: map[string]*Section{"test": section}}
+	stats := prog.GetOptimizationStats()
+	if nops := stats["test"].(map[string]int)["nops"]; nops != 1 {
+		t.Errorf("GetOptimizationStats() nops = %d, want 1", nops)
+	}
+}
+
+func TestGetOptimizationStatsSkipsInputNOPs(t *testing.T) {
+	hexData := strings.Join([]string{
+		"6701000020000000", // lsh r1, 32
+		"7701000020000000", // rsh r1, 32
+		"0500000000000000", // goto +0 (original, same bytes as the filler)
+		"9500000000000000", // exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	section.applyCompaction()
+
+	prog := &BPFProgram{Sections: map[string]*Section{"test": section}}
+	stats := prog.GetOptimizationStats()
+	if nops := stats["test"].(map[string]int)["nops"]; nops != 1 {
+		t.Errorf("GetOptimizationStats() nops = %d, want 1", nops)
+	}
+	if optimized := stats["summary"].(map[string]interface{})["optimized_instructions"]; optimized != 1 {
+		t.Errorf("GetOptimizationStats() optimized_instructions = %v, want 1", optimized)
+	}
+}
+
+func TestApplySuperwordMergeFindsStoreCandidates(t *testing.T) {
+	instructions := []string{
+		"720af8ff01000000", // *(u8 *)(r10 - 8) = 0x1
+		"720af9ff02000000", // *(u8 *)(r10 - 7) = 0x2
+		"720afaff03000000", // *(u8 *)(r10 - 6) = 0x3
+		"720afbff04000000", // *(u8 *)(r10 - 5) = 0x4
+		"7b1af0ff00000000", // *(u64 *)(r10 - 16) = r1 (register store, not a candidate)
+		"9500000000000000", // exit
+	}
+
+	section := createTestSection(instructions)
+	section.applySuperwordMerge()
+
+	expectedCandidates := []int{0, 1, 2, 3}
+	if !equalIntSlice(section.StoreCandidates, expectedCandidates) {
+		t.Errorf("StoreCandidates = %v, expected %v", section.StoreCandidates, expectedCandidates)
+	}
+
+	// The merger driven with explicit candidates must produce the same output
+	reference := createTestSection(instructions)
+	NewSuperwordMerger(reference).ApplySuperwordMergeWithCandidates([]int{0, 1, 2, 3})
+
+	expected := []string{"620af8ff01020304", bpf.NOP, bpf.NOP, bpf.NOP, "7b1af0ff00000000", "9500000000000000"}
+	for i, want := range expected {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+		if got := reference.Instructions[i].Raw; got != want {
+			t.Errorf("reference instruction %d = %s, expected %s", i, got, want)
+		}
+	}
+}
@@ -53,6 +53,12 @@ func (s *Section) findNextNode(cfg *ControlFlowGraph, nodesDone map[int]bool, lo
 
 		if allPredsDone {
 			newBase = node
+			// A function entry starts from the calling convention rather than an empty state
+			if len(cfg.NodesRev[node]) == 0 && node != 0 && s.isEntryPoint(node) {
+				newState = subprogramEntryState()
+				continue
+			}
+
 			// Merge states from predecessors
 			var predStates []*RegisterState
 			for _, pred := range cfg.NodesRev[node] {
@@ -0,0 +1,193 @@
+package optimizer
+
+import (
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// gapMergeWidths are the store widths in bytes a gap merge tries, widest first
+var gapMergeWidths = []int{8, 4, 2}
+
+// applyGapMerges merges immediate stack stores that leave unwritten bytes between them into one
+// wider store writing zero to the gap. This is only done when every gap byte is provably dead,
+// overwritten through r10 before anything may read memory, or already zero, written as zero
+// through r10 since the start of the block with no store through another pointer in between.
+// Only r10 stores take part: the stack address is known without any alias analysis.
+func (sm *SuperwordMerger) applyGapMerges() {
+	starts := make(map[int]bool)
+	for _, start := range sm.section.blockStarts() {
+		starts[start] = true
+	}
+
+	for i, inst := range sm.section.Instructions {
+		if !isStackImmediateStore(inst) {
+			continue
+		}
+
+		for _, width := range gapMergeWidths {
+			if width*8 <= getSize(inst) || int(inst.Offset)%width != 0 {
+				continue
+			}
+			if sm.mergeGap(i, int(inst.Offset), width, starts) {
+				break
+			}
+		}
+	}
+}
+
+// mergeGap merges first with the later stores of its straight-line code that lie in the width
+// bytes from base, when they leave a gap whose bytes are dead or zero. It reports whether it merged.
+func (sm *SuperwordMerger) mergeGap(first, base, width int, starts map[int]bool) bool {
+	insts := sm.section.Instructions
+
+	// Stores fully inside the window, up to the first barrier or join
+	members := []int{first}
+	last := first
+	for k := first + 1; k < len(insts) && !starts[k] && !isGapBarrier(insts[k]); k++ {
+		if lo, hi, ok := stackStoreBytes(insts[k]); ok && lo < base+width && hi > base {
+			if !isStackImmediateStore(insts[k]) || lo < base || hi > base+width {
+				break
+			}
+			members = append(members, k)
+			last = k
+		} else if writesThroughPointer(insts[k]) {
+			break
+		}
+	}
+	if len(members) < 2 || !sm.section.inRange(members...) {
+		return false
+	}
+
+	// Lay out the bytes, rejecting stores that overlap each other
+	value := make([]byte, width)
+	written := make([]bool, width)
+	for _, idx := range members {
+		lo, _, _ := stackStoreBytes(insts[idx])
+		for j, b := range immediateStoreBytes(insts[idx]) {
+			if written[lo-base+j] {
+				return false
+			}
+			value[lo-base+j] = b
+			written[lo-base+j] = true
+		}
+	}
+
+	gap := make([]int, 0)
+	for j := range written {
+		if !written[j] {
+			gap = append(gap, base+j)
+		}
+	}
+	if len(gap) == 0 {
+		return false
+	}
+	for _, addr := range gap {
+		if !sm.isStackByteDead(addr, last) && !sm.isStackByteZero(addr, first, starts) {
+			return false
+		}
+	}
+
+	// A 64-bit store sign-extends its imm
+	imm := int32(uint32(value[0]) | uint32(value[1])<<8)
+	if width >= 4 {
+		imm = int32(uint32(value[0]) | uint32(value[1])<<8 | uint32(value[2])<<16 | uint32(value[3])<<24)
+	}
+	if width == 8 {
+		extended := uint64(int64(imm))
+		for j := 4; j < 8; j++ {
+			if value[j] != byte(extended>>(8*j)) {
+				return false
+			}
+		}
+	}
+
+	merged, err := bpf.NewInstructionFromFields(bpf.BPF_ST|bpf.BPF_MEM|getSizeMask(width*8), frameRegister, 0, int16(base), imm)
+	if err != nil {
+		return false
+	}
+
+	// The members run in straight-line code without reading memory, so the last one can stand for all
+	insts[last] = merged
+	sm.section.recordOrigins(last, members...)
+	for _, idx := range members[:len(members)-1] {
+		insts[idx].SetAsNOP()
+	}
+	sm.section.countApplied()
+	return true
+}
+
+// isStackByteDead checks that a store through r10 overwrites the stack byte at addr after idx
+// and before anything may read memory
+func (sm *SuperwordMerger) isStackByteDead(addr, idx int) bool {
+	insts := sm.section.Instructions
+	for k := idx + 1; k < len(insts) && !isGapBarrier(insts[k]); k++ {
+		if lo, hi, ok := stackStoreBytes(insts[k]); ok && addr >= lo && addr < hi {
+			return true
+		}
+	}
+	return false
+}
+
+// isStackByteZero checks that the stack byte at addr holds zero before idx: a store through r10
+// in the same block wrote zero there, and no call or store through another pointer came since
+func (sm *SuperwordMerger) isStackByteZero(addr, idx int, starts map[int]bool) bool {
+	insts := sm.section.Instructions
+	for k := idx - 1; k >= 0 && !starts[k+1]; k-- {
+		inst := insts[k]
+		if inst.IsNOP() {
+			continue
+		}
+
+		if lo, hi, ok := stackStoreBytes(inst); ok && addr >= lo && addr < hi {
+			if !isStackImmediateStore(inst) {
+				return false
+			}
+			return immediateStoreBytes(inst)[addr-lo] == 0
+		}
+		if writesThroughPointer(inst) || inst.GetInstructionClass() == bpf.BPF_JMP || inst.GetInstructionClass() == bpf.BPF_JMP32 {
+			return false
+		}
+	}
+	return false
+}
+
+// isGapBarrier checks if memory may be read at inst, or control may leave the straight-line code
+func isGapBarrier(inst *bpf.Instruction) bool {
+	if inst.IsNOP() {
+		return false
+	}
+
+	class := inst.GetInstructionClass()
+	return class == bpf.BPF_LDX || class == bpf.BPF_JMP || class == bpf.BPF_JMP32 || isLegacyPacketLoad(inst) ||
+		(class == bpf.BPF_STX && inst.Opcode&0xE0 == bpf.BPF_ATOMIC)
+}
+
+// writesThroughPointer checks if inst stores through a register other than r10, which may point into the stack
+func writesThroughPointer(inst *bpf.Instruction) bool {
+	class := inst.GetInstructionClass()
+	return (class == bpf.BPF_ST || class == bpf.BPF_STX) && inst.DstReg != frameRegister
+}
+
+// isStackImmediateStore checks if inst is a plain immediate store through r10
+func isStackImmediateStore(inst *bpf.Instruction) bool {
+	return inst.GetInstructionClass() == bpf.BPF_ST && inst.Opcode&0xE0 == bpf.BPF_MEM && inst.DstReg == frameRegister
+}
+
+// stackStoreBytes returns the stack bytes [lo, hi) written by a plain store through r10
+func stackStoreBytes(inst *bpf.Instruction) (int, int, bool) {
+	class := inst.GetInstructionClass()
+	if (class != bpf.BPF_ST && class != bpf.BPF_STX) || inst.Opcode&0xE0 != bpf.BPF_MEM || inst.DstReg != frameRegister {
+		return 0, 0, false
+	}
+	lo := int(inst.Offset)
+	return lo, lo + getSize(inst)/8, true
+}
+
+// immediateStoreBytes returns the little-endian bytes an immediate store writes, a 64-bit one sign-extending imm
+func immediateStoreBytes(inst *bpf.Instruction) []byte {
+	value := uint64(int64(inst.Imm))
+	out := make([]byte, getSize(inst)/8)
+	for j := range out {
+		out[j] = byte(value >> (8 * j))
+	}
+	return out
+}
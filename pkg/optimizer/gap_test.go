@@ -0,0 +1,116 @@
+package optimizer
+
+import (
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+func TestApplyGapMerges(t *testing.T) {
+	tests := []struct {
+		name     string
+		insts    []string
+		expected []string
+	}{
+		{
+			name: "gap overwritten before any read",
+			insts: []string{
+				"720afcff12000000", // *(u8 *)(r10 - 4) = 0x12
+				"720afeff34000000", // *(u8 *)(r10 - 2) = 0x34
+				"731afdff00000000", // *(u8 *)(r10 - 3) = r1
+				"731affff00000000", // *(u8 *)(r10 - 1) = r1
+				"9500000000000000", // exit
+			},
+			expected: []string{
+				bpf.NOP,
+				"620afcff12003400", // *(u32 *)(r10 - 4) = 0x340012
+				"731afdff00000000",
+				"731affff00000000",
+				"9500000000000000",
+			},
+		},
+		{
+			name: "gap already zero",
+			insts: []string{
+				"7a0af8ff00000000", // *(u64 *)(r10 - 8) = 0
+				"720afcff12000000", // *(u8 *)(r10 - 4) = 0x12
+				"720afeff34000000", // *(u8 *)(r10 - 2) = 0x34
+				"9500000000000000", // exit
+			},
+			expected: []string{
+				"7a0af8ff00000000",
+				bpf.NOP,
+				"620afcff12003400", // *(u32 *)(r10 - 4) = 0x340012
+				"9500000000000000",
+			},
+		},
+		{
+			name: "no proof",
+			insts: []string{
+				"720afcff12000000", // *(u8 *)(r10 - 4) = 0x12
+				"720afeff34000000", // *(u8 *)(r10 - 2) = 0x34
+				"9500000000000000", // exit
+			},
+		},
+		{
+			name: "gap read before it is overwritten",
+			insts: []string{
+				"720afcff12000000", // *(u8 *)(r10 - 4) = 0x12
+				"720afeff34000000", // *(u8 *)(r10 - 2) = 0x34
+				"79a1f8ff00000000", // r1 = *(u64 *)(r10 - 8)
+				"731afdff00000000", // *(u8 *)(r10 - 3) = r1
+				"731affff00000000", // *(u8 *)(r10 - 1) = r1
+				"9500000000000000", // exit
+			},
+		},
+		{
+			name: "zero may be overwritten through another pointer",
+			insts: []string{
+				"7a0af8ff00000000", // *(u64 *)(r10 - 8) = 0
+				"7a01000005000000", // *(u64 *)(r1 + 0) = 5
+				"720afcff12000000", // *(u8 *)(r10 - 4) = 0x12
+				"720afeff34000000", // *(u8 *)(r10 - 2) = 0x34
+				"9500000000000000", // exit
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section := createTestSection(tt.insts)
+			NewSuperwordMerger(section).applyGapMerges()
+
+			expected := tt.expected
+			if expected == nil {
+				expected = tt.insts
+			}
+			for i, want := range expected {
+				if got := section.Instructions[i].Raw; got != want {
+					t.Errorf("instruction %d = %s, expected %s", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSuperwordMergeAggressive(t *testing.T) {
+	insts := []string{
+		"7a0af8ff00000000", // *(u64 *)(r10 - 8) = 0
+		"720afcff12000000", // *(u8 *)(r10 - 4) = 0x12
+		"720afeff34000000", // *(u8 *)(r10 - 2) = 0x34
+		"9500000000000000", // exit
+	}
+
+	section := createTestSection(insts)
+	section.applySuperwordMerge()
+	if got := section.Instructions[2].Raw; got != insts[2] {
+		t.Errorf("without AggressiveMerge instruction 2 = %s, expected %s", got, insts[2])
+	}
+
+	section = createTestSection(insts)
+	section.AggressiveMerge = true
+	section.applySuperwordMerge()
+	if got := section.Instructions[2].Raw; got != "620afcff12003400" {
+		t.Errorf("with AggressiveMerge instruction 2 = %s, expected 620afcff12003400", got)
+	}
+}
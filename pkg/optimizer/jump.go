@@ -0,0 +1,41 @@
+package optimizer
+
+import (
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// findNoOpJumpCandidates finds conditional jumps with offset 0. Both outcomes continue at
+// the next instruction and a compare has no side effect, so the jump can be dropped.
+func (s *Section) findNoOpJumpCandidates() []int {
+	candidates := make([]int, 0)
+
+	for i, inst := range s.Instructions {
+		if isConditionalJump(inst) && inst.Offset == 0 {
+			candidates = append(candidates, i)
+		}
+	}
+
+	return candidates
+}
+
+// applyNoOpJumpElimination NOPs conditional jumps to the next instruction
+func (s *Section) applyNoOpJumpElimination() {
+	for _, idx := range s.findNoOpJumpCandidates() {
+		if !s.inRange(idx) {
+			continue
+		}
+		s.Instructions[idx].SetAsNOP()
+		s.countApplied()
+	}
+}
+
+// isConditionalJump checks for a JMP/JMP32 compare-and-branch.
+// may_goto (0xe5) is left alone since it also updates the loop counter.
+func isConditionalJump(inst *bpf.Instruction) bool {
+	if !inst.IsJump() {
+		return false
+	}
+
+	op := inst.GetALUOp()
+	return op != bpf.JMP_A && op != 0xe0
+}
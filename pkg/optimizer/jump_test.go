@@ -0,0 +1,33 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+func TestApplyNoOpJumpElimination(t *testing.T) {
+	insts := []string{
+		"b700000000000000", // 0: r0 = 0
+		"1501000000000000", // 1: if r1 == 0 goto +0, both edges lead to 2
+		"1e21000000000000", // 2: if w1 == w2 goto +0
+		"1501010000000000", // 3: if r1 == 0 goto +1, kept
+		"b700000001000000", // 4: r0 = 1
+		"9500000000000000", // 5: exit
+	}
+	section, err := NewSection(strings.Join(insts, ""), "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	section.ApplyPass(PassPeephole)
+
+	want := append([]string{}, insts...)
+	want[1], want[2] = bpf.NOP, bpf.NOP
+	for i, inst := range section.Instructions {
+		if inst.Raw != want[i] {
+			t.Errorf("instruction %d = %s, want %s", i, inst.Raw, want[i])
+		}
+	}
+}
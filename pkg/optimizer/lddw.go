@@ -0,0 +1,212 @@
+package optimizer
+
+import (
+	"sort"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// LoadImm64Candidate is an lddw whose constant is still held by the register of an earlier lddw
+type LoadImm64Candidate struct {
+	Index  int   // first slot of the redundant lddw
+	Source uint8 // register already holding the constant
+	Reused int   // first slot of the earlier lddw that loaded Source
+}
+
+// findRedundantLoadImm64Candidates finds lddw instructions reloading a constant that an earlier
+// lddw in the same basic block left untouched in a register. Loads patched by a relocation
+// (map fds, global data, CO-RE type ids) or carrying a pseudo src_reg are never treated as plain constants.
+func (s *Section) findRedundantLoadImm64Candidates() []LoadImm64Candidate {
+	candidates := make([]LoadImm64Candidate, 0)
+	blockStarts := s.blockStarts()
+
+	for i := 0; i+1 < len(s.Instructions); i++ {
+		if !s.isPlainLoadImm64(i) {
+			continue
+		}
+		value := bpf.CombineLoadImm64(s.Instructions[i], s.Instructions[i+1])
+
+		// Only look back within the block holding i, a join may bring in another value
+		blockStart := 0
+		if pos := sort.SearchInts(blockStarts, i+1); pos > 0 {
+			blockStart = blockStarts[pos-1]
+		}
+
+		found, clobbered := false, false
+		for j := i - 2; j >= blockStart; j-- {
+			if !s.isPlainLoadImm64(j) || bpf.CombineLoadImm64(s.Instructions[j], s.Instructions[j+1]) != value {
+				continue
+			}
+
+			source := s.Instructions[j].DstReg
+			if !s.isRegisterPreserved(source, j+2, i) {
+				clobbered = true
+				continue
+			}
+
+			candidates = append(candidates, LoadImm64Candidate{Index: i, Source: source, Reused: j})
+			found = true
+			break
+		}
+		if clobbered && !found {
+			s.countBlocked(BlockedClobbered)
+		}
+	}
+
+	return candidates
+}
+
+// applyLoadImm64Dedup replaces redundant lddw instructions with a register move,
+// or drops them when the register already holds the constant
+func (s *Section) applyLoadImm64Dedup() {
+	for _, candidate := range s.findRedundantLoadImm64Candidates() {
+		if !s.inRange(candidate.Index, candidate.Index+1) {
+			continue
+		}
+
+		dst := s.Instructions[candidate.Index].DstReg
+
+		if dst == candidate.Source {
+			s.Instructions[candidate.Index].SetAsNOP()
+		} else {
+			s.Instructions[candidate.Index], _ = bpf.NewInstructionFromFields(bpf.BPF_ALU64|bpf.ALU_MOV|bpf.BPF_X, dst, candidate.Source, 0, 0)
+			s.recordOrigins(candidate.Index, candidate.Index, candidate.Index+1)
+		}
+		s.Instructions[candidate.Index+1].SetAsNOP()
+		s.countApplied()
+	}
+}
+
+// findRedundantMapLoadCandidates finds lddw instructions loading a map that an earlier load of
+// the same map in the same basic block left untouched in a register. Both loads carry a map
+// relocation; unlike findRedundantLoadImm64Candidates this relies on the relocation naming the map.
+func (s *Section) findRedundantMapLoadCandidates() []LoadImm64Candidate {
+	candidates := make([]LoadImm64Candidate, 0)
+	blockStarts := s.blockStarts()
+
+	for i := 0; i+1 < len(s.Instructions); i++ {
+		if !s.isMapLoad(i) {
+			continue
+		}
+
+		blockStart := 0
+		if pos := sort.SearchInts(blockStarts, i+1); pos > 0 {
+			blockStart = blockStarts[pos-1]
+		}
+
+		found, clobbered := false, false
+		for j := i - 2; j >= blockStart; j-- {
+			if !s.isMapLoad(j) || s.MapReferences[j] != s.MapReferences[i] ||
+				s.Instructions[j].SrcReg != s.Instructions[i].SrcReg ||
+				bpf.CombineLoadImm64(s.Instructions[j], s.Instructions[j+1]) != bpf.CombineLoadImm64(s.Instructions[i], s.Instructions[i+1]) {
+				continue
+			}
+
+			source := s.Instructions[j].DstReg
+			if !s.isRegisterPreserved(source, j+2, i) {
+				clobbered = true
+				continue
+			}
+
+			candidates = append(candidates, LoadImm64Candidate{Index: i, Source: source, Reused: j})
+			found = true
+			break
+		}
+		if clobbered && !found {
+			s.countBlocked(BlockedClobbered)
+		}
+	}
+
+	return candidates
+}
+
+// applyMapLoadDedup replaces redundant map loads with a register move, or drops them when the
+// register already holds the map, and moves their relocation to the load they reuse
+func (s *Section) applyMapLoadDedup() {
+	for _, candidate := range s.findRedundantMapLoadCandidates() {
+		if !s.inRange(candidate.Index, candidate.Index+1) {
+			continue
+		}
+
+		dst := s.Instructions[candidate.Index].DstReg
+
+		if dst == candidate.Source {
+			s.Instructions[candidate.Index].SetAsNOP()
+		} else {
+			s.Instructions[candidate.Index], _ = bpf.NewInstructionFromFields(bpf.BPF_ALU64|bpf.ALU_MOV|bpf.BPF_X, dst, candidate.Source, 0, 0)
+			s.recordOrigins(candidate.Index, candidate.Index, candidate.Index+1)
+		}
+		s.Instructions[candidate.Index+1].SetAsNOP()
+
+		// The reused load may itself be a copy of an earlier one
+		kept := candidate.Reused
+		if moved, ok := s.MovedRelocations[kept]; ok {
+			kept = moved
+		}
+		if s.MovedRelocations == nil {
+			s.MovedRelocations = make(map[int]int)
+		}
+		s.MovedRelocations[candidate.Index] = kept
+		s.countApplied()
+	}
+}
+
+// isMapLoad checks if index i starts an lddw of a map named by its relocation. Such loads are
+// usually pseudo-loads; map-dedup is the one pass rewriting them, since it moves the relocation too.
+func (s *Section) isMapLoad(i int) bool {
+	return i+1 < len(s.Instructions) && s.Instructions[i].IsLoadImm64() && s.MapReferences[i] != "" && !s.CORERelocations[i]
+}
+
+// isPlainLoadImm64 checks if index i starts an lddw of a plain constant
+func (s *Section) isPlainLoadImm64(i int) bool {
+	inst := s.Instructions[i]
+	return inst.IsLoadImm64() && !inst.IsPseudoLoadImm64() && !s.Relocations[i] && !s.Relocations[i+1] && !s.CORERelocations[i]
+}
+
+// isRegisterPreserved checks that no instruction in [start, end) may change reg
+func (s *Section) isRegisterPreserved(reg uint8, start, end int) bool {
+	for k := start; k < end; k++ {
+		inst := s.Instructions[k]
+
+		// Helper calls and legacy packet loads clobber r0-r5
+		if (inst.Opcode == bpf.BPF_JMP|bpf.JMP_CALL || isLegacyPacketLoad(inst)) && reg <= 5 {
+			return false
+		}
+
+		// Atomic fetch and cmpxchg write r0 or src_reg, which the analysis does not report
+		if inst.GetInstructionClass() == bpf.BPF_STX && inst.Opcode&0xE0 == bpf.BPF_ATOMIC {
+			return false
+		}
+
+		if analyzeInstruction(inst).UpdatedReg == int(reg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// blockStarts returns the sorted first instruction of every basic block. The CFG keeps a
+// tail call inside its block as Merlin does, but passes looking at straight-line code must
+// not reason across it: a successful tail call never comes back, so the instruction after
+// it starts a block here.
+func (s *Section) blockStarts() []int {
+	isStart := make(map[int]bool)
+	if s.ControlFlowGraph != nil {
+		for node := range s.ControlFlowGraph.NodesLen {
+			isStart[node] = true
+		}
+	}
+	for i, inst := range s.Instructions {
+		if inst.IsTailCall() && i+1 < len(s.Instructions) {
+			isStart[i+1] = true
+		}
+	}
+
+	starts := make([]int, 0, len(isStart))
+	for node := range isStart {
+		starts = append(starts, node)
+	}
+	sort.Ints(starts)
+	return starts
+}
@@ -0,0 +1,136 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+func TestApplyLoadImm64Dedup(t *testing.T) {
+	const lo, hi = "88776655", "44332211" // 0x1122334455667788
+
+	tests := []struct {
+		name        string
+		hex         []string
+		relocations map[int]bool
+		expected    map[int]string // index -> expected raw, other instructions are unchanged
+	}{
+		{
+			name: "constant resident in another register",
+			hex: []string{
+				"18010000" + lo, "00000000" + hi, // 0: r1 = 0x1122334455667788 ll
+				"b700000000000000",               // 2: r0 = 0
+				"18020000" + lo, "00000000" + hi, // 3: r2 = 0x1122334455667788 ll
+				"bf10000000000000", // 5: r0 = r1
+				"0f20000000000000", // 6: r0 += r2
+				"9500000000000000", // 7: exit
+			},
+			expected: map[int]string{3: "bf12000000000000", 4: bpf.NOP},
+		},
+		{
+			name: "same register reloaded",
+			hex: []string{
+				"18010000" + lo, "00000000" + hi, // 0: r1 = 0x1122334455667788 ll
+				"bf10000000000000",               // 2: r0 = r1
+				"18010000" + lo, "00000000" + hi, // 3: r1 = 0x1122334455667788 ll
+				"0f10000000000000", // 5: r0 += r1
+				"9500000000000000", // 6: exit
+			},
+			expected: map[int]string{3: bpf.NOP, 4: bpf.NOP},
+		},
+		{
+			name: "relocated load is kept",
+			hex: []string{
+				"1801000000000000", "0000000000000000", // 0: r1 = map ll (relocated)
+				"1802000000000000", "0000000000000000", // 2: r2 = map ll (relocated)
+				"9500000000000000", // 4: exit
+			},
+			relocations: map[int]bool{0: true, 2: true},
+		},
+		{
+			name: "only the later load is relocated",
+			hex: []string{
+				"1801000000000000", "0000000000000000", // 0: r1 = 0 ll
+				"1802000000000000", "0000000000000000", // 2: r2 = map ll (relocated)
+				"9500000000000000", // 4: exit
+			},
+			relocations: map[int]bool{2: true},
+		},
+		{
+			name: "pseudo map fd load is kept",
+			hex: []string{
+				"1811000001000000", "0000000000000000", // 0: r1 = map_fd(1)
+				"1812000001000000", "0000000000000000", // 2: r2 = map_fd(1)
+				"9500000000000000", // 4: exit
+			},
+		},
+		{
+			name: "register clobbered by a helper call",
+			hex: []string{
+				"18010000" + lo, "00000000" + hi, // 0: r1 = 0x1122334455667788 ll
+				"8500000005000000",               // 2: call 5
+				"18020000" + lo, "00000000" + hi, // 3: r2 = 0x1122334455667788 ll
+				"bf20000000000000", // 5: r0 = r2
+				"9500000000000000", // 6: exit
+			},
+		},
+		{
+			name: "tail call ends the straight-line code",
+			hex: []string{
+				"18060000" + lo, "00000000" + hi, // 0: r6 = 0x1122334455667788 ll
+				"850000000c000000",               // 2: call bpf_tail_call
+				"18070000" + lo, "00000000" + hi, // 3: r7 = 0x1122334455667788 ll
+				"bf70000000000000", // 5: r0 = r7
+				"9500000000000000", // 6: exit
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section, err := NewSection(strings.Join(tt.hex, ""), "test", true)
+			if err != nil {
+				t.Fatalf("NewSection() error = %v", err)
+			}
+			section.Relocations = tt.relocations
+
+			section.applyLoadImm64Dedup()
+
+			for i, raw := range tt.hex {
+				want, ok := tt.expected[i]
+				if !ok {
+					want = raw
+				}
+				if got := section.Instructions[i].Raw; got != want {
+					t.Errorf("instruction %d = %s, expected %s", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPseudoLoadImm64NeverOptimized(t *testing.T) {
+	insts := []string{
+		"1821000000000000", // 0: r1 = map_value(0) + 8 ll, overwritten before it is read
+		"0000000008000000", // 1
+		"bf61000000000000", // 2: r1 = r6
+		"1822000000000000", // 3: r2 = map_value(0) + 8 ll, the same load again
+		"0000000008000000", // 4
+		"7b21000000000000", // 5: *(u64 *)(r1 + 0) = r2
+		"b700000000000000", // 6: r0 = 0
+		"9500000000000000", // 7: exit
+	}
+
+	// No relocation is known here, the src_reg alone must keep the passes away
+	section, err := NewSection(strings.Join(insts, ""), "test", false)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	for _, i := range []int{0, 1, 3, 4} {
+		if got := section.Instructions[i].Raw; got != insts[i] {
+			t.Errorf("instruction %d = %s, expected the pseudo-load %s to be kept", i, got, insts[i])
+		}
+	}
+}
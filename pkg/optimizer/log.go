@@ -0,0 +1,57 @@
+package optimizer
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// LogLevel orders log messages from the most to the least important
+type LogLevel int
+
+const (
+	LogError LogLevel = iota
+	LogWarn
+	LogInfo
+	LogDebug
+)
+
+// Logger writes the messages at or above Level: errors and warnings to ErrorOutput,
+// the rest to Output. A nil writer drops its messages.
+type Logger struct {
+	Output      io.Writer
+	ErrorOutput io.Writer
+	Level       LogLevel
+}
+
+func (l *Logger) Errorf(format string, args ...any) { l.logf(LogError, format, args...) }
+func (l *Logger) Warnf(format string, args ...any)  { l.logf(LogWarn, format, args...) }
+func (l *Logger) Infof(format string, args ...any)  { l.logf(LogInfo, format, args...) }
+func (l *Logger) Debugf(format string, args ...any) { l.logf(LogDebug, format, args...) }
+
+// Enabled checks if messages at level are written, to skip work only done for them
+func (l *Logger) Enabled(level LogLevel) bool {
+	return l != nil && level <= l.Level
+}
+
+func (l *Logger) logf(level LogLevel, format string, args ...any) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	w := l.Output
+	if level <= LogWarn {
+		w = l.ErrorOutput
+	}
+	if w != nil {
+		fmt.Fprintf(w, format+"\n", args...)
+	}
+}
+
+// logger receives the debug output of the analysis and the passes, hidden by default
+var logger = &Logger{Output: os.Stdout, ErrorOutput: os.Stderr, Level: LogInfo}
+
+// SetLogger selects where the optimizer logs, nil to drop everything
+func SetLogger(l *Logger) {
+	logger = l
+}
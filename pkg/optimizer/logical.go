@@ -0,0 +1,49 @@
+package optimizer
+
+import (
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// LogicalInstruction is one instruction as the ISA counts them: a single slot, or all the
+// slots of a wide instruction such as lddw
+type LogicalInstruction struct {
+	Index  int                // first slot in Section.Instructions
+	Offset int                // byte offset in the section
+	Slots  []*bpf.Instruction // the first slot decides the width
+}
+
+// Width returns the encoded size of the instruction in bytes
+func (l LogicalInstruction) Width() int {
+	return len(l.Slots) * bpf.InstructionSize
+}
+
+// Bytes encodes all the slots of the instruction
+func (l LogicalInstruction) Bytes() ([]byte, error) {
+	data := make([]byte, 0, l.Width())
+
+	var err error
+	for _, slot := range l.Slots {
+		if data, err = slot.AppendBytes(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// LogicalInstructions returns the instructions of the section in order, a wide one once with
+// all of its slots. A wide instruction cut off by the end of the section keeps the slots it has.
+func (s *Section) LogicalInstructions() []LogicalInstruction {
+	return logicalInstructions(s.Instructions)
+}
+
+func logicalInstructions(insts []*bpf.Instruction) []LogicalInstruction {
+	result := make([]LogicalInstruction, 0, len(insts))
+
+	for i := 0; i < len(insts); {
+		end := min(i+insts[i].Width()/bpf.InstructionSize, len(insts))
+		result = append(result, LogicalInstruction{Index: i, Offset: i * bpf.InstructionSize, Slots: insts[i:end]})
+		i = end
+	}
+
+	return result
+}
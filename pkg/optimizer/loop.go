@@ -1,5 +1,11 @@
 package optimizer
 
+import (
+	"sort"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
 // LoopInfo represents information about a detected loop
 type LoopInfo struct {
 	Head      int             // loop head basic block
@@ -185,6 +191,58 @@ func (s *Section) detectLoop(start, stop int, nodes map[int][]int, visited map[i
 	return removeDuplicates(path)
 }
 
+// FindUnterminatedLoops returns the heads of loops from which no exit of the same function
+// is reachable, sorted. The verifier rejects such loops, so reporting them gives feedback
+// before loading. A loop head is the target of a back edge that detectLoop finds a path back
+// to; an exit inside a BPF-to-BPF callee only returns to the loop and does not count.
+func (s *Section) FindUnterminatedLoops() []int {
+	heads := make([]int, 0)
+	cfg := s.ControlFlowGraph
+	if cfg == nil {
+		return heads
+	}
+
+	for _, node := range s.loopHeads(cfg) {
+		terminated := false
+		for block := range cfg.reachableBlocks(node, false) {
+			for i := block; i < block+cfg.NodesLen[block] && i < len(s.Instructions); i++ {
+				if s.Instructions[i].Opcode == bpf.BPF_JMP|bpf.JMP_EXIT {
+					terminated = true
+				}
+			}
+		}
+		if !terminated {
+			heads = append(heads, node)
+		}
+	}
+
+	return heads
+}
+
+// loopHeads returns the sorted blocks that are the target of a back edge
+// and that detectLoop finds a path back to, the check findLoopCandidates applies
+func (s *Section) loopHeads(cfg *ControlFlowGraph) []int {
+	backEdgeTargets := make(map[int]bool)
+	for source, successors := range cfg.Nodes {
+		for _, succ := range successors {
+			if succ <= source {
+				backEdgeTargets[succ] = true
+			}
+		}
+	}
+
+	heads := make([]int, 0)
+	for node := range backEdgeTargets {
+		if _, isBlock := cfg.NodesLen[node]; !isBlock || contains(s.detectLoop(node, node, cfg.Nodes, nil), -1) {
+			continue
+		}
+		heads = append(heads, node)
+	}
+
+	sort.Ints(heads)
+	return heads
+}
+
 // 需要检查整个切片是否包含-1
 func contains(slice []int, value int) bool {
 	for _, v := range slice {
@@ -2,6 +2,7 @@ package optimizer
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/beepfd/bpf-optimizer/pkg/bpf"
@@ -397,3 +398,57 @@ func Test_buildLoopState(t *testing.T) {
 		})
 	}
 }
+
+func TestFindUnterminatedLoops(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  []string
+		want []int
+	}{
+		{
+			name: "infinite loop",
+			hex: []string{
+				"b700000000000000", // 0: r0 = 0
+				"0700000001000000", // 1: r0 += 1
+				"0500feff00000000", // 2: goto -2
+				"9500000000000000", // 3: exit
+			},
+			want: []int{1},
+		},
+		{
+			name: "counted loop",
+			hex: []string{
+				"b701000000000000", // 0: r1 = 0
+				"0701000001000000", // 1: r1 += 1
+				"a501feff0a000000", // 2: if r1 < 10 goto -2
+				"b700000000000000", // 3: r0 = 0
+				"9500000000000000", // 4: exit
+			},
+			want: []int{},
+		},
+		{
+			name: "exit only inside the called function",
+			hex: []string{
+				"b700000000000000", // 0: r0 = 0
+				"8510000001000000", // 1: call pc+1
+				"0500fdff00000000", // 2: goto -3
+				"b700000000000000", // 3: callee: r0 = 0
+				"9500000000000000", // 4: exit
+			},
+			want: []int{0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section, err := NewSection(strings.Join(tt.hex, ""), "test", true)
+			if err != nil {
+				t.Fatalf("NewSection() error = %v", err)
+			}
+
+			if got := section.FindUnterminatedLoops(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindUnterminatedLoops() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
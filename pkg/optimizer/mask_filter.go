@@ -17,7 +17,7 @@ func findMaskCandidates(instructions []*bpf.Instruction) []int {
 		inst1 := instructions[i]
 		inst2 := instructions[i+1]
 
-		if inst1.Opcode == bpf.BPF_LDDW && inst2.Opcode == bpf.BPF_IMM && inst1.SrcReg == 0 {
+		if inst1.Opcode == bpf.BPF_LDDW && inst2.Opcode == bpf.BPF_IMM && !inst1.IsPseudoLoadImm64() {
 			if inst2.Imm != 0 {
 				continue
 			}
@@ -26,7 +26,7 @@ func findMaskCandidates(instructions []*bpf.Instruction) []int {
 			// The raw string is 16 characters, and we need to extract the immediate value
 			imm1Hex := inst1.GetRawImm()
 			if imm1Hex == "" {
-				fmt.Printf("inst1: %s,index: %d, imm1Hex is empty\n", inst1.String(), i)
+				logger.Debugf("inst1: %s,index: %d, imm1Hex is empty", inst1.String(), i)
 				continue
 			}
 
@@ -115,23 +115,23 @@ func findCandidates(s *Section, maskCandidates []int) [][]int {
 func applyPeepholeOptimization(s *Section, candidates [][]int) {
 	// Apply peephole optimization
 	for _, candidate := range candidates {
-		var newHex string
-		var targetInst *bpf.Instruction
+		if !s.inRange(candidate...) || !s.inRange(candidate[0]+1) {
+			continue
+		}
+
+		var newInst *bpf.Instruction
 
 		if len(candidate) == 3 {
 			// 3-element case: [mask, item, include_pre]
+			// The MOV feeding the AND is folded into a zero-extending mov32 with the same operands
 			preInst := s.Instructions[candidate[2]]
-			newHex = fmt.Sprintf("bc%s%s000000000000", preInst.Raw[2:3], preInst.Raw[3:4])
-			targetInst = s.Instructions[candidate[1]]
+			newInst = newMov32(preInst.DstReg, preInst.SrcReg)
 		} else {
 			// 2-element case: [mask, item]
-			targetInst = s.Instructions[candidate[1]]
-			targetReg := targetInst.Raw[3:4]
-			newHex = fmt.Sprintf("bc%s%s000000000000", targetReg, targetReg)
+			targetInst := s.Instructions[candidate[1]]
+			newInst = newMov32(targetInst.DstReg, targetInst.DstReg)
 		}
 
-		newInst, _ := bpf.NewInstruction(newHex)
-
 		// Apply optimizations based on candidate length
 		for i, idx := range candidate {
 			if i == 1 {
@@ -145,5 +145,12 @@ func applyPeepholeOptimization(s *Section, candidates [][]int) {
 
 		// Always set mask+1 instruction as NOP (second part of 64-bit load)
 		s.Instructions[candidate[0]+1].SetAsNOP()
+		s.countApplied()
 	}
 }
+
+// newMov32 builds `w{dst} = w{src}`, which zero-extends the upper 32 bits of dst
+func newMov32(dst, src uint8) *bpf.Instruction {
+	inst, _ := bpf.NewInstructionFromFields(bpf.BPF_ALU|bpf.ALU_MOV|bpf.BPF_X, dst, src, 0, 0)
+	return inst
+}
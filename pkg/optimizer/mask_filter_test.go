@@ -333,7 +333,7 @@ func TestApplyPeepholeOptimization(t *testing.T) {
 				Instructions: []*bpf.Instruction{
 					createInstructionWithRaw("18000000ffffffff", 0x18, 0), // mask instruction
 					createInstructionWithRaw("0000000000000000", 0x00, 0), // mask part 2
-					createInstructionWithRaw("5701000000000000", bpf.ALU_AND_K, 0), // AND operation with dst reg 1
+					createTestInstruction("5701000000000000"), // AND operation with dst reg 1
 				},
 			},
 			candidates: [][]int{{0, 2}}, // mask and AND instruction
@@ -345,8 +345,8 @@ func TestApplyPeepholeOptimization(t *testing.T) {
 				Instructions: []*bpf.Instruction{
 					createInstructionWithRaw("18000000ffffffff", 0x18, 0), // mask instruction
 					createInstructionWithRaw("0000000000000000", 0x00, 0), // mask part 2
-					createInstructionWithRaw("b723000000000000", bpf.ALU_MOV_K, 0), // MOV operation with dst reg 2, src reg 3
-					createInstructionWithRaw("5701000000000000", bpf.ALU_AND_K, 0), // AND operation with dst reg 1
+					createTestInstruction("b723000000000000"), // MOV operation with dst reg 3, src reg 2
+					createTestInstruction("5701000000000000"), // AND operation with dst reg 1
 				},
 			},
 			candidates: [][]int{{0, 3, 2}}, // mask, AND, MOV instruction
@@ -358,10 +358,10 @@ func TestApplyPeepholeOptimization(t *testing.T) {
 				Instructions: []*bpf.Instruction{
 					createInstructionWithRaw("18000000ffffffff", 0x18, 0), // mask 1
 					createInstructionWithRaw("0000000000000000", 0x00, 0), // mask 1 part 2
-					createInstructionWithRaw("5701000000000000", bpf.ALU_AND_K, 0), // AND 1 with dst reg 1
+					createTestInstruction("5701000000000000"), // AND 1 with dst reg 1
 					createInstructionWithRaw("18000000ffff0000", 0x18, 0), // mask 2
 					createInstructionWithRaw("0000000000000000", 0x00, 0), // mask 2 part 2
-					createInstructionWithRaw("5702000000000000", bpf.ALU_AND_K, 0), // AND 2 with dst reg 2
+					createTestInstruction("5702000000000000"), // AND 2 with dst reg 2
 				},
 			},
 			candidates: [][]int{{0, 2}, {3, 5}}, // two 2-element optimizations
@@ -373,11 +373,11 @@ func TestApplyPeepholeOptimization(t *testing.T) {
 				Instructions: []*bpf.Instruction{
 					createInstructionWithRaw("18000000ffffffff", 0x18, 0), // mask 1
 					createInstructionWithRaw("0000000000000000", 0x00, 0), // mask 1 part 2
-					createInstructionWithRaw("5701000000000000", bpf.ALU_AND_K, 0), // AND 1 with dst reg 1
+					createTestInstruction("5701000000000000"), // AND 1 with dst reg 1
 					createInstructionWithRaw("18000000ffff0000", 0x18, 0), // mask 2
 					createInstructionWithRaw("0000000000000000", 0x00, 0), // mask 2 part 2
-					createInstructionWithRaw("b734000000000000", bpf.ALU_MOV_K, 0), // MOV with dst reg 3, src reg 4
-					createInstructionWithRaw("5702000000000000", bpf.ALU_AND_K, 0), // AND 2 with dst reg 2
+					createTestInstruction("b734000000000000"), // MOV with dst reg 4, src reg 3
+					createTestInstruction("5702000000000000"), // AND 2 with dst reg 2
 				},
 			},
 			candidates: [][]int{{0, 2}, {3, 6, 5}}, // 2-element and 3-element optimizations
@@ -389,12 +389,25 @@ func TestApplyPeepholeOptimization(t *testing.T) {
 				Instructions: []*bpf.Instruction{
 					createInstructionWithRaw("18000000ffffffff", 0x18, 0), // mask
 					createInstructionWithRaw("0000000000000000", 0x00, 0), // mask part 2
-					createInstructionWithRaw("5709000000000000", bpf.ALU_AND_K, 0), // AND with dst reg 9
+					createTestInstruction("5709000000000000"), // AND with dst reg 9
 				},
 			},
 			candidates: [][]int{{0, 2}}, // 2-element optimization
 			expected:   []string{bpf.NOP, bpf.NOP, "bc99000000000000"}, // AND->optimized with reg 9
 		},
+		{
+			name: "3-element optimization with distinct MOV registers",
+			section: &Section{
+				Instructions: []*bpf.Instruction{
+					createInstructionWithRaw("18000000ffffffff", 0x18, 0), // mask instruction
+					createInstructionWithRaw("0000000000000000", 0x00, 0), // mask part 2
+					createTestInstruction("bf32000000000000"),             // mov r2, r3
+					createTestInstruction("5702000000000000"),             // AND with dst reg 2
+				},
+			},
+			candidates: [][]int{{0, 3, 2}},
+			expected:   []string{bpf.NOP, bpf.NOP, bpf.NOP, "bc32000000000000"}, // w2 = w3
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,117 @@
+package optimizer
+
+import (
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// merlinPassOrder lists the passes of the reference Merlin implementation, in its order.
+// The golden fixtures in testdata come from this pipeline.
+var merlinPassOrder = []string{PassConstantPropagation, PassCompaction, PassPeephole}
+
+// Divergence is an instruction where the Go optimizer and Merlin disagree.
+// Got or Want is nil when that side has fewer instructions.
+type Divergence struct {
+	Section string
+	Index   int
+	Got     *bpf.Instruction
+	Want    *bpf.Instruction
+}
+
+// CompareInstructions returns the instructions of the section that differ from want
+func (s *Section) CompareInstructions(want []*bpf.Instruction) []Divergence {
+	divergences := make([]Divergence, 0)
+
+	for i := 0; i < len(s.Instructions) || i < len(want); i++ {
+		var got, expected *bpf.Instruction
+		if i < len(s.Instructions) {
+			got = s.Instructions[i]
+		}
+		if i < len(want) {
+			expected = want[i]
+		}
+
+		if got == nil || expected == nil || got.Raw != expected.Raw {
+			divergences = append(divergences, Divergence{Section: s.Name, Index: i, Got: got, Want: expected})
+		}
+	}
+
+	return divergences
+}
+
+// CompareMerlin optimizes inputPath with the passes Merlin implements and compares every
+// section against the same byte range of refPath, an object optimized by Merlin
+func CompareMerlin(inputPath, refPath string, opts ProgramOptions) ([]Divergence, error) {
+	opts.SkipOptimization = true
+	prog, err := NewBPFProgramWithOptions(inputPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer prog.Close()
+
+	ref, err := elf.Open(refPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open reference %s: %w", refPath, err)
+	}
+	defer ref.Close()
+
+	names := make([]string, 0, len(prog.Sections))
+	for name := range prog.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	divergences := make([]Divergence, 0)
+	for _, name := range names {
+		section := prog.Sections[name]
+		for _, pass := range merlinPassOrder {
+			section.ApplyPass(pass)
+		}
+
+		refSection := ref.Section(name)
+		if refSection == nil {
+			return nil, fmt.Errorf("section %s not found in reference %s", name, refPath)
+		}
+		data, err := refSection.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read reference section %s: %w", name, err)
+		}
+
+		// Trailing bytes are not instructions and are not optimized on either side
+		end := section.Offset + section.Size - uint64(len(section.Trailing))
+		if end > uint64(len(data)) {
+			return nil, fmt.Errorf("reference section %s has %d bytes, expected at least %d", name, len(data), end)
+		}
+
+		want, err := decodeInstructions(data[section.Offset:end])
+		if err != nil {
+			return nil, fmt.Errorf("reference section %s: %w", name, err)
+		}
+		divergences = append(divergences, section.CompareInstructions(want)...)
+	}
+
+	return divergences, nil
+}
+
+// decodeInstructions parses raw bytecode without building any analysis
+func decodeInstructions(data []byte) ([]*bpf.Instruction, error) {
+	hexData := hex.EncodeToString(data)
+	if len(hexData)%16 != 0 {
+		return nil, &ErrSectionLengthNotMultiple{Length: len(hexData)}
+	}
+
+	insts := make([]*bpf.Instruction, 0, len(hexData)/16)
+	for i := 0; i < len(hexData); i += 16 {
+		inst, err := bpf.NewInstruction(hexData[i : i+16])
+		if err != nil {
+			return nil, &ErrMalformedInstruction{Index: i / 16, Raw: hexData[i : i+16], Err: err}
+		}
+		insts = append(insts, inst)
+	}
+
+	return insts, nil
+}
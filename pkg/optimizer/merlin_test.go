@@ -0,0 +1,103 @@
+package optimizer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+	"github.com/beepfd/bpf-optimizer/tool"
+)
+
+func TestCompareInstructionsMerlinFixture(t *testing.T) {
+	hexData, err := os.ReadFile("../../testdata/section_data")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	optimizedData, err := os.ReadFile("../../testdata/section_data_optimized")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	raws, err := tool.ParsePythonSliceInt(string(optimizedData))
+	if err != nil {
+		t.Fatalf("ParsePythonSliceInt() error = %v", err)
+	}
+
+	want := make([]*bpf.Instruction, len(raws))
+	for i, raw := range raws {
+		if want[i], err = bpf.NewInstruction(raw); err != nil {
+			t.Fatalf("NewInstruction(%s) error = %v", raw, err)
+		}
+	}
+
+	section, err := NewSection(string(hexData), ".text", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	for _, pass := range merlinPassOrder {
+		section.ApplyPass(pass)
+	}
+
+	if divergences := section.CompareInstructions(want); len(divergences) != 0 {
+		t.Errorf("got %d divergences from Merlin, first at %d: %v != %v",
+			len(divergences), divergences[0].Index, divergences[0].Got, divergences[0].Want)
+	}
+}
+
+func TestCompareInstructionsReportsDivergence(t *testing.T) {
+	section := createTestSection([]string{
+		"b701000000000000",
+		"b702000000000000",
+	})
+	want := make([]*bpf.Instruction, 0)
+	for _, raw := range []string{"b701000000000000", "b702000001000000", "9500000000000000"} {
+		inst, _ := bpf.NewInstruction(raw)
+		want = append(want, inst)
+	}
+
+	divergences := section.CompareInstructions(want)
+	if len(divergences) != 2 {
+		t.Fatalf("got %d divergences, want 2", len(divergences))
+	}
+	if divergences[0].Index != 1 || divergences[0].Got.Raw != "b702000000000000" {
+		t.Errorf("first divergence = %+v, want index 1", divergences[0])
+	}
+	if divergences[1].Index != 2 || divergences[1].Got != nil || divergences[1].Want.Raw != "9500000000000000" {
+		t.Errorf("second divergence = %+v, want a missing instruction at 2", divergences[1])
+	}
+}
+
+func TestCompareMerlin(t *testing.T) {
+	// Stand in for a Merlin object by saving the same pass subset
+	prog, err := NewBPFProgramWithOptions(testObjectFile, ProgramOptions{SkipOptimization: true})
+	if err != nil {
+		t.Fatalf("NewBPFProgramWithOptions() error = %v", err)
+	}
+	defer prog.Close()
+	for _, section := range prog.Sections {
+		for _, pass := range merlinPassOrder {
+			section.ApplyPass(pass)
+		}
+	}
+	refPath := filepath.Join(t.TempDir(), "ref.o")
+	if err := prog.Save(refPath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	divergences, err := CompareMerlin(testObjectFile, refPath, ProgramOptions{})
+	if err != nil {
+		t.Fatalf("CompareMerlin() error = %v", err)
+	}
+	if len(divergences) != 0 {
+		t.Errorf("got %d divergences against the same pipeline, first %+v", len(divergences), divergences[0])
+	}
+
+	// The unoptimized input differs wherever a pass rewrote something
+	divergences, err = CompareMerlin(testObjectFile, testObjectFile, ProgramOptions{})
+	if err != nil {
+		t.Fatalf("CompareMerlin() error = %v", err)
+	}
+	if len(divergences) == 0 {
+		t.Errorf("expected divergences against the unoptimized input")
+	}
+}
@@ -0,0 +1,177 @@
+package optimizer
+
+import (
+	"sort"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// OptimizationStats holds the verifier-relevant size of a section before and after applyOptimizations.
+// No-ops are left out of both counts: they are dropped by the kernel and stand in for removed instructions.
+type OptimizationStats struct {
+	InstructionsBefore int
+	InstructionsAfter  int
+	BranchesBefore     int
+	BranchesAfter      int
+}
+
+// countActiveInstructions returns the number of instructions that are not no-ops.
+// An lddw counts once: its high slot only holds the upper half of the constant and the
+// verifier steps over it, so it is not an instruction of its own.
+func countActiveInstructions(insts []*bpf.Instruction) int {
+	count := 0
+	for _, inst := range logicalInstructions(insts) {
+		if !inst.Slots[0].IsNOP() {
+			count++
+		}
+	}
+	return count
+}
+
+// ActiveInstructionCount returns the number of instructions that are not no-ops,
+// counting each lddw once as countActiveInstructions does
+func (s *Section) ActiveInstructionCount() int {
+	return countActiveInstructions(s.Instructions)
+}
+
+// InstructionClassHistogram counts the instructions that are not no-ops by mnemonic (stdw, call,
+// jeq, ...), counting each lddw once so the counts add up to ActiveInstructionCount
+func (s *Section) InstructionClassHistogram() map[string]int {
+	histogram := make(map[string]int)
+	for _, inst := range s.LogicalInstructions() {
+		if !inst.Slots[0].IsNOP() {
+			histogram[inst.Slots[0].Mnemonic()]++
+		}
+	}
+	return histogram
+}
+
+// classNames names the instruction classes as StatsDiff reports them
+var classNames = map[uint8]string{
+	bpf.BPF_LD:    "ld",
+	bpf.BPF_LDX:   "ldx",
+	bpf.BPF_ST:    "st",
+	bpf.BPF_STX:   "stx",
+	bpf.BPF_ALU:   "alu",
+	bpf.BPF_JMP:   "jmp",
+	bpf.BPF_JMP32: "jmp32",
+	bpf.BPF_ALU64: "alu64",
+}
+
+// StatsDiff is the change in instruction counts from one section to another, negative for
+// instructions eliminated. Counts are those of InstructionClassHistogram; classes and
+// mnemonics whose count did not change are left out.
+type StatsDiff struct {
+	Total     int            `json:"total"`
+	Classes   map[string]int `json:"classes"`   // by instruction class: ld, ldx, st, stx, alu, jmp, jmp32, alu64
+	Mnemonics map[string]int `json:"mnemonics"` // by mnemonic: stdw, call, jeq, ...
+}
+
+// DiffStats compares the instructions that are not no-ops in before and after, for instance a
+// section optimized under two configurations or before and after a pass
+func DiffStats(before, after *Section) StatsDiff {
+	diff := StatsDiff{
+		Total:     after.ActiveInstructionCount() - before.ActiveInstructionCount(),
+		Classes:   classHistogram(after),
+		Mnemonics: after.InstructionClassHistogram(),
+	}
+
+	for class, count := range classHistogram(before) {
+		diff.Classes[class] -= count
+	}
+	for mnemonic, count := range before.InstructionClassHistogram() {
+		diff.Mnemonics[mnemonic] -= count
+	}
+
+	for _, deltas := range []map[string]int{diff.Classes, diff.Mnemonics} {
+		for key, delta := range deltas {
+			if delta == 0 {
+				delete(deltas, key)
+			}
+		}
+	}
+	return diff
+}
+
+// classHistogram counts the instructions that are not no-ops by class, each lddw once
+func classHistogram(s *Section) map[string]int {
+	histogram := make(map[string]int)
+	for _, inst := range s.LogicalInstructions() {
+		if !inst.Slots[0].IsNOP() {
+			histogram[classNames[inst.Slots[0].GetInstructionClass()]]++
+		}
+	}
+	return histogram
+}
+
+// countBranches returns the number of jumps that are not no-ops
+func countBranches(insts []*bpf.Instruction) int {
+	count := 0
+	for _, inst := range insts {
+		if inst.IsJump() && !inst.IsNOP() {
+			count++
+		}
+	}
+	return count
+}
+
+// NOPInfo classifies a no-op as written by a pass or already present in the input
+type NOPInfo struct {
+	Index     int
+	Synthetic bool
+}
+
+// NOPIndices returns the index of every no-op instruction, in order
+func (s *Section) NOPIndices() []int {
+	indices := make([]int, 0)
+	for i, inst := range s.Instructions {
+		if inst.IsNOP() {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// ClassifyNOPs tells the no-ops left by the passes from those in the input, like a `goto +0`
+// emitted by the compiler. Sections not built from hex data have no InputNOPs, so all are synthetic.
+func (s *Section) ClassifyNOPs() []NOPInfo {
+	nops := make([]NOPInfo, 0)
+	for _, idx := range s.NOPIndices() {
+		nops = append(nops, NOPInfo{Index: idx, Synthetic: !s.InputNOPs[idx]})
+	}
+	return nops
+}
+
+// Verifier instruction limits a budget is commonly checked against
+const (
+	InstructionLimitLegacy     = 4096    // BPF_MAXINSNS, the limit before 5.2 and for unprivileged loads
+	InstructionLimitPrivileged = 1000000 // BPF_COMPLEXITY_LIMIT_INSNS for privileged loads since 5.2
+)
+
+// BudgetUsage compares the active instruction count of a section with an instruction budget
+type BudgetUsage struct {
+	Section string
+	Active  int
+	Budget  int
+}
+
+// Exceeded reports whether the section has more active instructions than the budget allows
+func (u BudgetUsage) Exceeded() bool {
+	return u.Active > u.Budget
+}
+
+// CheckBudget returns the budget usage of every section, ordered by section name
+func (prog *BPFProgram) CheckBudget(budget int) []BudgetUsage {
+	names := make([]string, 0, len(prog.Sections))
+	for name := range prog.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	usage := make([]BudgetUsage, 0, len(names))
+	for _, name := range names {
+		active := prog.Sections[name].ActiveInstructionCount()
+		usage = append(usage, BudgetUsage{Section: name, Active: active, Budget: budget})
+	}
+	return usage
+}
@@ -0,0 +1,247 @@
+package optimizer
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptimizationStatsBranches(t *testing.T) {
+	tests := []struct {
+		name     string
+		insts    []string
+		expected OptimizationStats
+	}{
+		{
+			name: "no-op jump removed",
+			insts: []string{
+				"b700000000000000", // r0 = 0
+				"1501000000000000", // if r1 == 0 goto +0
+				"5501010000000000", // if r1 != 0 goto +1
+				"b700000001000000", // r0 = 1
+				"9500000000000000", // exit
+			},
+			expected: OptimizationStats{InstructionsBefore: 5, InstructionsAfter: 4, BranchesBefore: 2, BranchesAfter: 1},
+		},
+		{
+			name: "branches kept",
+			insts: []string{
+				"b700000000000000", // r0 = 0
+				"5501010000000000", // if r1 != 0 goto +1
+				"b700000001000000", // r0 = 1
+				"9500000000000000", // exit
+			},
+			expected: OptimizationStats{InstructionsBefore: 4, InstructionsAfter: 4, BranchesBefore: 1, BranchesAfter: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section, err := NewSection(strings.Join(tt.insts, ""), "test", false)
+			if err != nil {
+				t.Fatalf("NewSection() error = %v", err)
+			}
+
+			if section.Stats != tt.expected {
+				t.Errorf("Stats = %+v, expected %+v", section.Stats, tt.expected)
+			}
+		})
+	}
+}
+
+func TestActiveInstructionCountLoadImm64(t *testing.T) {
+	section, err := NewSection(strings.Join([]string{
+		"1801000078563412", // r1 = 0x1234567812345678 ll
+		"0000000078563412",
+		"1802000001000000", // r2 = 1 ll, removed below
+		"0000000000000000",
+		"0500000000000000", // goto +0
+		"b700000000000000", // r0 = 0
+		"9500000000000000", // exit
+	}, ""), "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	section.Instructions[2].SetAsNOP()
+	section.Instructions[3].SetAsNOP()
+
+	// The live lddw counts once, the removed one and the goto +0 not at all
+	if got := section.ActiveInstructionCount(); got != 3 {
+		t.Errorf("ActiveInstructionCount() = %d, expected 3", got)
+	}
+}
+
+func TestCheckBudget(t *testing.T) {
+	small, err := NewSection(strings.Join([]string{
+		"b700000000000000", // r0 = 0
+		"9500000000000000", // exit
+	}, ""), "small", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	large, err := NewSection(strings.Join([]string{
+		"b700000000000000", // r0 = 0
+		"0500000000000000", // goto +0, not counted
+		"b700000001000000", // r0 = 1
+		"b700000002000000", // r0 = 2
+		"9500000000000000", // exit
+	}, ""), "large", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	prog := &BPFProgram{Sections: map[string]*Section{"small": small, "large": large}}
+	got := prog.CheckBudget(3)
+
+	want := []BudgetUsage{
+		{Section: "large", Active: 4, Budget: 3},
+		{Section: "small", Active: 2, Budget: 3},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("CheckBudget() = %+v, expected %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("CheckBudget()[%d] = %+v, expected %+v", i, got[i], want[i])
+		}
+	}
+
+	if !got[0].Exceeded() {
+		t.Errorf("section %s with %d active instructions should exceed budget %d", got[0].Section, got[0].Active, got[0].Budget)
+	}
+	if got[1].Exceeded() {
+		t.Errorf("section %s with %d active instructions should fit budget %d", got[1].Section, got[1].Active, got[1].Budget)
+	}
+}
+
+func TestClassifyNOPs(t *testing.T) {
+	prog, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	for name, section := range prog.Sections {
+		changed := make(map[int]bool)
+		for _, entry := range section.Trace {
+			for _, change := range entry.Changes {
+				changed[change.Index] = true
+			}
+		}
+
+		synthetic := 0
+		for _, nop := range section.ClassifyNOPs() {
+			if nop.Synthetic {
+				synthetic++
+				if !changed[nop.Index] {
+					t.Errorf("%s: synthetic NOP %d was not changed by any pass", name, nop.Index)
+				}
+			} else if !section.InputNOPs[nop.Index] {
+				t.Errorf("%s: NOP %d classified as original but not a NOP in the input", name, nop.Index)
+			}
+		}
+
+		if len(section.NOPIndices()) != len(section.ClassifyNOPs()) {
+			t.Errorf("%s: NOPIndices() and ClassifyNOPs() disagree", name)
+		}
+		if name == "uprobe" && synthetic == 0 {
+			t.Errorf("%s: no synthetic NOPs after optimization", name)
+		}
+	}
+}
+
+func TestClassifyNOPsInputGoto(t *testing.T) {
+	section, err := NewSection(strings.Join([]string{
+		"0500000000000000", // goto +0 from the compiler
+		"6701000020000000", // r1 <<= 32
+		"7701000020000000", // r1 >>= 32
+		"9500000000000000", // exit
+	}, ""), "test", false)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	expected := []NOPInfo{{Index: 0, Synthetic: false}, {Index: 2, Synthetic: true}}
+	got := section.ClassifyNOPs()
+	if len(got) != len(expected) {
+		t.Fatalf("ClassifyNOPs() = %+v, expected %+v", got, expected)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("ClassifyNOPs()[%d] = %+v, expected %+v", i, got[i], expected[i])
+		}
+	}
+}
+
+func TestInstructionClassHistogram(t *testing.T) {
+	prog, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	section := prog.Sections["uprobe/generic_uprobe"]
+	if section == nil {
+		t.Fatalf("section uprobe/generic_uprobe not found")
+	}
+
+	histogram := section.InstructionClassHistogram()
+	total := 0
+	for _, count := range histogram {
+		total += count
+	}
+	if want := section.ActiveInstructionCount(); total != want {
+		t.Errorf("histogram sums to %d, expected ActiveInstructionCount() = %d", total, want)
+	}
+
+	for mnemonic, want := range map[string]int{"call": 37, "stdw": 5, "jeq": 24, "lddw": 7, "exit": 1} {
+		if got := histogram[mnemonic]; got != want {
+			t.Errorf("histogram[%q] = %d, expected %d", mnemonic, got, want)
+		}
+	}
+
+	summary := prog.GetOptimizationStats()["summary"].(map[string]interface{})
+	if got := summary["histogram"].(map[string]int)["stdw"]; got < 5 {
+		t.Errorf("summary histogram stdw = %d, expected at least 5", got)
+	}
+}
+
+func TestDiffStats(t *testing.T) {
+	hexData, err := os.ReadFile("../../testdata/section_data_uprobe_raw")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	// Superword merge only sees immediate stores, the ones constant propagation leaves
+	sections := make([]*Section, 2)
+	for i := range sections {
+		if sections[i], err = NewSection(string(hexData), "uprobe", true); err != nil {
+			t.Fatalf("NewSection() error = %v", err)
+		}
+		if err := sections[i].ApplyPass(PassConstantPropagation); err != nil {
+			t.Fatalf("ApplyPass() error = %v", err)
+		}
+	}
+	before, after := sections[0], sections[1]
+	if err := after.ApplyPass(PassSuperword); err != nil {
+		t.Fatalf("ApplyPass() error = %v", err)
+	}
+
+	// Every store a merge absorbs becomes a no-op
+	diff := DiffStats(before, after)
+	if want := len(before.NOPIndices()) - len(after.NOPIndices()); diff.Total != want {
+		t.Errorf("Total = %d, expected %d", diff.Total, want)
+	}
+	if want := map[string]int{"st": -143}; !reflect.DeepEqual(diff.Classes, want) {
+		t.Errorf("Classes = %v, expected %v", diff.Classes, want)
+	}
+	if want := map[string]int{"stb": -164, "stw": 1, "stdw": 20}; !reflect.DeepEqual(diff.Mnemonics, want) {
+		t.Errorf("Mnemonics = %v, expected %v", diff.Mnemonics, want)
+	}
+
+	if same := DiffStats(before, before); same.Total != 0 || len(same.Classes) != 0 || len(same.Mnemonics) != 0 {
+		t.Errorf("DiffStats(before, before) = %+v, expected no change", same)
+	}
+}
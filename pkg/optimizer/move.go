@@ -0,0 +1,32 @@
+package optimizer
+
+import (
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// findSelfMoveCandidates finds 64-bit `r = r` moves left behind by the other passes.
+// The 32-bit `w = w` form zeroes the upper half and movsx (non-zero offset) sign-extends,
+// so only BPF_ALU64 moves with offset 0 qualify. Fillers already in the NOP encoding are skipped.
+func (s *Section) findSelfMoveCandidates() []int {
+	candidates := make([]int, 0)
+
+	for i, inst := range s.Instructions {
+		if inst.Opcode == bpf.BPF_ALU64|bpf.ALU_MOV|bpf.BPF_X && inst.DstReg == inst.SrcReg &&
+			inst.Offset == 0 && inst.Imm == 0 && !inst.IsSyntheticNOP() {
+			candidates = append(candidates, i)
+		}
+	}
+
+	return candidates
+}
+
+// applySelfMoveElimination NOPs the 64-bit self-moves
+func (s *Section) applySelfMoveElimination() {
+	for _, idx := range s.findSelfMoveCandidates() {
+		if !s.inRange(idx) {
+			continue
+		}
+		s.Instructions[idx].SetAsNOP()
+		s.countApplied()
+	}
+}
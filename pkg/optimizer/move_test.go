@@ -0,0 +1,43 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+func TestApplySelfMoveElimination(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000005000000", // r1 = 5
+		"bf11000000000000", // r1 = r1
+		"bc11000000000000", // w1 = w1, zeroes the upper half
+		"bf11080000000000", // r1 = (s8)r1
+		"bf12000000000000", // r2 = r1
+		"bf10000000000000", // r0 = r1
+		"9500000000000000", // exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	if err := section.ApplyPass(PassSelfMove); err != nil {
+		t.Fatalf("ApplyPass(%s) error = %v", PassSelfMove, err)
+	}
+
+	expected := []string{
+		"b701000005000000",
+		bpf.NOP,
+		"bc11000000000000",
+		"bf11080000000000",
+		"bf12000000000000",
+		"bf10000000000000",
+		"9500000000000000",
+	}
+	for i, want := range expected {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package optimizer
 
 import (
-	"fmt"
 	"sort"
 
 	"github.com/beepfd/bpf-optimizer/pkg/bpf"
@@ -20,13 +19,23 @@ func buildInstructionNode(insts []*bpf.Instruction, cfg *ControlFlowGraph) {
 		off := inst.Offset
 		msb := opcode & 0xF0
 		if msb == bpf.JMP_CALL {
+			// Calls return to the next instruction, so they do not end the block.
+			// A BPF-to-BPF call is recorded to make its callee a block of its own.
+			if inst.IsPseudoCall() {
+				if callee := i + int(inst.Imm) + 1; callee >= 0 && callee < len(insts) {
+					if cfg.Calls == nil {
+						cfg.Calls = make(map[int]int)
+					}
+					cfg.Calls[i] = callee
+				}
+			}
 			continue
 		}
 
 		if msb == bpf.JMP_EXIT {
 			cfg.Nodes[currentNode] = []int{}
-		} else if opcode == 5 {
-			jumpTarget := i + int(off) + 1
+		} else if opcode == 5 || opcode == bpf.BPF_GOTOL {
+			jumpTarget := unconditionalJumpTarget(inst, i)
 			// Only add valid jump targets (within bounds)
 			if jumpTarget >= 0 {
 				cfg.Nodes[currentNode] = []int{jumpTarget}
@@ -43,8 +52,8 @@ func buildInstructionNode(insts []*bpf.Instruction, cfg *ControlFlowGraph) {
 			if jumpTarget >= 0 {
 				successors = append(successors, jumpTarget)
 			}
-			// Add fall-through if valid
-			if fallThrough >= 0 {
+			// Add fall-through if valid, once when the jump lands there too (off == 0)
+			if fallThrough >= 0 && fallThrough != jumpTarget {
 				successors = append(successors, fallThrough)
 			}
 			cfg.Nodes[i] = successors
@@ -53,6 +62,14 @@ func buildInstructionNode(insts []*bpf.Instruction, cfg *ControlFlowGraph) {
 	}
 }
 
+// unconditionalJumpTarget 计算无条件跳转的目标, gotol 的偏移量在 32 位 imm 中
+func unconditionalJumpTarget(inst *bpf.Instruction, i int) int {
+	if inst.Opcode == bpf.BPF_GOTOL {
+		return i + int(inst.Imm) + 1
+	}
+	return i + int(inst.Offset) + 1
+}
+
 // buildInstructionNodeReverse 构建反向映射
 func buildInstructionNodeReverse(cfg *ControlFlowGraph) {
 	// Build reverse mapping
@@ -70,11 +87,27 @@ func buildInstructionNodeReverse(cfg *ControlFlowGraph) {
 
 // buildInstructionNodeLength 构建节点长度映射
 func buildInstructionNodeLength(insts []*bpf.Instruction, cfg *ControlFlowGraph) {
-	allNodes := make([]int, 0)
+	// Every jump target is a block boundary, including back-edge targets that land
+	// in the middle of a block already opened earlier in the section
+	boundaries := map[int]bool{0: true, len(insts): true}
 	for node := range cfg.NodesRev {
-		allNodes = append(allNodes, node)
+		boundaries[node] = true
+	}
+	for _, successors := range cfg.Nodes {
+		for _, succ := range successors {
+			boundaries[succ] = true
+		}
+	}
+	for _, callee := range cfg.Calls {
+		boundaries[callee] = true
+	}
+
+	allNodes := make([]int, 0, len(boundaries))
+	for node := range boundaries {
+		if node >= 0 && node <= len(insts) {
+			allNodes = append(allNodes, node)
+		}
 	}
-	allNodes = append(allNodes, 0, len(insts))
 
 	// Sort nodes efficiently using Go's built-in sort
 	sort.Ints(allNodes)
@@ -105,13 +138,13 @@ func rebuildInstructionNodeRev(insts []*bpf.Instruction, cfg *ControlFlowGraph)
 	sort.Ints(sortedNodes)
 	
 	// Debug: Log node processing order for 4810 area
-	fmt.Printf("DEBUG: rebuildInstructionNodeRev - Node processing order around 4810: ")
+	debugNodes := make([]int, 0)
 	for _, node := range sortedNodes {
 		if node >= 4800 && node <= 4820 {
-			fmt.Printf("%d ", node)
+			debugNodes = append(debugNodes, node)
 		}
 	}
-	fmt.Printf("\n")
+	logger.Debugf("DEBUG: rebuildInstructionNodeRev - Node processing order around 4810: %v", debugNodes)
 	
 	for _, node := range sortedNodes {
 		nodeLen := cfg.NodesLen[node]
@@ -133,8 +166,8 @@ func rebuildInstructionNodeRev(insts []*bpf.Instruction, cfg *ControlFlowGraph)
 				} else if msb == bpf.JMP_EXIT {
 					// Exit instructions don't have successors
 					continue
-				} else if opcode == 0x05 { // Unconditional jump
-					jumpTarget := instIdx + int(off) + 1
+				} else if opcode == 0x05 || opcode == bpf.BPF_GOTOL { // Unconditional jump
+					jumpTarget := unconditionalJumpTarget(inst, instIdx)
 					if jumpTarget >= 0 && jumpTarget < len(insts) {
 						// Record that 'node' jumps to 'jumpTarget'
 						if _, exists := cfg.NodesRev[jumpTarget]; exists {
@@ -152,8 +185,8 @@ func rebuildInstructionNodeRev(insts []*bpf.Instruction, cfg *ControlFlowGraph)
 							cfg.NodesRev[jumpTarget] = append(cfg.NodesRev[jumpTarget], instIdx)
 						}
 					}
-					// Record fall-through
-					if fallThrough >= 0 && fallThrough < len(insts) {
+					// Record fall-through, unless the jump already lands there (off == 0)
+					if fallThrough >= 0 && fallThrough < len(insts) && fallThrough != jumpTarget {
 						if _, exists := cfg.NodesRev[fallThrough]; exists {
 							cfg.NodesRev[fallThrough] = append(cfg.NodesRev[fallThrough], instIdx)
 						}
@@ -198,3 +231,70 @@ func updateInstructionNode(cfg *ControlFlowGraph) {
 		sort.Ints(cfg.NodesRev[target])
 	}
 }
+
+// ReachableBlocks returns the blocks reachable from the block at entry, following
+// jumps, fall-through and BPF-to-BPF calls made from inside a block
+func (cfg *ControlFlowGraph) ReachableBlocks(entry int) map[int]bool {
+	return cfg.reachableBlocks(entry, true)
+}
+
+// reachableBlocks returns the blocks reachable from entry, entering the callees of
+// BPF-to-BPF calls only when followCalls is set
+func (cfg *ControlFlowGraph) reachableBlocks(entry int, followCalls bool) map[int]bool {
+	reachable := make(map[int]bool)
+	queue := []int{entry}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if reachable[node] {
+			continue
+		}
+		reachable[node] = true
+
+		end := node + cfg.NodesLen[node]
+		for site, callee := range cfg.Calls {
+			if followCalls && site >= node && site < end {
+				queue = append(queue, callee)
+			}
+		}
+
+		// A block ending in a conditional jump points at the jump instruction, which holds the edges
+		for _, succ := range cfg.Nodes[node] {
+			if _, isBlock := cfg.NodesLen[succ]; isBlock {
+				queue = append(queue, succ)
+			} else {
+				for _, target := range cfg.Nodes[succ] {
+					queue = append(queue, target)
+				}
+			}
+		}
+	}
+
+	for node := range reachable {
+		if _, isBlock := cfg.NodesLen[node]; !isBlock {
+			delete(reachable, node)
+		}
+	}
+	return reachable
+}
+
+// removeEdge drops the edge from the conditional jump at site to target, once a pass
+// proved that the jump never goes there
+func (cfg *ControlFlowGraph) removeEdge(site, target int) {
+	cfg.Nodes[site] = withoutInt(cfg.Nodes[site], target)
+	if sources, ok := cfg.NodesRev[target]; ok {
+		cfg.NodesRev[target] = withoutInt(sources, site)
+	}
+}
+
+// withoutInt returns a copy of slice without any occurrence of value
+func withoutInt(slice []int, value int) []int {
+	result := make([]int, 0, len(slice))
+	for _, v := range slice {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -1,6 +1,7 @@
 package optimizer
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/beepfd/bpf-optimizer/pkg/bpf"
@@ -199,3 +200,120 @@ func Test_buildInstructionNodeSingleInstruction(t *testing.T) {
 		})
 	}
 }
+
+func Test_buildControlFlowGraphBackEdge(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000000000000", // 0: r1 = 0
+		"0701000001000000", // 1: r1 += 1
+		"bf12000000000000", // 2: r2 = r1
+		"a501fdff0a000000", // 3: if r1 < 10 goto -3 (back edge to 1)
+		"bf20000000000000", // 4: r0 = r2
+		"9500000000000000", // 5: exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	cfg := section.buildControlFlowGraph()
+
+	wantNodesLen := map[int]int{0: 1, 1: 2, 3: 1, 4: 2}
+	if !tool.CompareIntIntMap(cfg.NodesLen, wantNodesLen) {
+		t.Errorf("NodesLen maps differ: %s", tool.FormatIntMapDifference("NodesLen", cfg.NodesLen, wantNodesLen))
+	}
+
+	// The loop head is reached both from the preceding block and from the back edge
+	wantPreds := []int{0, 3}
+	if !tool.CompareIntSlices(cfg.NodesRev[1], wantPreds) {
+		t.Errorf("NodesRev[1] = %v, want %v", cfg.NodesRev[1], wantPreds)
+	}
+}
+
+func Test_buildControlFlowGraphSameTargetJump(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b700000000000000", // 0: r0 = 0
+		"1501000000000000", // 1: if r1 == 0 goto +0, both edges lead to 2
+		"b700000001000000", // 2: r0 = 1
+		"9500000000000000", // 3: exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	cfg := section.buildControlFlowGraph()
+
+	if !tool.CompareIntSlices(cfg.Nodes[1], []int{2}) {
+		t.Errorf("Nodes[1] = %v, want a single edge to 2", cfg.Nodes[1])
+	}
+	if !tool.CompareIntSlices(cfg.NodesRev[2], []int{1}) {
+		t.Errorf("NodesRev[2] = %v, want a single edge from 1", cfg.NodesRev[2])
+	}
+}
+
+func Test_buildControlFlowGraphLongJump(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b700000000000000", // 0: r0 = 0
+		"0600000002000000", // 1: gotol +2 (offset in imm)
+		"b700000001000000", // 2: r0 = 1
+		"9500000000000000", // 3: exit
+		"b700000002000000", // 4: r0 = 2
+		"9500000000000000", // 5: exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	cfg := section.buildControlFlowGraph()
+
+	if !tool.CompareIntSlices(cfg.Nodes[0], []int{4}) {
+		t.Errorf("Nodes[0] = %v, want [4]", cfg.Nodes[0])
+	}
+	if !tool.CompareIntSlices(cfg.NodesRev[4], []int{0}) {
+		t.Errorf("NodesRev[4] = %v, want [0]", cfg.NodesRev[4])
+	}
+	if len(cfg.NodesRev[2]) != 0 {
+		t.Errorf("NodesRev[2] = %v, want no predecessors past the long jump", cfg.NodesRev[2])
+	}
+}
+
+func Test_buildControlFlowGraphPseudoCall(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000001000000", // 0: r1 = 1
+		"8510000002000000", // 1: call pc+2 (BPF-to-BPF)
+		"9500000000000000", // 2: exit
+		"b700000000000000", // 3: r0 = 0
+		"bf10000000000000", // 4: callee: r0 = r1
+		"9500000000000000", // 5: exit
+	}, "")
+
+	// Only the main function has a symbol, the callee is found through the call
+	section, err := NewSectionWithOptions(hexData, "test", SectionOptions{SkipOptimization: true, FunctionStarts: []int{0}})
+	if err != nil {
+		t.Fatalf("NewSectionWithOptions() error = %v", err)
+	}
+	cfg := section.ControlFlowGraph
+
+	if callee, ok := cfg.Calls[1]; !ok || callee != 4 {
+		t.Errorf("Calls[1] = %d, %v, want 4", callee, ok)
+	}
+	// The callee starts mid-block, the call makes it a block of its own
+	if cfg.NodesLen[3] != 1 || cfg.NodesLen[4] != 2 {
+		t.Errorf("NodesLen = %v, want blocks 3 and 4 split at the callee", cfg.NodesLen)
+	}
+
+	reachable := cfg.ReachableBlocks(0)
+	if !reachable[4] {
+		t.Errorf("callee block 4 is not reachable from 0: %v", reachable)
+	}
+	if reachable[3] {
+		t.Errorf("block 3 after exit should not be reachable: %v", reachable)
+	}
+	if !section.isEntryPoint(4) {
+		t.Errorf("EntryPoints = %v, want the callee 4", section.EntryPoints)
+	}
+}
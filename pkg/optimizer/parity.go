@@ -0,0 +1,317 @@
+package optimizer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+	"github.com/beepfd/bpf-optimizer/tool"
+)
+
+// Merlin dump files MerlinParity knows, named as in testdata
+const (
+	dumpAnalysis     = "analyz_result.csv" // one analysis row per instruction, see formatAnalysisRow
+	dumpNodes        = "dep_nodes"         // CFG successors, one Python dict per line, the first is the finished CFG
+	dumpNodesRev     = "dep_nodes_rev"     // CFG predecessors
+	dumpNodesLen     = "dep_nodes_len"     // basic block lengths
+	dumpDependencies = "section_deps"      // [Dependencies, DependedBy] sets of every instruction
+)
+
+// parityContext is how many instructions a ParityDivergence shows on each side of the difference
+const parityContext = 2
+
+// ParityDivergence is the first difference between the Go analysis of a section and a Merlin dump
+type ParityDivergence struct {
+	Dump    string   // dump file the difference was found in
+	Index   int      // instruction, CFG node or row of the dump
+	Got     string   // Go result, in the dump's notation
+	Want    string   // Merlin result
+	Context []string // instructions around Index, the differing one marked with >
+}
+
+// MerlinParity compares the analysis of the section with the Merlin dumps found in dir and
+// returns the first divergence in each of them, none when every dump matches. Missing dumps
+// are skipped, but dir must hold at least one. The section should not be optimized yet:
+// Merlin dumps its analysis before the passes run.
+func (s *Section) MerlinParity(dir string) ([]ParityDivergence, error) {
+	divergences := make([]ParityDivergence, 0)
+	found := false
+
+	checks := []struct {
+		dump  string
+		check func(path string) (*ParityDivergence, error)
+	}{
+		{dumpAnalysis, s.analysisParity},
+		{dumpNodes, func(path string) (*ParityDivergence, error) {
+			return s.nodesParity(dumpNodes, path, s.ControlFlowGraph.Nodes)
+		}},
+		{dumpNodesRev, func(path string) (*ParityDivergence, error) {
+			return s.nodesParity(dumpNodesRev, path, s.ControlFlowGraph.NodesRev)
+		}},
+		{dumpNodesLen, s.nodesLenParity},
+		{dumpDependencies, s.dependencyParity},
+	}
+
+	for _, c := range checks {
+		path := filepath.Join(dir, c.dump)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		found = true
+
+		if s.ControlFlowGraph == nil && c.dump != dumpAnalysis && c.dump != dumpDependencies {
+			return nil, fmt.Errorf("section %s has no control flow graph to compare with %s", s.Name, c.dump)
+		}
+
+		divergence, err := c.check(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare %s: %w", c.dump, err)
+		}
+		if divergence != nil {
+			divergences = append(divergences, *divergence)
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no Merlin dump (%s, %s, %s, %s, %s) in %s",
+			dumpAnalysis, dumpNodes, dumpNodesRev, dumpNodesLen, dumpDependencies, dir)
+	}
+	return divergences, nil
+}
+
+// analysisParity compares every row of analyz_result.csv with the analysis of the instruction it
+// names. The rows are compared on their own, the dump may come from another section.
+func (s *Section) analysisParity(path string) (*ParityDivergence, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows written by WriteDependencyCSV carry the dependency sets after the analysis, ignore them
+	rows := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	insts := make([]*bpf.Instruction, len(rows))
+	for i, row := range rows {
+		inst, err := bpf.NewInstruction(strings.SplitN(row, "/", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		insts[i] = inst
+	}
+
+	for i, row := range rows {
+		want := row
+		if fields := strings.Split(row, "/"); len(fields) > 8 {
+			want = strings.Join(fields[:8], "/")
+		}
+		if got := formatAnalysisRow(insts[i], analyzeInstruction(insts[i])); got != want {
+			return &ParityDivergence{Dump: dumpAnalysis, Index: i, Got: got, Want: want, Context: instructionContext(insts, i)}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// nodesParity compares a CFG edge map with the first dict of a dep_nodes dump
+func (s *Section) nodesParity(dump, path string, got map[int][]int) (*ParityDivergence, error) {
+	dicts, err := tool.ParsePythonDictIntSlice(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(dicts) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	want := dicts[0]
+
+	for _, node := range unionKeys(got, want) {
+		gotEdges, gotOK := got[node]
+		wantEdges, wantOK := want[node]
+		if gotOK != wantOK || !tool.CompareIntSlices(gotEdges, wantEdges) {
+			return s.divergence(dump, node, formatNodeEdges(gotEdges, gotOK), formatNodeEdges(wantEdges, wantOK)), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// nodesLenParity compares the basic block lengths with the first dict of dep_nodes_len
+func (s *Section) nodesLenParity(path string) (*ParityDivergence, error) {
+	dicts, err := tool.ParsePythonDictInt(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(dicts) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	want := dicts[0]
+
+	keys := make(map[int]bool)
+	for node := range s.ControlFlowGraph.NodesLen {
+		keys[node] = true
+	}
+	for node := range want {
+		keys[node] = true
+	}
+	nodes := make([]int, 0, len(keys))
+	for node := range keys {
+		nodes = append(nodes, node)
+	}
+	sort.Ints(nodes)
+
+	for _, node := range nodes {
+		gotLen, gotOK := s.ControlFlowGraph.NodesLen[node]
+		wantLen, wantOK := want[node]
+		if gotOK != wantOK || gotLen != wantLen {
+			return s.divergence(dumpNodesLen, node, formatNodeLen(gotLen, gotOK), formatNodeLen(wantLen, wantOK)), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// dependencyParity compares the dependency sets of every instruction with section_deps
+func (s *Section) dependencyParity(path string) (*ParityDivergence, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	want, err := parsePyDependencies(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < max(len(want), len(s.Dependencies)); i++ {
+		gotRow, wantRow := "<missing>", "<missing>"
+		if i < len(s.Dependencies) {
+			deps := s.Dependencies[i].Deduplication()
+			gotRow = formatPySet(deps.Dependencies) + "/" + formatPySet(deps.DependedBy)
+		}
+		if i < len(want) {
+			wantRow = formatPySet(want[i].Dependencies) + "/" + formatPySet(want[i].DependedBy)
+		}
+
+		if gotRow != wantRow {
+			return s.divergence(dumpDependencies, i, gotRow, wantRow), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// divergence builds a ParityDivergence at instruction index of the section
+func (s *Section) divergence(dump string, index int, got, want string) *ParityDivergence {
+	return &ParityDivergence{Dump: dump, Index: index, Got: got, Want: want, Context: instructionContext(s.Instructions, index)}
+}
+
+// instructionContext lists the instructions within parityContext of index with their mnemonic
+func instructionContext(insts []*bpf.Instruction, index int) []string {
+	lines := make([]string, 0, 2*parityContext+1)
+	for i := max(0, index-parityContext); i <= index+parityContext && i < len(insts); i++ {
+		marker := " "
+		if i == index {
+			marker = ">"
+		}
+		lines = append(lines, fmt.Sprintf("%s %d: %s %s", marker, i, insts[i].Raw, insts[i].Mnemonic()))
+	}
+	return lines
+}
+
+// unionKeys returns the keys of both maps in ascending order
+func unionKeys(a, b map[int][]int) []int {
+	keys := make(map[int]bool)
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+
+	result := make([]int, 0, len(keys))
+	for k := range keys {
+		result = append(result, k)
+	}
+	sort.Ints(result)
+	return result
+}
+
+func formatNodeEdges(edges []int, ok bool) string {
+	if !ok {
+		return "<missing>"
+	}
+	return formatPyList(edges)
+}
+
+func formatNodeLen(length int, ok bool) string {
+	if !ok {
+		return "<missing>"
+	}
+	return strconv.Itoa(length)
+}
+
+// pySetPattern matches a Python set literal as printed by str(set)
+var pySetPattern = regexp.MustCompile(`set\(\)|\{[^}]*\}`)
+
+// parsePyDependencies parses a Python list of [Dependencies, DependedBy] set pairs, the section_deps format
+func parsePyDependencies(data []byte) ([]DependencyInfo, error) {
+	content := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(content, "[") || !strings.HasSuffix(content, "]") {
+		return nil, fmt.Errorf("expected a Python list")
+	}
+	content = content[1 : len(content)-1]
+
+	deps := make([]DependencyInfo, 0)
+	scanner := bufio.NewScanner(bytes.NewReader([]byte(content)))
+	scanner.Buffer(nil, len(content)+1)
+	scanner.Split(scanPyPairs)
+	for scanner.Scan() {
+		sets := pySetPattern.FindAllString(scanner.Text(), -1)
+		if len(sets) != 2 {
+			return nil, fmt.Errorf("entry %d: expected 2 sets, got %q", len(deps), scanner.Text())
+		}
+
+		pair := [2][]int{}
+		for j, set := range sets {
+			pair[j] = make([]int, 0)
+			for _, field := range strings.Split(strings.Trim(set, "{}"), ",") {
+				if field = strings.TrimSpace(field); field == "" || set == "set()" {
+					continue
+				}
+				value, err := strconv.Atoi(field)
+				if err != nil {
+					return nil, fmt.Errorf("entry %d: %w", len(deps), err)
+				}
+				pair[j] = append(pair[j], value)
+			}
+		}
+		deps = append(deps, DependencyInfo{Dependencies: pair[0], DependedBy: pair[1]}.Deduplication())
+	}
+
+	return deps, scanner.Err()
+}
+
+// scanPyPairs splits a list body into its top-level [...] entries
+func scanPyPairs(data []byte, atEOF bool) (int, []byte, error) {
+	start := bytes.IndexByte(data, '[')
+	if start < 0 {
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+
+	end := bytes.IndexByte(data[start:], ']')
+	if end < 0 {
+		if atEOF {
+			return 0, nil, fmt.Errorf("unterminated entry")
+		}
+		return 0, nil, nil
+	}
+	return start + end + 1, data[start+1 : start+end], nil
+}
@@ -0,0 +1,135 @@
+package optimizer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// copyMerlinDumps copies testdata files into a fresh directory under the dump names they stand for
+func copyMerlinDumps(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, file := range files {
+		data, err := os.ReadFile(filepath.Join("../../testdata", file))
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	return dir
+}
+
+func TestMerlinParity(t *testing.T) {
+	hexData, err := os.ReadFile("../../testdata/section_data")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	section, err := NewSection(string(hexData), ".text", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	dir := copyMerlinDumps(t, map[string]string{
+		dumpAnalysis: dumpAnalysis,
+		dumpNodes:    dumpNodes,
+		dumpNodesRev: dumpNodesRev,
+		dumpNodesLen: dumpNodesLen,
+	})
+
+	divergences, err := section.MerlinParity(dir)
+	if err != nil {
+		t.Fatalf("MerlinParity() error = %v", err)
+	}
+	if len(divergences) != 0 {
+		t.Fatalf("got %d divergences, want none: %+v", len(divergences), divergences)
+	}
+
+	// Block 3 is one instruction long in Merlin's CFG
+	path := filepath.Join(dir, dumpNodesLen)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Replace(string(data), "3: 1,", "3: 2,", 1)), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	divergences, err = section.MerlinParity(dir)
+	if err != nil {
+		t.Fatalf("MerlinParity() error = %v", err)
+	}
+	if len(divergences) != 1 {
+		t.Fatalf("got %d divergences, want 1: %+v", len(divergences), divergences)
+	}
+	d := divergences[0]
+	if d.Dump != dumpNodesLen || d.Index != 3 || d.Got != "1" || d.Want != "2" {
+		t.Errorf("divergence = %+v, want block 3 of %s", d, dumpNodesLen)
+	}
+	if len(d.Context) != 5 || !strings.HasPrefix(d.Context[2], "> 3: "+section.Instructions[3].Raw) {
+		t.Errorf("context = %q, want instructions 1-5 with 3 marked", d.Context)
+	}
+}
+
+func TestMerlinParityDependencies(t *testing.T) {
+	// r1 = 0; r0 = r1; exit
+	section, err := NewSection("b701000000000000bf100000000000009500000000000000", "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, dumpDependencies)
+	if err := os.WriteFile(path, []byte("[[set(), {1}], [{0}, {2}], [{1}, set()]]"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	divergences, err := section.MerlinParity(dir)
+	if err != nil {
+		t.Fatalf("MerlinParity() error = %v", err)
+	}
+	if len(divergences) != 0 {
+		t.Fatalf("got %d divergences, want none: %+v", len(divergences), divergences)
+	}
+
+	// Drop the r0 = r1 -> exit edge
+	if err := os.WriteFile(path, []byte("[[set(), {1}], [{0}, set()], [set(), set()]]"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	divergences, err = section.MerlinParity(dir)
+	if err != nil {
+		t.Fatalf("MerlinParity() error = %v", err)
+	}
+	if len(divergences) != 1 {
+		t.Fatalf("got %d divergences, want 1: %+v", len(divergences), divergences)
+	}
+	if d := divergences[0]; d.Dump != dumpDependencies || d.Index != 1 {
+		t.Errorf("divergence = %+v, want instruction 1 of %s", d, dumpDependencies)
+	}
+}
+
+func TestMerlinParityNoDump(t *testing.T) {
+	section := createTestSection([]string{"9500000000000000"})
+	if _, err := section.MerlinParity(t.TempDir()); err == nil {
+		t.Error("MerlinParity() of an empty directory succeeded, want an error")
+	}
+}
+
+func TestParsePyDependencies(t *testing.T) {
+	deps, err := parsePyDependencies([]byte("[[set(), set()], [{-1}, {2}], [{1, 0}, set()]]"))
+	if err != nil {
+		t.Fatalf("parsePyDependencies() error = %v", err)
+	}
+	if len(deps) != 3 {
+		t.Fatalf("got %d entries, want 3", len(deps))
+	}
+	if got := formatPySet(deps[1].Dependencies) + formatPySet(deps[1].DependedBy); got != "{-1}{2}" {
+		t.Errorf("entry 1 = %s, want {-1}{2}", got)
+	}
+	if got := formatPySet(deps[2].Dependencies) + formatPySet(deps[2].DependedBy); got != "{0, 1}set()" {
+		t.Errorf("entry 2 = %s, want {0, 1}set()", got)
+	}
+}
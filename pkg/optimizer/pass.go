@@ -0,0 +1,153 @@
+package optimizer
+
+import "context"
+
+// passTable maps every pass name to the section method applying it
+var passTable = map[string]func(s *Section){
+	PassConstantPropagation: func(s *Section) { s.StoreCandidates = s.applyConstantPropagation() },
+	PassCompaction:          (*Section).applyCompaction,
+	PassPeephole:            (*Section).applyPeepholeOptimization,
+	PassLoadImm64Dedup:      (*Section).applyLoadImm64Dedup,
+	PassBranchFolding:       (*Section).applyBranchFolding,
+	PassSuperword:           (*Section).applySuperwordMerge,
+	PassSelfMove:            (*Section).applySelfMoveElimination,
+	PassRedundantDef:        (*Section).applyRedundantDefElimination,
+	PassMapLoadDedup:        (*Section).applyMapLoadDedup,
+	PassDeadCode:            (*Section).applyDeadCodeElimination,
+	PassTailMerge:           (*Section).applyTailMerge,
+}
+
+// defaultPassOrder is the order applyOptimizations runs the passes in when none is configured.
+// Superword merge comes after the passes producing stores, unreachable blocks and redundant
+// definitions are removed once the rewrites are done, and the self-move cleanup runs last to catch `r = r` moves
+// any earlier pass leaves behind. Map load dedup rewrites the relocation table as well, and
+// tail merging adds jumps and joins the verifier explores, so they only run when selected with a pass order.
+var defaultPassOrder = []string{
+	PassConstantPropagation,
+	PassCompaction,
+	PassPeephole,
+	PassLoadImm64Dedup,
+	PassBranchFolding,
+	PassSuperword,
+	PassDeadCode,
+	PassRedundantDef,
+	PassSelfMove,
+}
+
+// ValidatePassOrder checks that every name in order is a known pass
+func ValidatePassOrder(order []string) error {
+	for _, name := range order {
+		if _, ok := passTable[name]; !ok {
+			return &ErrUnknownPass{Name: name}
+		}
+	}
+	return nil
+}
+
+// ApplyPass applies a single pass by name, records it in the section trace and counts its candidates in Candidates
+func (s *Section) ApplyPass(name string) error {
+	apply, ok := passTable[name]
+	if !ok {
+		return &ErrUnknownPass{Name: name}
+	}
+
+	s.activePass = name
+	s.tracePass(name, func() { apply(s) })
+	s.activePass = ""
+	return nil
+}
+
+// OptimizationConfig selects what OptimizeContext runs
+type OptimizationConfig struct {
+	PassOrder           []string // passes to apply in order, empty for defaultPassOrder
+	RebuildDependencies bool     // rerun the dependency analysis first, e.g. on a section loaded with SkipOptimization and edited since
+	FixpointIterations  int      // rerun the passes until a round changes nothing, at most this many rounds; 0 or 1 runs them once
+}
+
+// OptimizeContext applies the configured passes like NewSectionWithOptions does, stopping with
+// ctx.Err() once ctx is done. Cancellation is checked between passes and between the blocks of
+// the dependency analysis. A cancelled section keeps the passes applied so far, and a cancelled
+// analysis leaves the dependency graph incomplete: Snapshot and Reset can roll it back.
+func (s *Section) OptimizeContext(ctx context.Context, cfg OptimizationConfig) error {
+	if err := ValidatePassOrder(cfg.PassOrder); err != nil {
+		return err
+	}
+
+	if cfg.RebuildDependencies {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		s.ctx = ctx
+		s.resetDependencies()
+		s.buildDependencies()
+		s.ctx = nil
+	}
+
+	return s.applyPasses(ctx, cfg.PassOrder, cfg.FixpointIterations)
+}
+
+// applyPasses applies the passes in order, or defaultPassOrder when order is empty, and records
+// the instruction and branch counts around them. With rounds above 1 the passes run again,
+// on a rebuilt dependency graph, as long as the previous round changed an instruction: a
+// rewrite may give an earlier pass of the order, or the same one, something new to work on.
+// The cap bounds the rounds even if passes keep rewriting each other's output.
+func (s *Section) applyPasses(ctx context.Context, order []string, rounds int) error {
+	if len(order) == 0 {
+		order = defaultPassOrder
+	}
+
+	s.Stats.InstructionsBefore = countActiveInstructions(s.Instructions)
+	s.Stats.BranchesBefore = countBranches(s.Instructions)
+
+	for round := 1; ; round++ {
+		before := make([]string, len(s.Instructions))
+		for i, inst := range s.Instructions {
+			before[i] = inst.Raw
+		}
+
+		if err := s.applyRound(ctx, order); err != nil {
+			return err
+		}
+
+		changed := false
+		for i, inst := range s.Instructions {
+			changed = changed || inst.Raw != before[i]
+		}
+		if !changed || round >= rounds {
+			break
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.ctx = ctx
+		s.resetDependencies()
+		s.buildDependencies()
+		s.ctx = nil
+	}
+
+	s.Stats.InstructionsAfter = countActiveInstructions(s.Instructions)
+	s.Stats.BranchesAfter = countBranches(s.Instructions)
+	return nil
+}
+
+// applyRound applies each pass of order once
+func (s *Section) applyRound(ctx context.Context, order []string) error {
+	for _, name := range order {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.ApplyPass(name)
+
+		// A pass leaving Raw and the decoded fields apart would be written out differently than analysed
+		if logger.Enabled(LogDebug) {
+			for i, inst := range s.Instructions {
+				if err := inst.CheckIntegrity(); err != nil {
+					logger.Debugf("DEBUG: pass %s left instruction %d inconsistent: %v", name, i, err)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package optimizer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+func TestApplyPass(t *testing.T) {
+	tests := []struct {
+		pass     string
+		hex      []string
+		expected []string
+	}{
+		{
+			pass: PassConstantPropagation,
+			hex: []string{
+				"b70100000a000000", // r1 = 10
+				"7b1af8ff00000000", // *(u64 *)(r10 - 8) = r1
+				"9500000000000000", // exit
+			},
+			expected: []string{bpf.NOP, "7a0af8ff0a000000", "9500000000000000"},
+		},
+		{
+			pass: PassCompaction,
+			hex: []string{
+				"6701000020000000", // r1 <<= 32
+				"7701000020000000", // r1 >>= 32
+				"9500000000000000", // exit
+			},
+			expected: []string{"bc11000000000000", bpf.NOP, "9500000000000000"},
+		},
+		{
+			pass: PassPeephole,
+			hex: []string{
+				"0401000005000000", // w1 += 5
+				"57010000ffffffff", // r1 &= 0xffffffff
+				"9500000000000000", // exit
+			},
+			expected: []string{"0401000005000000", bpf.NOP, "9500000000000000"},
+		},
+		{
+			pass: PassLoadImm64Dedup,
+			hex: []string{
+				"1801000001000000", "0000000002000000", // r1 = 0x200000001 ll
+				"1802000001000000", "0000000002000000", // r2 = 0x200000001 ll
+				"9500000000000000", // exit
+			},
+			expected: []string{"1801000001000000", "0000000002000000", "bf12000000000000", bpf.NOP, "9500000000000000"},
+		},
+		{
+			pass: PassSuperword,
+			hex: []string{
+				"720af8ff01000000", // *(u8 *)(r10 - 8) = 0x1
+				"720af9ff02000000", // *(u8 *)(r10 - 7) = 0x2
+				"9500000000000000", // exit
+			},
+			expected: []string{"6a0af8ff01020000", bpf.NOP, "9500000000000000"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pass, func(t *testing.T) {
+			section, err := NewSection(strings.Join(tt.hex, ""), "test", true)
+			if err != nil {
+				t.Fatalf("NewSection() error = %v", err)
+			}
+
+			if err := section.ApplyPass(tt.pass); err != nil {
+				t.Fatalf("ApplyPass(%s) error = %v", tt.pass, err)
+			}
+
+			for i, want := range tt.expected {
+				if got := section.Instructions[i].Raw; got != want {
+					t.Errorf("instruction %d = %s, expected %s", i, got, want)
+				}
+			}
+
+			if len(section.Trace) != 1 || section.Trace[0].Pass != tt.pass {
+				t.Errorf("Trace = %+v, want a single %s entry", section.Trace, tt.pass)
+			}
+		})
+	}
+}
+
+func TestApplyPassUnknown(t *testing.T) {
+	section := createTestSection([]string{"9500000000000000"})
+
+	err := section.ApplyPass("dead-store")
+	var unknown *ErrUnknownPass
+	if !errors.As(err, &unknown) || unknown.Name != "dead-store" {
+		t.Errorf("ApplyPass(dead-store) error = %v, want ErrUnknownPass", err)
+	}
+}
+
+func TestApplyPassUppercaseHex(t *testing.T) {
+	// Pattern matches compare Raw against lowercase hex, so the input casing must not matter
+	hexData := strings.ToUpper(strings.Join([]string{
+		"6701000020000000", // r1 <<= 32
+		"7701000020000000", // r1 >>= 32
+		"040200000A000000", // w2 += 10
+		"57020000ffffffff", // r2 &= 0xffffffff
+		"9500000000000000", // exit
+	}, ""))
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	for _, pass := range []string{PassCompaction, PassPeephole} {
+		if err := section.ApplyPass(pass); err != nil {
+			t.Fatalf("ApplyPass(%s) error = %v", pass, err)
+		}
+	}
+
+	expected := []string{"bc11000000000000", bpf.NOP, "040200000a000000", bpf.NOP, "9500000000000000"}
+	for i, want := range expected {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+	}
+}
+
+func TestPassOrder(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000001000000", // r1 = 1
+		"b702000000000000", // r2 = 0
+		"631af8ff00000000", // *(u32 *)(r10 - 8) = r1
+		"632afcff00000000", // *(u32 *)(r10 - 4) = r2
+		"9500000000000000", // exit
+	}, "")
+
+	tests := []struct {
+		name     string
+		order    []string
+		expected []string
+	}{
+		{
+			name: "default order merges the stores const-prop produced",
+			expected: []string{
+				bpf.NOP, bpf.NOP, "7a0af8ff01000000", bpf.NOP, "9500000000000000",
+			},
+		},
+		{
+			name:  "superword before const-prop finds no immediate stores",
+			order: []string{PassSuperword, PassConstantPropagation},
+			expected: []string{
+				bpf.NOP, bpf.NOP, "620af8ff01000000", "620afcff00000000", "9500000000000000",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section, err := NewSectionWithOptions(hexData, "test", SectionOptions{PassOrder: tt.order})
+			if err != nil {
+				t.Fatalf("NewSectionWithOptions() error = %v", err)
+			}
+
+			for i, want := range tt.expected {
+				if got := section.Instructions[i].Raw; got != want {
+					t.Errorf("instruction %d = %s, expected %s", i, got, want)
+				}
+			}
+
+			// Either way r10-8 holds the u64 value 1
+			if got := section.DumpStackBuffer(10, -8, 0); !bytes.Equal(got, []byte{1, 0, 0, 0, 0, 0, 0, 0}) {
+				t.Errorf("stack bytes = %x, expected 0100000000000000", got)
+			}
+		})
+	}
+
+	_, err := NewSectionWithOptions(hexData, "test", SectionOptions{PassOrder: []string{PassPeephole, "dead-store"}})
+	var unknown *ErrUnknownPass
+	if !errors.As(err, &unknown) || unknown.Name != "dead-store" {
+		t.Errorf("NewSectionWithOptions() error = %v, want ErrUnknownPass", err)
+	}
+}
+
+func TestOptimizeContextCancelled(t *testing.T) {
+	// Hundreds of diamonds keep the dependency analysis busy for a while
+	var hexData strings.Builder
+	for i := 0; i < 500; i++ {
+		hexData.WriteString("b701000001000000") // r1 = 1
+		hexData.WriteString("1502010000000000") // if r2 == 0 goto +1
+		hexData.WriteString("0f13000000000000") // r3 += r1
+		hexData.WriteString("7b3af8ff00000000") // *(u64 *)(r10 - 8) = r3
+	}
+	hexData.WriteString("b700000000000000") // r0 = 0
+	hexData.WriteString("9500000000000000") // exit
+
+	start := time.Now()
+	section, err := NewSection(hexData.String(), "big", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	analysis := time.Since(start)
+	section.Snapshot()
+
+	ctx, cancel := context.WithTimeout(context.Background(), analysis/20)
+	defer cancel()
+
+	start = time.Now()
+	err = section.OptimizeContext(ctx, OptimizationConfig{RebuildDependencies: true})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("OptimizeContext() error = %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > analysis/2 {
+		t.Errorf("OptimizeContext() returned after %v, the full analysis takes %v", elapsed, analysis)
+	}
+	if len(section.Trace) != 0 {
+		t.Errorf("Trace = %v, expected no pass to run after the cancellation", section.Trace)
+	}
+
+	// Once rolled back, the section optimizes as usual
+	section.Reset()
+	if err := section.OptimizeContext(context.Background(), OptimizationConfig{}); err != nil {
+		t.Fatalf("OptimizeContext() error = %v", err)
+	}
+	if len(section.Trace) != len(defaultPassOrder) {
+		t.Errorf("Trace has %d passes, expected %d", len(section.Trace), len(defaultPassOrder))
+	}
+}
+
+func TestFixpointIterations(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000005000000", // r1 = 5
+		"bf11000000000000", // r1 = r1
+		"1501010005000000", // if r1 == 5 goto +1
+		"b700000001000000", // r0 = 1
+		"9500000000000000", // exit
+	}, "")
+
+	// The self-move cleanup runs last, so the compare only folds in round two, which leaves
+	// r1 = 5 unused for round three. Round four changes nothing and ends the iteration.
+	tests := []struct {
+		rounds   int
+		ran      int
+		expected []string
+	}{
+		{0, 1, []string{"b701000005000000", bpf.NOP, "1501010005000000", "b700000001000000", "9500000000000000"}},
+		{2, 2, []string{"b701000005000000", bpf.NOP, "0500010000000000", "b700000001000000", "9500000000000000"}},
+		{10, 4, []string{bpf.NOP, bpf.NOP, "0500010000000000", "b700000001000000", "9500000000000000"}},
+	}
+
+	for _, tt := range tests {
+		section, err := NewSectionWithOptions(hexData, "test", SectionOptions{FixpointIterations: tt.rounds})
+		if err != nil {
+			t.Fatalf("NewSectionWithOptions() error = %v", err)
+		}
+
+		for i, want := range tt.expected {
+			if got := section.Instructions[i].Raw; got != want {
+				t.Errorf("%d rounds: instruction %d = %s, expected %s", tt.rounds, i, got, want)
+			}
+		}
+		if got := len(section.Trace) / len(defaultPassOrder); got != tt.ran {
+			t.Errorf("%d rounds: ran %d rounds, expected %d", tt.rounds, got, tt.ran)
+		}
+		if err := section.Verify(); err != nil {
+			t.Errorf("%d rounds: Verify() error = %v", tt.rounds, err)
+		}
+	}
+}
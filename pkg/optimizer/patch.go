@@ -0,0 +1,168 @@
+package optimizer
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// patchHeader starts every patch file written by WritePatch
+const patchHeader = "# bpf-optimizer patch v1"
+
+// PatchEntry is a run of consecutive instructions Save would rewrite
+type PatchEntry struct {
+	Section string
+	Offset  uint64 // file offset of the first changed byte
+	Old     []byte
+	New     []byte
+}
+
+// Patch returns the byte changes Save would make to the input file, ordered by section name,
+// followed by the relocation sections map-dedup rewrote. Consecutive changed instructions
+// (or relocation entries, compared in instruction-sized chunks) are grouped into one entry.
+func (prog *BPFProgram) Patch() ([]PatchEntry, error) {
+	original, err := os.ReadFile(prog.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read original file: %v", err)
+	}
+
+	optimized, err := prog.optimizedImage()
+	if err != nil {
+		return nil, err
+	}
+
+	elfFile, err := elf.NewFile(bytes.NewReader(original))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse original ELF: %v", err)
+	}
+
+	names := make([]string, 0, len(prog.Sections))
+	for name := range prog.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]PatchEntry, 0)
+	for _, name := range names {
+		start, size, err := sectionFileRange(elfFile, name, prog.Sections[name])
+		if err != nil || start+size > uint64(len(original)) {
+			continue
+		}
+
+		entries = appendChangedRuns(entries, name, original, optimized, start, size)
+	}
+
+	for _, s := range elfFile.Sections {
+		if (s.Type == elf.SHT_REL || s.Type == elf.SHT_RELA) && s.Offset+s.Size <= uint64(len(original)) {
+			entries = appendChangedRuns(entries, s.Name, original, optimized, s.Offset, s.Size)
+		}
+	}
+
+	return entries, nil
+}
+
+// appendChangedRuns appends an entry for every run of instruction-sized chunks
+// that differ between original and optimized within [start, start+size)
+func appendChangedRuns(entries []PatchEntry, name string, original, optimized []byte, start, size uint64) []PatchEntry {
+	var entry *PatchEntry
+	for off := start; off < start+size; off += bpf.InstructionSize {
+		end := min(off+bpf.InstructionSize, start+size)
+		if bytes.Equal(original[off:end], optimized[off:end]) {
+			entry = nil
+			continue
+		}
+
+		if entry == nil {
+			entries = append(entries, PatchEntry{Section: name, Offset: off})
+			entry = &entries[len(entries)-1]
+		}
+		entry.Old = append(entry.Old, original[off:end]...)
+		entry.New = append(entry.New, optimized[off:end]...)
+	}
+	return entries
+}
+
+// WritePatch writes entries as lines of `<section> <file offset> <old hex> <new hex>`
+func WritePatch(w io.Writer, entries []PatchEntry) error {
+	bw := bufio.NewWriter(w)
+
+	if _, err := fmt.Fprintln(bw, patchHeader); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := fmt.Fprintf(bw, "%s %d %x %x\n", entry.Section, entry.Offset, entry.Old, entry.New); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ReadPatch parses a patch written by WritePatch. Blank lines and lines starting with # are skipped.
+func ReadPatch(r io.Reader) ([]PatchEntry, error) {
+	entries := make([]PatchEntry, 0)
+
+	// A run of changed instructions is one line, as long as the section at worst
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, math.MaxInt)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("patch line %d: expected 4 fields, got %d", line, len(fields))
+		}
+
+		offset, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("patch line %d: invalid offset %q", line, fields[1])
+		}
+		oldBytes, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("patch line %d: invalid old bytes: %v", line, err)
+		}
+		newBytes, err := hex.DecodeString(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("patch line %d: invalid new bytes: %v", line, err)
+		}
+		if len(oldBytes) != len(newBytes) {
+			return nil, fmt.Errorf("patch line %d: old and new bytes differ in length", line)
+		}
+
+		entries = append(entries, PatchEntry{Section: fields[0], Offset: offset, Old: oldBytes, New: newBytes})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ApplyPatch rewrites data in place. Every entry's old bytes are checked before anything is written,
+// so a patch made for another file leaves data untouched.
+func ApplyPatch(data []byte, entries []PatchEntry) error {
+	for _, entry := range entries {
+		end := entry.Offset + uint64(len(entry.Old))
+		if end > uint64(len(data)) || !bytes.Equal(data[entry.Offset:end], entry.Old) {
+			return &ErrPatchMismatch{Section: entry.Section, Offset: entry.Offset}
+		}
+	}
+
+	for _, entry := range entries {
+		copy(data[entry.Offset:], entry.New)
+	}
+	return nil
+}
@@ -1,11 +1,19 @@
 package optimizer
 
 import (
+	"bytes"
 	"debug/elf"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
 )
 
 // BPFProgram represents a BPF program loaded from an ELF file
@@ -13,39 +21,117 @@ type BPFProgram struct {
 	FilePath string
 	ELFFile  *elf.File
 	Sections map[string]*Section
+	Symbols  map[string]*Section // function symbol name -> section holding its code
+	Options  ProgramOptions
+
+	// Diagnostics collects the problems that did not abort loading or saving, Save appends to it
+	Diagnostics []Diagnostic
+
+	coreRelocations map[string][]uint64 // code section name -> byte offsets patched by CO-RE relocations
+	sectionErrors   []error             // errors of the code sections skipped while loading
+}
+
+// ProgramOptions controls how sections are loaded and optimized
+type ProgramOptions struct {
+	SkipOptimization bool        // only build the dependency graph, leave instructions untouched
+	Symbol           string      // only optimize the code of this function symbol, empty for all
+	KeepTrailingData bool        // keep bytes after the last whole instruction of a section instead of skipping it
+	Range            *IndexRange // only apply candidates within these instruction indices, nil for all
+	CacheDir         string      // directory caching the dependency analysis of each section, empty for none
+	PassOrder        []string    // passes to apply in order, empty for the default order
+
+	ProtectRelocations  bool // leave instructions carrying an ELF or CO-RE relocation untouched
+	MergeAcrossNopJumps bool // let superword merge stores across a `goto +0`, which blocks it by default
+	AggressiveMerge     bool // let superword merge stack stores with a gap between them, see applyGapMerges
+	AggressiveFold      bool // let branch folding drop a jump that may be a bounds check, see guardsMemoryAccess
+
+	FixpointIterations int // rerun the passes until a round changes nothing, at most this many rounds, see applyPasses
 }
 
 // NewBPFProgram creates a new BPF program from an ELF file
 func NewBPFProgram(filePath string) (*BPFProgram, error) {
+	return NewBPFProgramWithOptions(filePath, ProgramOptions{})
+}
+
+// NewBPFProgramWithOptions creates a new BPF program from an ELF file using the given options
+func NewBPFProgramWithOptions(filePath string, opts ProgramOptions) (*BPFProgram, error) {
+	if err := ValidatePassOrder(opts.PassOrder); err != nil {
+		return nil, err
+	}
+
+	// Tell a wrong input file apart from a malformed ELF before debug/elf does
+	if err := checkELFMagic(filePath); err != nil {
+		return nil, err
+	}
+
 	// Open the ELF file
 	elfFile, err := elf.Open(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open ELF file: %v", err)
+		return nil, fmt.Errorf("failed to open ELF file: %w", err)
 	}
 
 	prog := &BPFProgram{
 		FilePath: filePath,
 		ELFFile:  elfFile,
 		Sections: make(map[string]*Section),
+		Symbols:  make(map[string]*Section),
+		Options:  opts,
+	}
+
+	if prog.coreRelocations, err = parseCORERelocations(elfFile); err != nil {
+		prog.diagnose(SeverityWarning, "", "failed to parse CO-RE relocations: %v", err)
 	}
 
 	// Process symbols and sections
 	if err := prog.processSections(); err != nil {
 		elfFile.Close()
-		return nil, fmt.Errorf("failed to process sections: %v", err)
+		return nil, fmt.Errorf("failed to process sections: %w", err)
+	}
+
+	// Failed sections are skipped, the program only fails when none is left
+	if len(prog.Sections) == 0 {
+		elfFile.Close()
+		if len(prog.sectionErrors) > 0 {
+			return nil, &ErrSectionsFailed{Path: filePath, Errors: prog.sectionErrors}
+		}
+		return nil, &ErrNoBPFSections{Path: filePath}
 	}
 
 	return prog, nil
 }
 
+// checkELFMagic checks that the file starts with the ELF magic number
+func checkELFMagic(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(file, magic); err != nil || string(magic) != elf.ELFMAG {
+		return &ErrNotELF{Path: filePath}
+	}
+
+	return nil
+}
+
 // processSections extracts and optimizes BPF code sections
 func (prog *BPFProgram) processSections() error {
-	// Get symbol table
+	// Get symbol table, stripped objects have none
 	symbols, err := prog.ELFFile.Symbols()
-	if err != nil {
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
 		return fmt.Errorf("failed to read symbols: %v", err)
 	}
 
+	if prog.Options.Symbol != "" {
+		return prog.processSymbol(symbols, prog.Options.Symbol)
+	}
+
+	// Symbols sharing a byte range (every function in a section) must optimize it only once,
+	// otherwise Save would write the same offset several times
+	processed := make(map[sectionRange]*Section)
+
 	// Process each function symbol
 	for _, symbol := range symbols {
 		if elf.ST_TYPE(symbol.Info) == elf.STT_FUNC {
@@ -54,6 +140,15 @@ func (prog *BPFProgram) processSections() error {
 				continue
 			}
 
+			key := sectionRange{Offset: section.Offset, Size: section.Size}
+			if optimizedSection, ok := processed[key]; ok {
+				if optimizedSection != nil {
+					prog.Symbols[symbol.Name] = optimizedSection
+				}
+				continue
+			}
+			processed[key] = nil
+
 			// Read section data
 			data, err := section.Data()
 			if err != nil {
@@ -65,99 +160,473 @@ func (prog *BPFProgram) processSections() error {
 				continue
 			}
 
-			// Convert to hex string and create optimized section
-			hexData := hex.EncodeToString(data)
-			optimizedSection, err := NewSection(hexData, section.Name, false)
+			optimizedSection, err := prog.newProgramSection(section, int(symbol.Section), data, 0)
 			if err != nil {
-				fmt.Printf("Warning: failed to process section %s: %v\n", section.Name, err)
+				prog.sectionFailed(section.Name, err)
 				continue
 			}
 
+			processed[key] = optimizedSection
 			prog.Sections[section.Name] = optimizedSection
+			prog.Symbols[symbol.Name] = optimizedSection
+		}
+	}
+
+	if len(processed) == 0 {
+		// No function symbols: the programs can only be found by their section
+		return prog.processCodeSections(processed, isCodeSection)
+	}
+
+	// Executable sections without a function symbol, e.g. hand-written assembly
+	return prog.processCodeSections(processed, isExecutableSection)
+}
+
+// sectionFailed records a code section skipped because of err
+func (prog *BPFProgram) sectionFailed(name string, err error) {
+	prog.diagnose(SeverityError, name, "failed to process section: %v", err)
+	prog.sectionErrors = append(prog.sectionErrors, fmt.Errorf("section %s: %w", name, err))
+}
+
+// bpfSectionPrefixes are the SEC() names of BPF programs, for code sections missing SHF_EXECINSTR
+var bpfSectionPrefixes = []string{
+	"kprobe/", "kretprobe/", "uprobe", "uretprobe", "tracepoint/", "tp/", "raw_tracepoint/", "raw_tp/",
+	"tp_btf/", "fentry/", "fexit/", "fmod_ret/", "lsm/", "iter/", "struct_ops", "syscall", "xdp", "tc",
+	"classifier", "action", "socket", "sk_skb", "sk_msg", "sk_lookup", "sockops", "cgroup", "perf_event",
+	"lwt_", "flow_dissector",
+}
+
+// isCodeSection checks if an ELF section holds BPF instructions
+func isCodeSection(section *elf.Section) bool {
+	if section.Type != elf.SHT_PROGBITS {
+		return false
+	}
+	if section.Flags&elf.SHF_EXECINSTR != 0 {
+		return true
+	}
+	if section.Flags&elf.SHF_WRITE != 0 {
+		return false
+	}
+
+	for _, prefix := range bpfSectionPrefixes {
+		if strings.HasPrefix(section.Name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExecutableSection checks if an ELF section is flagged as holding instructions
+func isExecutableSection(section *elf.Section) bool {
+	return section.Type == elf.SHT_PROGBITS && section.Flags&elf.SHF_EXECINSTR != 0
+}
+
+// processCodeSections builds a section for every section matching isCode whose byte range
+// was not already processed through a function symbol
+func (prog *BPFProgram) processCodeSections(processed map[sectionRange]*Section, isCode func(*elf.Section) bool) error {
+	for index, elfSection := range prog.ELFFile.Sections {
+		if !isCode(elfSection) {
+			continue
+		}
+
+		key := sectionRange{Offset: elfSection.Offset, Size: elfSection.Size}
+		if _, ok := processed[key]; ok {
+			continue
+		}
+		processed[key] = nil
+
+		data, err := elfSection.Data()
+		if err != nil || len(data) == 0 {
+			continue
+		}
+
+		section, err := prog.newProgramSection(elfSection, index, data, 0)
+		if err != nil {
+			prog.sectionFailed(elfSection.Name, err)
+			continue
+		}
+
+		processed[key] = section
+		prog.Sections[elfSection.Name] = section
+	}
+
+	return nil
+}
+
+// processSymbol builds a section over the byte range of a single function symbol
+func (prog *BPFProgram) processSymbol(symbols []elf.Symbol, name string) error {
+	for _, symbol := range symbols {
+		if elf.ST_TYPE(symbol.Info) != elf.STT_FUNC || symbol.Name != name {
+			continue
+		}
+
+		if int(symbol.Section) >= len(prog.ELFFile.Sections) {
+			return fmt.Errorf("symbol %s has no code section", name)
+		}
+		section := prog.ELFFile.Sections[symbol.Section]
+
+		data, err := section.Data()
+		if err != nil {
+			return fmt.Errorf("failed to read section %s: %w", section.Name, err)
+		}
+
+		// In relocatable objects the symbol value is the offset within its section
+		end := symbol.Value + symbol.Size
+		if symbol.Size == 0 || end > uint64(len(data)) {
+			return fmt.Errorf("symbol %s range [%d, %d) is outside section %s", name, symbol.Value, end, section.Name)
+		}
+
+		optimizedSection, err := prog.newProgramSection(section, int(symbol.Section), data[symbol.Value:end], symbol.Value)
+		if err != nil {
+			return fmt.Errorf("failed to process symbol %s: %w", name, err)
+		}
+
+		prog.Sections[section.Name] = optimizedSection
+		prog.Symbols[symbol.Name] = optimizedSection
+		return nil
+	}
+
+	return fmt.Errorf("function symbol %s not found", name)
+}
+
+// newProgramSection builds a Section over data found at offset within the ELF section at index.
+// Optimization waits until the relocated instructions are known, so passes can leave them alone.
+// A panic on malformed input is returned as an *ErrSectionPanic so the other sections still process.
+func (prog *BPFProgram) newProgramSection(elfSection *elf.Section, index int, data []byte, offset uint64) (section *Section, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			section = nil
+			err = &ErrSectionPanic{Section: elfSection.Name, Value: r, Stack: panicStack(5)}
+		}
+	}()
+
+	section, err = NewSectionWithOptions(hex.EncodeToString(data), elfSection.Name, SectionOptions{
+		SkipOptimization: true,
+		KeepTrailingData: prog.Options.KeepTrailingData,
+		CacheDir:         prog.Options.CacheDir,
+		FunctionStarts:   prog.functionStarts(index, offset, uint64(len(data))),
+	})
+	if err != nil {
+		return nil, err
+	}
+	prog.Diagnostics = append(prog.Diagnostics, section.Diagnostics...)
+
+	section.Offset = offset
+	section.Size = uint64(len(data))
+	section.Relocations, section.MapReferences = prog.relocatedInstructions(index, offset, section.Size)
+	section.CORERelocations = prog.coreRelocatedInstructions(elfSection.Name, offset, section.Size)
+	section.Range = prog.Options.Range
+	section.ProtectRelocations = prog.Options.ProtectRelocations
+	section.MergeAcrossNopJumps = prog.Options.MergeAcrossNopJumps
+	section.AggressiveMerge = prog.Options.AggressiveMerge
+	section.AggressiveFold = prog.Options.AggressiveFold
+
+	if !prog.Options.SkipOptimization {
+		section.applyOptimizations(prog.Options.PassOrder, prog.Options.FixpointIterations)
+	}
+
+	return section, nil
+}
+
+// panicStack returns up to depth frames of the panicking goroutine as `func (file:line)`.
+// It must be called from the deferred function that recovered.
+func panicStack(depth int) []string {
+	pcs := make([]uintptr, 32)
+	// Skip runtime.Callers, panicStack and the deferred function
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+
+	stack := make([]string, 0, depth)
+	for len(stack) < depth {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			// Drop the import path, keeping e.g. optimizer.(*Section).applyCompaction
+			function := frame.Function[strings.LastIndex(frame.Function, "/")+1:]
+			stack = append(stack, fmt.Sprintf("%s (%s:%d)", function, filepath.Base(frame.File), frame.Line))
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// relocatedInstructions returns the indices, relative to offset, of the instructions
+// patched by the relocation sections that apply to the ELF section at index, and among
+// them the loads of a map, with the name of the map
+func (prog *BPFProgram) relocatedInstructions(index int, offset, size uint64) (map[int]bool, map[int]string) {
+	relocated := make(map[int]bool)
+	maps := make(map[int]string)
+	symbols, _ := prog.ELFFile.Symbols()
+
+	for _, s := range prog.ELFFile.Sections {
+		if (s.Type != elf.SHT_REL && s.Type != elf.SHT_RELA) || int(s.Info) != index {
+			continue
+		}
+
+		data, err := s.Data()
+		if err != nil {
+			continue
+		}
+
+		// Every entry starts with r_offset; Elf64_Rel is 16 bytes, Elf64_Rela 24
+		entrySize := int(s.Entsize)
+		if entrySize == 0 {
+			entrySize = 16
+		}
+
+		for i := 0; i+entrySize <= len(data); i += entrySize {
+			relOffset := prog.ELFFile.ByteOrder.Uint64(data[i:])
+			if relOffset < offset || relOffset >= offset+size {
+				continue
+			}
+
+			idx := int((relOffset - offset) / bpf.InstructionSize)
+			relocated[idx] = true
+
+			// r_info follows r_offset, with the symbol index in its upper half; Symbols skips entry 0
+			sym := int(prog.ELFFile.ByteOrder.Uint64(data[i+8:])>>32) - 1
+			if sym >= 0 && sym < len(symbols) && prog.isMapSymbol(symbols[sym]) {
+				maps[idx] = symbols[sym].Name
+			}
+		}
+	}
+
+	return relocated, maps
+}
+
+// functionStarts returns the sorted instruction indices where the function symbols of the ELF
+// section at index start, for those inside the section's byte range [offset, offset+size)
+func (prog *BPFProgram) functionStarts(index int, offset, size uint64) []int {
+	starts := make([]int, 0)
+	symbols, _ := prog.ELFFile.Symbols()
+
+	for _, symbol := range symbols {
+		if elf.ST_TYPE(symbol.Info) != elf.STT_FUNC || int(symbol.Section) != index ||
+			symbol.Value < offset || symbol.Value >= offset+size {
+			continue
+		}
+		starts = append(starts, int((symbol.Value-offset)/bpf.InstructionSize))
+	}
+	sort.Ints(starts)
+
+	return starts
+}
+
+// isMapSymbol checks if symbol is a map definition, in the BTF .maps section or the legacy maps sections
+func (prog *BPFProgram) isMapSymbol(symbol elf.Symbol) bool {
+	if int(symbol.Section) >= len(prog.ELFFile.Sections) || elf.ST_TYPE(symbol.Info) == elf.STT_SECTION {
+		return false
+	}
+
+	name := prog.ELFFile.Sections[symbol.Section].Name
+	return name == ".maps" || name == "maps" || strings.HasPrefix(name, "maps/")
+}
+
+// moveRelocations points the relocations of the map loads map-dedup turned into register copies
+// at the load they now reuse. The relocation table keeps its size, so the kept load ends up with
+// two identical map relocations, which loaders resolve to the same map fd.
+func (prog *BPFProgram) moveRelocations(data []byte, elfFile *elf.File, sectionName string, section *Section) error {
+	if len(section.MovedRelocations) == 0 {
+		return nil
+	}
+
+	index := -1
+	for i, s := range elfFile.Sections {
+		if s.Name == sectionName {
+			index = i
+			break
+		}
+	}
+
+	for _, s := range elfFile.Sections {
+		if (s.Type != elf.SHT_REL && s.Type != elf.SHT_RELA) || int(s.Info) != index {
+			continue
+		}
+
+		entrySize := s.Entsize
+		if entrySize == 0 {
+			entrySize = 16
+		}
+		if s.Offset+s.Size > uint64(len(data)) {
+			return fmt.Errorf("relocation section %s extends past the end of the file", s.Name)
+		}
+
+		for i := s.Offset; i+entrySize <= s.Offset+s.Size; i += entrySize {
+			relOffset := elfFile.ByteOrder.Uint64(data[i:])
+			if relOffset < section.Offset || (relOffset-section.Offset)%bpf.InstructionSize != 0 {
+				continue
+			}
+
+			kept, ok := section.MovedRelocations[int((relOffset-section.Offset)/bpf.InstructionSize)]
+			if ok {
+				elfFile.ByteOrder.PutUint64(data[i:], section.Offset+uint64(kept)*bpf.InstructionSize)
+			}
 		}
 	}
 
 	return nil
 }
 
-// Save saves the optimized program to a new ELF file
+// sectionRange identifies the bytes of a section within the ELF file
+type sectionRange struct {
+	Offset uint64
+	Size   uint64
+}
+
+// Save writes the optimized program to outputPath
 func (prog *BPFProgram) Save(outputPath string) error {
-	// This is a simplified implementation
-	// A full implementation would need to properly reconstruct the ELF file
-	// with modified section contents while preserving other metadata
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+
+	_, err = prog.WriteTo(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
 
-	// For now, we'll create a basic implementation that copies the original
-	// file and patches the modified sections
+// WriteTo writes the original ELF file with the optimized sections patched in place,
+// so everything but the section contents (relocations, BTF, symbols) is preserved
+func (prog *BPFProgram) WriteTo(w io.Writer) (int64, error) {
+	data, err := prog.optimizedImage()
+	if err != nil {
+		return 0, err
+	}
 
-	// Copy original file
-	if err := copyFile(prog.FilePath, outputPath); err != nil {
-		return fmt.Errorf("failed to copy original file: %v", err)
+	n, err := w.Write(data)
+	if err != nil {
+		return int64(n), fmt.Errorf("failed to write output: %v", err)
 	}
+	return int64(n), nil
+}
 
-	// Open the copied file for modification
-	file, err := os.OpenFile(outputPath, os.O_RDWR, 0644)
+// optimizedImage returns the contents of the input file with every section replaced by its optimized data
+func (prog *BPFProgram) optimizedImage() ([]byte, error) {
+	data, err := os.ReadFile(prog.FilePath)
 	if err != nil {
-		return fmt.Errorf("failed to open output file: %v", err)
+		return nil, fmt.Errorf("failed to read original file: %v", err)
 	}
-	defer file.Close()
 
-	// Parse the copied ELF file
-	outputELF, err := elf.NewFile(file)
+	outputELF, err := elf.NewFile(bytes.NewReader(data))
 	if err != nil {
-		return fmt.Errorf("failed to parse output ELF: %v", err)
+		return nil, fmt.Errorf("failed to parse original ELF: %v", err)
 	}
 
-	// Update sections with optimized data
+	// Update sections with optimized data, leaving BTF and the data sections as they were
+	btf := btfSnapshot(data, outputELF)
+	preserved := snapshotSections(data, outputELF, prog.dataSectionNames(outputELF))
 	for sectionName, optimizedSection := range prog.Sections {
-		if err := prog.updateSectionInFile(file, outputELF, sectionName, optimizedSection); err != nil {
-			fmt.Printf("Warning: failed to update section %s: %v\n", sectionName, err)
+		if err := prog.updateSectionData(data, outputELF, sectionName, optimizedSection); err != nil {
+			prog.diagnose(SeverityError, sectionName, "failed to update section: %v", err)
+			continue
+		}
+		if err := prog.moveRelocations(data, outputELF, sectionName, optimizedSection); err != nil {
+			prog.diagnose(SeverityError, sectionName, "failed to move relocations: %v", err)
 		}
 	}
+	if err := btf.verify(data); err != nil {
+		return nil, err
+	}
+	if err := preserved.verify(data); err != nil {
+		return nil, err
+	}
 
-	return nil
+	return data, nil
 }
 
-// updateSectionInFile updates a section in the ELF file with optimized data
-func (prog *BPFProgram) updateSectionInFile(file *os.File, elfFile *elf.File, sectionName string, section *Section) error {
-	// Find the section in the ELF file
-	var targetSection *elf.Section
-	for _, s := range elfFile.Sections {
-		if s.Name == sectionName {
-			targetSection = s
-			break
+// dataSectionNames returns the sections holding no instructions the output must keep byte for
+// byte: .rodata, .data, .maps, license and the like, their relocations and the symbol table
+// that references them. .bss has no file contents to keep.
+func (prog *BPFProgram) dataSectionNames(elfFile *elf.File) []string {
+	isData := func(section *elf.Section) bool {
+		_, optimized := prog.Sections[section.Name]
+		return section.Type == elf.SHT_PROGBITS && !optimized && !isCodeSection(section)
+	}
+
+	names := make([]string, 0)
+	for _, section := range elfFile.Sections {
+		switch section.Type {
+		case elf.SHT_PROGBITS:
+			if !isData(section) {
+				continue
+			}
+		case elf.SHT_REL, elf.SHT_RELA:
+			if int(section.Info) >= len(elfFile.Sections) || !isData(elfFile.Sections[section.Info]) {
+				continue
+			}
+		case elf.SHT_SYMTAB:
+		default:
+			continue
 		}
+		names = append(names, section.Name)
 	}
+	return names
+}
 
+// sectionFileRange returns the file offset and size of the bytes a section was built over
+func sectionFileRange(elfFile *elf.File, sectionName string, section *Section) (uint64, uint64, error) {
+	targetSection := elfFile.Section(sectionName)
 	if targetSection == nil {
-		return fmt.Errorf("section %s not found", sectionName)
+		return 0, 0, fmt.Errorf("section %s not found", sectionName)
+	}
+
+	// A section built over a symbol only owns that symbol's bytes
+	size := section.Size
+	if size == 0 {
+		size = targetSection.Size - section.Offset
+	}
+	return targetSection.Offset + section.Offset, size, nil
+}
+
+// updateSectionData patches a section of the ELF file contents with optimized data
+func (prog *BPFProgram) updateSectionData(data []byte, elfFile *elf.File, sectionName string, section *Section) error {
+	start, size, err := sectionFileRange(elfFile, sectionName, section)
+	if err != nil {
+		return err
 	}
 
 	// Get optimized data
-	optimizedData := section.Dump()
+	optimizedData, err := section.ToBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode optimized data: %v", err)
+	}
 
 	// Check if the optimized data fits in the original section
-	if uint64(len(optimizedData)) > targetSection.Size {
+	if uint64(len(optimizedData)) > size {
 		return fmt.Errorf("optimized data is larger than original section")
 	}
-
-	// Write optimized data to the section offset in the file
-	_, err := file.WriteAt(optimizedData, int64(targetSection.Offset))
-	if err != nil {
-		return fmt.Errorf("failed to write optimized data: %v", err)
+	if start+size > uint64(len(data)) {
+		return fmt.Errorf("section %s extends past the end of the file", sectionName)
 	}
 
-	// If the optimized data is smaller, pad with zeros
-	if uint64(len(optimizedData)) < targetSection.Size {
-		padding := make([]byte, targetSection.Size-uint64(len(optimizedData)))
-		_, err = file.WriteAt(padding, int64(targetSection.Offset)+int64(len(optimizedData)))
-		if err != nil {
-			return fmt.Errorf("failed to write padding: %v", err)
+	// Write optimized data to the section offset in the file
+	copy(data[start:], optimizedData)
+
+	// If the optimized data is smaller, pad with NOPs so the tail still decodes to valid instructions
+	if uint64(len(optimizedData)) < size {
+		prog.diagnose(SeverityWarning, sectionName, "shrank by %d bytes, padding with NOP instructions",
+			size-uint64(len(optimizedData)))
+		if elfFile.Section(".BTF.ext") != nil {
+			prog.diagnose(SeverityWarning, sectionName, ".BTF.ext func/line info still refers to the original instruction offsets")
 		}
+		copy(data[start+uint64(len(optimizedData)):], nopPadding(size-uint64(len(optimizedData))))
 	}
 
 	return nil
 }
 
+// nopPadding returns size bytes filled with the NOP encoding.
+// A trailing remainder shorter than an instruction is left zeroed.
+func nopPadding(size uint64) []byte {
+	nop, _ := hex.DecodeString(bpf.NOPEncoding())
+	padding := make([]byte, size)
+	for i := 0; i+len(nop) <= len(padding); i += len(nop) {
+		copy(padding[i:], nop)
+	}
+	return padding
+}
+
 // Close closes the ELF file
 func (prog *BPFProgram) Close() error {
 	if prog.ELFFile != nil {
@@ -173,25 +642,47 @@ func (prog *BPFProgram) GetOptimizationStats() map[string]interface{} {
 	totalInstructions := 0
 	optimizedInstructions := 0
 	nopInstructions := 0
+	histogram := make(map[string]int)
+	candidates := make(PassCandidates)
+	eliminated := make(map[string]int)
+	var verifierStats OptimizationStats
 
 	for sectionName, section := range prog.Sections {
 		sectionStats := make(map[string]int)
 		sectionStats["total"] = len(section.Instructions)
 
 		nops := 0
-		for _, inst := range section.Instructions {
-			if inst.IsNOP() {
+		for i, inst := range section.Instructions {
+			// Count only the fillers written by the optimizer, not no-ops already in the input
+			if inst.IsSyntheticNOP() && !section.InputNOPs[i] {
 				nops++
 			}
 		}
 		sectionStats["nops"] = nops
-		sectionStats["active"] = len(section.Instructions) - nops
+		sectionStats["input_nops"] = len(section.InputNOPs)
+		sectionStats["active"] = section.ActiveInstructionCount()
+		sectionStats["stack_depth"] = section.MaxStackDepth()
+		sectionStats["instructions_before"] = section.Stats.InstructionsBefore
+		sectionStats["instructions_after"] = section.Stats.InstructionsAfter
+		sectionStats["branches_before"] = section.Stats.BranchesBefore
+		sectionStats["branches_after"] = section.Stats.BranchesAfter
 
 		stats[sectionName] = sectionStats
 
 		totalInstructions += len(section.Instructions)
 		nopInstructions += nops
 		optimizedInstructions += nops
+		verifierStats.InstructionsBefore += section.Stats.InstructionsBefore
+		verifierStats.InstructionsAfter += section.Stats.InstructionsAfter
+		verifierStats.BranchesBefore += section.Stats.BranchesBefore
+		verifierStats.BranchesAfter += section.Stats.BranchesAfter
+		for mnemonic, count := range section.InstructionClassHistogram() {
+			histogram[mnemonic] += count
+		}
+		candidates.Add(section.Candidates)
+		for pass, count := range section.EliminatedByPass() {
+			eliminated[pass] += count
+		}
 	}
 
 	stats["summary"] = map[string]interface{}{
@@ -199,25 +690,14 @@ func (prog *BPFProgram) GetOptimizationStats() map[string]interface{} {
 		"optimized_instructions": optimizedInstructions,
 		"nop_instructions":       nopInstructions,
 		"optimization_ratio":     float64(optimizedInstructions) / float64(totalInstructions),
+		"instructions_before":    verifierStats.InstructionsBefore,
+		"instructions_after":     verifierStats.InstructionsAfter,
+		"branches_before":        verifierStats.BranchesBefore,
+		"branches_after":         verifierStats.BranchesAfter,
+		"histogram":              histogram,
+		"candidates":             candidates,
+		"eliminated":             eliminated,
 	}
 
 	return stats
 }
-
-// copyFile copies a file from src to dst
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer sourceFile.Close()
-
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer destFile.Close()
-
-	_, err = io.Copy(destFile, sourceFile)
-	return err
-}
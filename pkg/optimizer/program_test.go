@@ -0,0 +1,701 @@
+package optimizer
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+const testObjectFile = "../../testdata/bpf_generic_uprobe_v61.o"
+
+func readSectionData(t *testing.T, path, name string) []byte {
+	t.Helper()
+
+	elfFile, err := elf.Open(path)
+	if err != nil {
+		t.Fatalf("elf.Open() error = %v", err)
+	}
+	defer elfFile.Close()
+
+	section := elfFile.Section(name)
+	if section == nil {
+		t.Fatalf("section %s not found in %s", name, path)
+	}
+
+	data, err := section.Data()
+	if err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+	return data
+}
+
+func TestSavePadsShrunkSectionWithNOPs(t *testing.T) {
+	const sectionName = "uprobe/generic_uprobe"
+	original := readSectionData(t, testObjectFile, sectionName)
+
+	// Keep only the first 4 instructions so Save has to pad the rest
+	keep := 4 * bpf.InstructionSize
+	section, err := NewSection(hex.EncodeToString(original[:keep]), sectionName, true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	elfFile, err := elf.Open(testObjectFile)
+	if err != nil {
+		t.Fatalf("elf.Open() error = %v", err)
+	}
+	prog := &BPFProgram{
+		FilePath: testObjectFile,
+		ELFFile:  elfFile,
+		Sections: map[string]*Section{sectionName: section},
+	}
+	defer prog.Close()
+
+	outputPath := filepath.Join(t.TempDir(), "out.o")
+	if err := prog.Save(outputPath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	got := readSectionData(t, outputPath, sectionName)
+	if len(got) != len(original) {
+		t.Fatalf("section size changed: got %d, want %d", len(got), len(original))
+	}
+
+	for i := 0; i < len(got); i += bpf.InstructionSize {
+		inst, err := bpf.NewInstruction(hex.EncodeToString(got[i : i+bpf.InstructionSize]))
+		if err != nil {
+			t.Fatalf("instruction at %d does not decode: %v", i/bpf.InstructionSize, err)
+		}
+		if i < keep {
+			if inst.Raw != section.Instructions[i/bpf.InstructionSize].Raw {
+				t.Errorf("instruction %d: got %s, want %s", i/bpf.InstructionSize, inst.Raw, section.Instructions[i/bpf.InstructionSize].Raw)
+			}
+			continue
+		}
+		if !inst.IsNOP() {
+			t.Errorf("padding instruction %d: got %s, want NOP", i/bpf.InstructionSize, inst.Raw)
+		}
+	}
+}
+
+func TestProcessSectionsSharesSectionAcrossSymbols(t *testing.T) {
+	prog, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	// Both functions live in .text, so they must share one optimized Section
+	first, second := prog.Symbols["extract_arg_depth"], prog.Symbols["filter_inet"]
+	if first == nil || second == nil {
+		t.Fatalf("symbols not mapped: extract_arg_depth=%v, filter_inet=%v", first != nil, second != nil)
+	}
+	if first != second || first != prog.Sections[".text"] {
+		t.Errorf("symbols in .text were optimized into separate sections")
+	}
+
+	// One Section per executable ELF section, no matter how many symbols point into it
+	if len(prog.Sections) != 3 {
+		t.Errorf("got %d sections, want 3", len(prog.Sections))
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "out.o")
+	if err := prog.Save(outputPath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	for name, section := range prog.Sections {
+		want, err := section.ToBytes()
+		if err != nil {
+			t.Fatalf("ToBytes() error = %v", err)
+		}
+		if got := readSectionData(t, outputPath, name); !bytes.Equal(got, want) {
+			t.Errorf("section %s in the saved file does not match the optimized instructions", name)
+		}
+	}
+}
+
+func TestSaveOnlyTargetedSymbol(t *testing.T) {
+	// cwd_read_v61 occupies [0x120, 0x4c0) of .text, right after extract_arg_depth [0, 0x120)
+	const start, end = 0x120, 0x4c0
+
+	prog, err := NewBPFProgramWithOptions(testObjectFile, ProgramOptions{Symbol: "cwd_read_v61"})
+	if err != nil {
+		t.Fatalf("NewBPFProgramWithOptions() error = %v", err)
+	}
+	defer prog.Close()
+
+	if len(prog.Sections) != 1 || prog.Sections[".text"] == nil {
+		t.Fatalf("expected only .text to be loaded, got %d sections", len(prog.Sections))
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "out.o")
+	if err := prog.Save(outputPath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	original := readSectionData(t, testObjectFile, ".text")
+	got := readSectionData(t, outputPath, ".text")
+
+	if !bytes.Equal(got[:start], original[:start]) {
+		t.Errorf("extract_arg_depth changed although only cwd_read_v61 was targeted")
+	}
+	if !bytes.Equal(got[end:], original[end:]) {
+		t.Errorf("bytes after cwd_read_v61 changed")
+	}
+	if bytes.Equal(got[start:end], original[start:end]) {
+		t.Errorf("cwd_read_v61 was not optimized")
+	}
+
+	for _, name := range []string{"uprobe", "uprobe/generic_uprobe"} {
+		if !bytes.Equal(readSectionData(t, outputPath, name), readSectionData(t, testObjectFile, name)) {
+			t.Errorf("section %s changed although it does not hold the symbol", name)
+		}
+	}
+}
+
+func TestUnknownSymbol(t *testing.T) {
+	if _, err := NewBPFProgramWithOptions(testObjectFile, ProgramOptions{Symbol: "no_such_function"}); err == nil {
+		t.Errorf("expected an error for an unknown symbol")
+	}
+}
+
+func TestProcessSectionsReadsRelocations(t *testing.T) {
+	prog, err := NewBPFProgramWithOptions(testObjectFile, ProgramOptions{SkipOptimization: true})
+	if err != nil {
+		t.Fatalf("NewBPFProgramWithOptions() error = %v", err)
+	}
+	defer prog.Close()
+
+	// .reluprobe/generic_uprobe holds 6 entries, each patching an lddw
+	section := prog.Sections["uprobe/generic_uprobe"]
+	if len(section.Relocations) != 6 {
+		t.Fatalf("got %d relocated instructions, want 6", len(section.Relocations))
+	}
+	for idx := range section.Relocations {
+		if !section.Instructions[idx].IsLoadImm64() && section.Instructions[idx].Opcode != bpf.BPF_JMP|bpf.JMP_CALL {
+			t.Errorf("relocation at %d points at %s, not an lddw or call", idx, section.Instructions[idx].Raw)
+		}
+	}
+}
+
+func TestNewBPFProgramInputErrors(t *testing.T) {
+	textFile := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(textFile, []byte("this is not an object file\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	_, err := NewBPFProgram(textFile)
+	var notELF *ErrNotELF
+	if !errors.As(err, &notELF) {
+		t.Errorf("NewBPFProgram(text file) error = %v, want ErrNotELF", err)
+	}
+
+	// A valid BPF ELF holding only data and a license section
+	_, err = NewBPFProgram("../../testdata/bpf_no_functions.o")
+	var noSections *ErrNoBPFSections
+	if !errors.As(err, &noSections) {
+		t.Errorf("NewBPFProgram(no functions) error = %v, want ErrNoBPFSections", err)
+	}
+	if errors.As(err, &notELF) {
+		t.Errorf("an ELF without functions must not be reported as not an ELF")
+	}
+}
+
+func TestProcessSectionsRecoversFromPanic(t *testing.T) {
+	const badSection = "uprobe/generic_uprobe"
+
+	// Stand in for a pass indexing past the end of malformed input
+	peephole := passTable[PassPeephole]
+	passTable[PassPeephole] = func(s *Section) {
+		if s.Name == badSection {
+			_ = s.Instructions[len(s.Instructions)]
+		}
+		peephole(s)
+	}
+	defer func() { passTable[PassPeephole] = peephole }()
+
+	prog, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	if _, ok := prog.Sections[badSection]; ok {
+		t.Errorf("section %s should have been skipped", badSection)
+	}
+	for _, name := range []string{".text", "uprobe"} {
+		section := prog.Sections[name]
+		if section == nil {
+			t.Fatalf("section %s was not processed", name)
+		}
+		if section.Stats.InstructionsAfter >= section.Stats.InstructionsBefore {
+			t.Errorf("section %s was not optimized: %+v", name, section.Stats)
+		}
+	}
+
+	elfSection := prog.ELFFile.Section(badSection)
+	sectionData, _ := elfSection.Data()
+	index := 0
+	for i, s := range prog.ELFFile.Sections {
+		if s == elfSection {
+			index = i
+		}
+	}
+	_, err = prog.newProgramSection(elfSection, index, sectionData, 0)
+	var panicErr *ErrSectionPanic
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("newProgramSection() error = %v, want *ErrSectionPanic", err)
+	}
+	if panicErr.Section != badSection || len(panicErr.Stack) == 0 {
+		t.Errorf("ErrSectionPanic = %+v, want section %s and a stack", panicErr, badSection)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "out.o")
+	if err := prog.Save(outputPath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if !bytes.Equal(readSectionData(t, outputPath, badSection), readSectionData(t, testObjectFile, badSection)) {
+		t.Errorf("skipped section %s changed in the output", badSection)
+	}
+	if bytes.Equal(readSectionData(t, outputPath, "uprobe"), readSectionData(t, testObjectFile, "uprobe")) {
+		t.Errorf("section uprobe was not written")
+	}
+}
+
+func TestNewBPFProgramAllSectionsFail(t *testing.T) {
+	peephole := passTable[PassPeephole]
+	passTable[PassPeephole] = func(s *Section) {
+		_ = s.Instructions[len(s.Instructions)]
+	}
+	defer func() { passTable[PassPeephole] = peephole }()
+
+	// Every code section is found but none survives, which is not a missing section
+	_, err := NewBPFProgram(testObjectFile)
+	var failed *ErrSectionsFailed
+	if !errors.As(err, &failed) {
+		t.Fatalf("NewBPFProgram() error = %v, want ErrSectionsFailed", err)
+	}
+	if len(failed.Errors) != 3 {
+		t.Errorf("got %d section errors, want one per code section: %v", len(failed.Errors), failed.Errors)
+	}
+	var panicErr *ErrSectionPanic
+	if !errors.As(err, &panicErr) {
+		t.Errorf("NewBPFProgram() error = %v, want it to wrap *ErrSectionPanic", err)
+	}
+	var noSections *ErrNoBPFSections
+	if errors.As(err, &noSections) {
+		t.Errorf("NewBPFProgram() error = %v, must not be ErrNoBPFSections", err)
+	}
+}
+
+func TestWriteToMatchesSave(t *testing.T) {
+	prog, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	var buf bytes.Buffer
+	n, err := prog.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo() = %d, wrote %d bytes", n, buf.Len())
+	}
+
+	outputELF, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("WriteTo() output does not parse as ELF: %v", err)
+	}
+	for name, section := range prog.Sections {
+		elfSection := outputELF.Section(name)
+		if elfSection == nil {
+			t.Fatalf("section %s missing from WriteTo() output", name)
+		}
+		data, err := elfSection.Data()
+		if err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+		want, _ := section.ToBytes()
+		if !bytes.Equal(data[section.Offset:section.Offset+uint64(len(want))], want) {
+			t.Errorf("section %s in WriteTo() output differs from the optimized section", name)
+		}
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "out.o")
+	if err := prog.Save(outputPath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	saved, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(saved, buf.Bytes()) {
+		t.Errorf("Save() and WriteTo() wrote different bytes")
+	}
+}
+
+func TestNewBPFProgramWithoutSymbols(t *testing.T) {
+	// An xdp section holding r1 <<= 32; r1 >>= 32; r0 = r1; exit, stripped of its symbol table
+	prog, err := NewBPFProgram("../../testdata/bpf_stripped.o")
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	if len(prog.Sections) != 1 {
+		t.Fatalf("got sections %v, want only xdp", prog.Sections)
+	}
+	section, ok := prog.Sections["xdp"]
+	if !ok {
+		t.Fatalf("section xdp not discovered, got %v", prog.Sections)
+	}
+
+	expected := []string{"bc11000000000000", bpf.NOP, "bf10000000000000", "9500000000000000"}
+	for i, want := range expected {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+	}
+}
+
+func TestNewBPFProgramExecutableSectionWithoutSymbol(t *testing.T) {
+	// xdp holds the function prog, handwritten is an executable section with no function symbol.
+	// Both start with a zero-extension of a 64-bit register through lsh/rsh.
+	prog, err := NewBPFProgram("../../testdata/bpf_multi_exec.o")
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	expected := map[string][]string{
+		"xdp":         {"bc33000000000000", bpf.NOP, "b700000000000000", "9500000000000000"},
+		"handwritten": {"bc44000000000000", bpf.NOP, "b700000001000000", "9500000000000000"},
+	}
+	if len(prog.Sections) != len(expected) {
+		t.Fatalf("got sections %v, want xdp and handwritten", prog.Sections)
+	}
+
+	for name, want := range expected {
+		section, ok := prog.Sections[name]
+		if !ok {
+			t.Errorf("section %s not discovered", name)
+			continue
+		}
+		for i := range want {
+			if got := section.Instructions[i].Raw; got != want[i] {
+				t.Errorf("%s instruction %d = %s, expected %s", name, i, got, want[i])
+			}
+		}
+	}
+
+	if _, ok := prog.Symbols["prog"]; !ok || len(prog.Symbols) != 1 {
+		t.Errorf("got symbols %v, want only prog", prog.Symbols)
+	}
+}
+
+func TestPatchMatchesSave(t *testing.T) {
+	prog, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	saved := filepath.Join(t.TempDir(), "optimized.o")
+	if err := prog.Save(saved); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	want, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	entries, err := prog.Patch()
+	if err != nil {
+		t.Fatalf("Patch() error = %v", err)
+	}
+	if len(entries) == 0 {
+		t.Fatalf("Patch() found no changes on the fixture")
+	}
+
+	var patch bytes.Buffer
+	if err := WritePatch(&patch, entries); err != nil {
+		t.Fatalf("WritePatch() error = %v", err)
+	}
+	parsed, err := ReadPatch(&patch)
+	if err != nil {
+		t.Fatalf("ReadPatch() error = %v", err)
+	}
+
+	got, err := os.ReadFile(testObjectFile)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if err := ApplyPatch(got, parsed); err != nil {
+		t.Fatalf("ApplyPatch() error = %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("applying the patch differs from Save")
+	}
+
+	// The patched file no longer holds the old bytes
+	var mismatch *ErrPatchMismatch
+	if err := ApplyPatch(got, parsed); !errors.As(err, &mismatch) {
+		t.Errorf("ApplyPatch() twice error = %v, want ErrPatchMismatch", err)
+	}
+}
+
+func TestPatchLongRun(t *testing.T) {
+	// 4096 changed slots in a row make a line past bufio.Scanner's default limit
+	original := bytes.Repeat([]byte{0xb7, 0x01, 0, 0, 0x01, 0, 0, 0}, 4096)
+	optimized := bytes.Repeat([]byte{0x05, 0, 0, 0, 0, 0, 0, 0}, 4096)
+	entries := appendChangedRuns(nil, ".text", original, optimized, 0, uint64(len(original)))
+	if len(entries) != 1 {
+		t.Fatalf("appendChangedRuns() = %d entries, want one run", len(entries))
+	}
+
+	var patch bytes.Buffer
+	if err := WritePatch(&patch, entries); err != nil {
+		t.Fatalf("WritePatch() error = %v", err)
+	}
+	parsed, err := ReadPatch(&patch)
+	if err != nil {
+		t.Fatalf("ReadPatch() error = %v", err)
+	}
+	if !reflect.DeepEqual(parsed, entries) {
+		t.Fatalf("ReadPatch() = %d entries, want the written run back", len(parsed))
+	}
+
+	if err := ApplyPatch(original, parsed); err != nil {
+		t.Fatalf("ApplyPatch() error = %v", err)
+	}
+	if !bytes.Equal(original, optimized) {
+		t.Errorf("applying the patch differs from the optimized bytes")
+	}
+}
+
+func TestSavePreservesSectionLayout(t *testing.T) {
+	prog, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	outputPath := filepath.Join(t.TempDir(), "out.o")
+	if err := prog.Save(outputPath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	original, err := elf.Open(testObjectFile)
+	if err != nil {
+		t.Fatalf("elf.Open() error = %v", err)
+	}
+	defer original.Close()
+
+	saved, err := elf.Open(outputPath)
+	if err != nil {
+		t.Fatalf("elf.Open() error = %v", err)
+	}
+	defer saved.Close()
+
+	// Save patches code in place, so every header, offset and alignment is the original one
+	if len(saved.Sections) != len(original.Sections) {
+		t.Fatalf("got %d sections, want %d", len(saved.Sections), len(original.Sections))
+	}
+	for i, want := range original.Sections {
+		got := saved.Sections[i]
+		if got.SectionHeader != want.SectionHeader {
+			t.Errorf("section %s header = %+v, want %+v", want.Name, got.SectionHeader, want.SectionHeader)
+		}
+		if want.Addralign > 1 && got.Offset%want.Addralign != 0 {
+			t.Errorf("section %s offset %d is not aligned to %d", want.Name, got.Offset, want.Addralign)
+		}
+	}
+}
+
+func TestSavePreservesDataSections(t *testing.T) {
+	prog, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	original, err := elf.Open(testObjectFile)
+	if err != nil {
+		t.Fatalf("elf.Open() error = %v", err)
+	}
+	defer original.Close()
+
+	names := prog.dataSectionNames(original)
+	for _, want := range []string{".rodata.str1.1", ".maps", ".rel.maps", "license", ".symtab"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("dataSectionNames() = %v, missing %s", names, want)
+		}
+	}
+	for name := range prog.Sections {
+		if slices.Contains(names, name) || slices.Contains(names, ".rel"+name) {
+			t.Errorf("dataSectionNames() = %v, holds code section %s or its relocations", names, name)
+		}
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "out.o")
+	if err := prog.Save(outputPath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	saved, err := elf.Open(outputPath)
+	if err != nil {
+		t.Fatalf("elf.Open() error = %v", err)
+	}
+	defer saved.Close()
+
+	for _, name := range names {
+		want, err := original.Section(name).Data()
+		if err != nil {
+			t.Fatalf("section %s Data() error = %v", name, err)
+		}
+		got, err := saved.Section(name).Data()
+		if err != nil {
+			t.Fatalf("saved section %s Data() error = %v", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("section %s differs in the output", name)
+		}
+	}
+
+	wantSymbols, _ := original.Symbols()
+	gotSymbols, err := saved.Symbols()
+	if err != nil || !reflect.DeepEqual(gotSymbols, wantSymbols) {
+		t.Errorf("symbols differ in the output (error %v)", err)
+	}
+}
+
+func TestProtectRelocationsKeepsMapReferences(t *testing.T) {
+	// xdp: r1 = events ll; r2 = events ll; r3 <<= 32; r3 >>= 32; r0 = 0; exit.
+	// Both lddw carry an R_BPF_64_64 relocation against the map.
+	prog, err := NewBPFProgramWithOptions("../../testdata/bpf_map_reference.o", ProgramOptions{ProtectRelocations: true})
+	if err != nil {
+		t.Fatalf("NewBPFProgramWithOptions() error = %v", err)
+	}
+	defer prog.Close()
+
+	section := prog.Sections["xdp"]
+	if section == nil {
+		t.Fatalf("section xdp not found, got %v", prog.Sections)
+	}
+	if !section.Relocations[0] || !section.Relocations[2] {
+		t.Fatalf("got relocations %v, want both lddw", section.Relocations)
+	}
+
+	expected := []string{
+		"1801000000000000", "0000000000000000",
+		"1802000000000000", "0000000000000000",
+		"bc33000000000000", bpf.NOP,
+		"b700000000000000", "9500000000000000",
+	}
+	for i, want := range expected {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+	}
+}
+
+func TestMapLoadDedup(t *testing.T) {
+	prog, err := NewBPFProgramWithOptions("../../testdata/bpf_map_reference.o", ProgramOptions{PassOrder: []string{PassMapLoadDedup}})
+	if err != nil {
+		t.Fatalf("NewBPFProgramWithOptions() error = %v", err)
+	}
+	defer prog.Close()
+
+	section := prog.Sections["xdp"]
+	if section == nil {
+		t.Fatalf("section xdp not found, got %v", prog.Sections)
+	}
+	if section.MapReferences[0] != "events" || section.MapReferences[2] != "events" {
+		t.Fatalf("MapReferences = %v, expected both lddw to load events", section.MapReferences)
+	}
+
+	// The second load becomes r2 = r1
+	expected := []string{
+		"1801000000000000", "0000000000000000",
+		"bf12000000000000", bpf.NOP,
+	}
+	for i, want := range expected {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "out.o")
+	if err := prog.Save(outputPath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	saved, err := elf.Open(outputPath)
+	if err != nil {
+		t.Fatalf("elf.Open() error = %v", err)
+	}
+	defer saved.Close()
+
+	rels, err := saved.Section(".relxdp").Data()
+	if err != nil {
+		t.Fatalf("reading .relxdp: %v", err)
+	}
+
+	// Both relocations now patch the first load, none is left on the register copy
+	for i := 0; i+16 <= len(rels); i += 16 {
+		if offset := saved.ByteOrder.Uint64(rels[i:]); offset != 0 {
+			t.Errorf("relocation %d at offset %d, expected 0", i/16, offset)
+		}
+	}
+
+	entries, err := prog.Patch()
+	if err != nil {
+		t.Fatalf("Patch() error = %v", err)
+	}
+	patched := false
+	for _, entry := range entries {
+		patched = patched || entry.Section == ".relxdp"
+	}
+	if !patched {
+		t.Errorf("Patch() = %v, expected the .relxdp change", entries)
+	}
+}
+
+func TestSaveDiagnosticsSectionNotFound(t *testing.T) {
+	prog, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	missing, err := NewSection("9500000000000000", "missing", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	prog.Sections["missing"] = missing
+
+	if err := prog.Save(filepath.Join(t.TempDir(), "out.o")); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	found := false
+	for _, d := range prog.Diagnostics {
+		if d.Section == "missing" && d.Severity == SeverityError && strings.Contains(d.Message, "not found") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("got diagnostics %v, want a section not found error for missing", prog.Diagnostics)
+	}
+}
@@ -0,0 +1,59 @@
+package optimizer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IndexRange is a half-open range [Start, End) of instruction indices
+type IndexRange struct {
+	Start int
+	End   int
+}
+
+// ParseIndexRange parses "start:end" as taken by the -range flag
+func ParseIndexRange(value string) (*IndexRange, error) {
+	startStr, endStr, ok := strings.Cut(value, ":")
+	if !ok {
+		return nil, fmt.Errorf("range %q must be start:end", value)
+	}
+
+	start, err := strconv.Atoi(startStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid range start %q: %v", startStr, err)
+	}
+	end, err := strconv.Atoi(endStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid range end %q: %v", endStr, err)
+	}
+
+	if start < 0 || end <= start {
+		return nil, fmt.Errorf("range %q is empty or negative", value)
+	}
+
+	return &IndexRange{Start: start, End: end}, nil
+}
+
+// Contains checks if every index lies within the range
+func (r IndexRange) Contains(indices ...int) bool {
+	for _, idx := range indices {
+		if idx < r.Start || idx >= r.End {
+			return false
+		}
+	}
+	return true
+}
+
+// inRange checks if a candidate rewriting the given indices may be applied.
+// Without a Range every candidate may, unless ProtectRelocations rules out a relocated index.
+func (s *Section) inRange(indices ...int) bool {
+	if s.ProtectRelocations {
+		for _, idx := range indices {
+			if s.Relocations[idx] || s.CORERelocations[idx] {
+				return false
+			}
+		}
+	}
+	return s.Range == nil || s.Range.Contains(indices...)
+}
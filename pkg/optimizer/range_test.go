@@ -0,0 +1,93 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+func TestParseIndexRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    IndexRange
+		wantErr bool
+	}{
+		{value: "500:520", want: IndexRange{Start: 500, End: 520}},
+		{value: "0:1", want: IndexRange{Start: 0, End: 1}},
+		{value: "520:500", wantErr: true},
+		{value: "5:5", wantErr: true},
+		{value: "-1:5", wantErr: true},
+		{value: "500", wantErr: true},
+		{value: "a:b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseIndexRange(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseIndexRange(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && *got != tt.want {
+			t.Errorf("ParseIndexRange(%q) = %+v, want %+v", tt.value, *got, tt.want)
+		}
+	}
+}
+
+func TestApplyPassWithinRange(t *testing.T) {
+	section := createTestSection([]string{
+		"6701000020000000", // 0: r1 <<= 32
+		"7701000020000000", // 1: r1 >>= 32
+		"b700000000000000", // 2: r0 = 0
+		"6702000020000000", // 3: r2 <<= 32
+		"7702000020000000", // 4: r2 >>= 32
+		"9500000000000000", // 5: exit
+	})
+	section.Range = &IndexRange{Start: 0, End: 3}
+
+	section.ApplyPass(PassCompaction)
+
+	expected := []string{
+		"bc11000000000000",
+		bpf.NOP,
+		"b700000000000000",
+		"6702000020000000", // outside the range
+		"7702000020000000",
+		"9500000000000000",
+	}
+	for i, want := range expected {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+	}
+}
+
+func TestProtectRelocations(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000008000000", // r1 = 8, a CO-RE field offset
+		"7b1af8ff00000000", // *(u64 *)(r10 - 8) = r1
+		"b702000001000000", // r2 = 1
+		"7b2af0ff00000000", // *(u64 *)(r10 - 16) = r2
+		"9500000000000000", // exit
+	}, "")
+
+	for _, protect := range []bool{false, true} {
+		section, err := NewSection(hexData, "test", true)
+		if err != nil {
+			t.Fatalf("NewSection() error = %v", err)
+		}
+		section.CORERelocations = map[int]bool{0: true}
+		section.ProtectRelocations = protect
+
+		if err := section.ApplyPass(PassConstantPropagation); err != nil {
+			t.Fatalf("ApplyPass() error = %v", err)
+		}
+
+		if got := section.Instructions[0].IsNOP(); got == protect {
+			t.Errorf("ProtectRelocations = %v: relocated mov became NOP = %v", protect, got)
+		}
+		if !section.Instructions[2].IsNOP() {
+			t.Errorf("ProtectRelocations = %v: unrelocated mov was not propagated", protect)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package optimizer
 
 import (
-	"fmt"
 	"sort"
 
 	"github.com/beepfd/bpf-optimizer/pkg/bpf"
@@ -15,7 +14,7 @@ func (s *Section) BuildRegisterDependencies(cfg *ControlFlowGraph, nodeLen, base
 		}
 
 		inst := s.Instructions[instIdx]
-		if inst.Opcode == 0 { // skip NOPs
+		if inst.Opcode == 0 || inst.IsSyntheticNOP() { // skip NOPs, and fillers of an earlier run
 			continue
 		}
 
@@ -155,7 +154,7 @@ func (s *Section) ProcessUsedStack(instIdx int, analysis *InstructionAnalysis, i
 
 			// Debug: Log stack processing order
 			if instIdx >= 4810 && instIdx <= 4813 {
-				fmt.Printf("DEBUG: ProcessUsedStack - instIdx %d, stack offsets order: %v\n",
+				logger.Debugf("DEBUG: ProcessUsedStack - instIdx %d, stack offsets order: %v",
 					instIdx, stackOffsets)
 			}
 
@@ -202,5 +201,103 @@ func (s *Section) ProcessUsedStack(instIdx int, analysis *InstructionAnalysis, i
 			state.Stacks[offset] = []int{-1}
 			s.Dependencies[instIdx].Dependencies = append(s.Dependencies[instIdx].Dependencies, -1)
 		}
+
+		if offset != 0 {
+			s.addOverlappingStackDependencies(instIdx, offset, analysis.UsedStack[1], state)
+		}
+	}
+}
+
+// addOverlappingStackDependencies makes a stack load at offset depend on the stores at other
+// offsets that cover part of its bytes, e.g. a 4-byte load of r10-4 after an 8-byte store to r10-8.
+// Stacks is keyed by offset only, so the store size is taken from the storing instruction.
+func (s *Section) addOverlappingStackDependencies(instIdx int, offset, sizeBits int16, state *RegisterState) {
+	loadEnd := int(offset) + int(sizeBits)/8
+
+	stackOffsets := make([]int16, 0, len(state.Stacks))
+	for stackOffset := range state.Stacks {
+		if stackOffset != offset {
+			stackOffsets = append(stackOffsets, stackOffset)
+		}
+	}
+	sort.Slice(stackOffsets, func(i, j int) bool {
+		return stackOffsets[i] < stackOffsets[j]
+	})
+
+	for _, stackOffset := range stackOffsets {
+		for _, stackInstIdx := range state.Stacks[stackOffset] {
+			if stackInstIdx < 0 || stackInstIdx >= len(s.Instructions) {
+				continue
+			}
+
+			store := analyzeInstruction(s.Instructions[stackInstIdx]).UpdatedStack
+			if len(store) < 2 {
+				continue
+			}
+			storeEnd := int(stackOffset) + int(store[1])/8
+			if int(stackOffset) >= loadEnd || storeEnd <= int(offset) {
+				continue
+			}
+
+			if !s.FoundDependency(instIdx, stackInstIdx) {
+				s.Dependencies[instIdx].Dependencies = append(s.Dependencies[instIdx].Dependencies, stackInstIdx)
+			}
+			if !s.FoundDependedBy(stackInstIdx, instIdx) {
+				s.Dependencies[stackInstIdx].DependedBy = append(s.Dependencies[stackInstIdx].DependedBy, instIdx)
+			}
+		}
+	}
+}
+
+// UninitializedReads returns the sorted instructions reading a register that no path defines.
+// A register never defined has no reaching definition at all, so such a read ends up without any
+// dependency on it rather than with a -1: -1 only stands for the values seeded at a function entry
+// (r1 and r10, r1-r5 for a subprogram). r10 is always defined, and helper calls are skipped since
+// the registers they read are assumed from the helper id. The dependency graph is not rebuilt by
+// the passes, so this is meant for sections loaded with SkipOptimization.
+func (s *Section) UninitializedReads() []int {
+	reads := make([]int, 0)
+
+	for i, inst := range s.Instructions {
+		if i >= len(s.Dependencies) || inst.Opcode == 0 || inst.IsNOP() || inst.Opcode == bpf.BPF_JMP|bpf.JMP_CALL {
+			continue
+		}
+
+		used := analyzeInstruction(inst).UsedReg
+		// A compare against an immediate does not read src_reg, which the analysis still lists
+		if isConditionalJump(inst) && inst.Opcode&bpf.BPF_X == 0 {
+			used = []int{int(inst.DstReg)}
+		}
+
+		for _, reg := range used {
+			if reg != 10 && !s.isRegisterDefinedFor(i, reg) {
+				reads = append(reads, i)
+				break
+			}
+		}
+	}
+
+	return reads
+}
+
+// isRegisterDefinedFor checks if a dependency of the instruction at idx may define reg:
+// an instruction updating it, or the -1 of a function entry for the argument registers
+func (s *Section) isRegisterDefinedFor(idx, reg int) bool {
+	for _, dep := range s.Dependencies[idx].Dependencies {
+		if dep == -1 {
+			if reg <= 5 {
+				return true
+			}
+			continue
+		}
+		if dep < 0 || dep >= len(s.Instructions) {
+			continue
+		}
+
+		def := s.Instructions[dep]
+		if analyzeInstruction(def).UpdatedReg == reg || (def.Opcode == bpf.BPF_JMP|bpf.JMP_CALL && reg == 0) {
+			return true
+		}
 	}
+	return false
 }
@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
 )
 
 func TestSection_ProcessUsedRegisters(t *testing.T) {
@@ -336,3 +338,86 @@ func TestCalculateActualIndex(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildRegisterDependenciesPseudoCall(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b706000001000000", // 0: r6 = 1
+		"b702000002000000", // 1: r2 = 2
+		"8510000005000000", // 2: call pc+5 (BPF-to-BPF, imm collides with helper id 5)
+		"bf60000000000000", // 3: r0 = r6
+		"bf20000000000000", // 4: r0 = r2
+		"9500000000000000", // 5: exit
+		"b700000000000000", // 6: r0 = 0
+		"9500000000000000", // 7: exit
+		"b700000000000000", // 8: callee: r0 = 0
+		"9500000000000000", // 9: exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	// r6 is callee-saved, so its definition survives the call
+	if !section.FoundDependency(3, 0) {
+		t.Errorf("Expected instruction 3 to depend on 0 across the pseudo-call, got %v", section.Dependencies[3])
+	}
+
+	// r2 is caller-saved, so its definition is reset by the call
+	if section.FoundDependency(4, 1) {
+		t.Errorf("Expected instruction 4 not to depend on 1 across the pseudo-call, got %v", section.Dependencies[4])
+	}
+}
+
+func TestBuildRegisterDependenciesSelfMove(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b700000001000000", // 0: r0 = 1
+		"bf00000000000000", // 1: r0 = r0
+		"9500000000000000", // 2: exit
+	}, "")
+
+	// Under the default goto encoding an input r0 = r0 is an ordinary move
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	if !section.FoundDependency(1, 0) || !section.FoundDependency(2, 1) {
+		t.Errorf("Expected r0 = r0 to read 0 and feed the exit, got %v and %v", section.Dependencies[1], section.Dependencies[2])
+	}
+
+	// Under the mov encoding it is a filler and the exit reads r0 from 0
+	if err := bpf.SetNOPEncoding(bpf.NOPMov); err != nil {
+		t.Fatalf("SetNOPEncoding() error = %v", err)
+	}
+	defer bpf.SetNOPEncoding(bpf.NOP)
+
+	section, err = NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	if !section.FoundDependency(2, 0) || section.FoundDependency(2, 1) {
+		t.Errorf("Expected the exit to depend on 0 only, got %v", section.Dependencies[2])
+	}
+}
+
+func TestUninitializedReads(t *testing.T) {
+	hexData := strings.Join([]string{
+		"bf16000000000000", // 0: r6 = r1 (seeded at entry)
+		"bf37000000000000", // 1: r7 = r3 (never defined)
+		"b702000000000000", // 2: r2 = 0
+		"1502010000000000", // 3: if r2 == 0 goto +1
+		"b704000001000000", // 4: r4 = 1
+		"bf40000000000000", // 5: r0 = r4 (defined on one path only)
+		"0f80000000000000", // 6: r0 += r8 (never defined)
+		"9500000000000000", // 7: exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	if got, want := section.UninitializedReads(), []int{1, 6}; !equalIntSlice(got, want) {
+		t.Errorf("UninitializedReads() = %v, expected %v", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package optimizer
+
+// Pass names used when reporting and selecting optimizations
+const (
+	PassConstantPropagation = "const-prop"
+	PassCompaction          = "compaction"
+	PassPeephole            = "peephole"
+	PassLoadImm64Dedup      = "lddw-dedup"
+	PassBranchFolding       = "branch-fold"
+	PassSuperword           = "superword"
+	PassSelfMove            = "self-move"
+	PassRedundantDef        = "dead-def"
+	PassMapLoadDedup        = "map-dedup"
+	PassDeadCode            = "dead-code"
+	PassTailMerge           = "tail-merge"
+)
+
+// Opportunity is a group of instruction indices that a pass would rewrite
+type Opportunity struct {
+	Pass    string
+	Indices []int
+}
+
+// FindOpportunities runs the candidate finding of every pass without mutating the section.
+// The section should be built with skipOptimization, otherwise the candidates were already applied.
+func (s *Section) FindOpportunities() []Opportunity {
+	opportunities := make([]Opportunity, 0)
+
+	candidates, storeCandidates := s.findConstantPropagationCandidates()
+	for _, candIdx := range candidates {
+		indices := append([]int{candIdx}, s.Dependencies[candIdx].DependedBy...)
+		opportunities = append(opportunities, Opportunity{Pass: PassConstantPropagation, Indices: indices})
+	}
+
+	for _, candIdx := range s.findCompactionCandidates() {
+		opportunities = append(opportunities, Opportunity{Pass: PassCompaction, Indices: []int{candIdx, candIdx + 1}})
+	}
+
+	for _, candidate := range findCandidates(s, findMaskCandidates(s.Instructions)) {
+		opportunities = append(opportunities, Opportunity{Pass: PassPeephole, Indices: candidate})
+	}
+
+	for _, candIdx := range s.findAllOnesMaskCandidates() {
+		opportunities = append(opportunities, Opportunity{Pass: PassPeephole, Indices: []int{candIdx}})
+	}
+
+	for _, candidate := range s.findZeroExtensionCandidates() {
+		opportunities = append(opportunities, Opportunity{Pass: PassPeephole, Indices: candidate})
+	}
+
+	for _, candIdx := range s.findNoOpJumpCandidates() {
+		opportunities = append(opportunities, Opportunity{Pass: PassPeephole, Indices: []int{candIdx}})
+	}
+
+	for _, candidate := range s.findRedundantLoadImm64Candidates() {
+		opportunities = append(opportunities, Opportunity{Pass: PassLoadImm64Dedup, Indices: []int{candidate.Index, candidate.Index + 1}})
+	}
+
+	for _, candidate := range s.findRedundantMapLoadCandidates() {
+		opportunities = append(opportunities, Opportunity{Pass: PassMapLoadDedup, Indices: []int{candidate.Index, candidate.Index + 1}})
+	}
+
+	for _, candidate := range s.findBranchFoldingCandidates() {
+		opportunities = append(opportunities, Opportunity{Pass: PassBranchFolding, Indices: []int{candidate.Index}})
+	}
+
+	merger := NewSuperwordMerger(s)
+	for _, candidate := range merger.findMergeCandidates(storeCandidates) {
+		opportunities = append(opportunities, Opportunity{Pass: PassSuperword, Indices: candidate})
+	}
+
+	for _, candidate := range s.findDeadCodeCandidates() {
+		opportunities = append(opportunities, Opportunity{Pass: PassDeadCode, Indices: candidate})
+	}
+
+	for _, candIdx := range s.findRedundantDefCandidates() {
+		opportunities = append(opportunities, Opportunity{Pass: PassRedundantDef, Indices: []int{candIdx}})
+	}
+
+	for _, candidate := range s.findTailMergeCandidates() {
+		opportunities = append(opportunities, Opportunity{Pass: PassTailMerge, Indices: candidate.From})
+	}
+
+	for _, candIdx := range s.findSelfMoveCandidates() {
+		opportunities = append(opportunities, Opportunity{Pass: PassSelfMove, Indices: []int{candIdx}})
+	}
+
+	return opportunities
+}
@@ -0,0 +1,29 @@
+package optimizer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindOpportunitiesCompaction(t *testing.T) {
+	instructions := []string{
+		"6701000020000000", // lsh r1, 32
+		"7701000020000000", // rsh r1, 32
+		"9500000000000000", // exit
+	}
+	section := createTestSection(instructions)
+
+	got := section.FindOpportunities()
+	want := []Opportunity{
+		{Pass: PassCompaction, Indices: []int{0, 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindOpportunities() = %v, want %v", got, want)
+	}
+
+	for i, hexStr := range instructions {
+		if section.Instructions[i].Raw != hexStr {
+			t.Errorf("Instruction %d modified: expected %s, got %s", i, hexStr, section.Instructions[i].Raw)
+		}
+	}
+}
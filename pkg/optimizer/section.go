@@ -1,9 +1,10 @@
 package optimizer
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
 	"sort"
-	"strconv"
 	"strings"
 
 	"github.com/beepfd/bpf-optimizer/pkg/bpf"
@@ -15,6 +16,32 @@ type Section struct {
 	Instructions     []*bpf.Instruction
 	Dependencies     []DependencyInfo // dependency information for each instruction
 	ControlFlowGraph *ControlFlowGraph
+	EntryPoints      []int             // first instruction of each function in the section
+	FunctionStarts   []int             // first instruction of each function symbol, nil when the symbols are unknown
+	StoreCandidates  []int             // immediate stores considered by superword merge
+	Offset           uint64            // byte offset of the first instruction within its ELF section
+	Size             uint64            // bytes covered within its ELF section, 0 for the rest of the section
+	Trace            []TraceEntry      // passes applied by applyOptimizations, in order
+	Relocations      map[int]bool      // instructions patched by an ELF relocation (map fds, globals)
+	CORERelocations  map[int]bool      // instructions patched by a CO-RE relocation (field offsets, existence checks)
+	Stats            OptimizationStats // instruction and branch counts around applyOptimizations
+	Trailing         []byte            // bytes after the last whole instruction, kept verbatim
+	Range            *IndexRange       // only candidates entirely inside are applied, nil for all
+	InputNOPs        map[int]bool      // instructions that were already no-ops in the input
+	Diagnostics      []Diagnostic      // problems met while building the section that did not abort it
+	Origins          map[int][]int     // input instructions a merged or rewritten instruction was built from
+	MapReferences    map[int]string    // relocated lddw loading a map, with the map name
+	MovedRelocations map[int]int       // map load rewritten by map-dedup -> the load now carrying its relocation
+	Candidates       PassCandidates    // candidates each pass applied or blocked by a safety guard
+
+	ProtectRelocations  bool // skip every candidate rewriting an instruction in Relocations or CORERelocations
+	MergeAcrossNopJumps bool // let superword merge stores separated only by a `goto +0` no branch lands on
+	AggressiveMerge     bool // let superword merge stack stores leaving a gap, zeroing gap bytes proven dead or already zero
+	AggressiveFold      bool // let branch folding drop a conditional jump dominating a load or store through a pointer
+
+	snapshot   *sectionSnapshot // state restored by Reset
+	ctx        context.Context  // stops the dependency analysis once done, set during OptimizeContext
+	activePass string           // pass ApplyPass is running, whose candidates are counted
 }
 
 // DependencyInfo tracks dependencies for an instruction
@@ -54,35 +81,111 @@ func removeDuplicateInts(slice []int) []int {
 	return result
 }
 
+// SectionOptions controls how a section is built from its hex data
+type SectionOptions struct {
+	SkipOptimization bool     // only build the dependency graph, leave instructions untouched
+	KeepTrailingData bool     // keep bytes after the last whole instruction instead of failing
+	CacheDir         string   // load and store the dependency analysis here, empty to always compute it
+	PassOrder        []string // passes to apply in order, empty for defaultPassOrder
+	FunctionStarts   []int    // first instruction of each function symbol, seeds the entry points of the analysis
+
+	FixpointIterations int // rerun the passes until a round changes nothing, at most this many rounds, see applyPasses
+}
+
 // NewSection creates a new section from hex data
 func NewSection(hexData, name string, skipOptimization bool) (*Section, error) {
-	if len(hexData)%16 != 0 {
-		return nil, fmt.Errorf("bytecode section length must be a multiple of 16")
+	return NewSectionWithOptions(hexData, name, SectionOptions{SkipOptimization: skipOptimization})
+}
+
+// NewSectionWithOptions creates a new section from hex data using the given options.
+// With KeepTrailingData, bytes that do not form a whole instruction (e.g. an appended
+// jump table) are excluded from analysis and written back unchanged by ToBytes.
+func NewSectionWithOptions(hexData, name string, opts SectionOptions) (*Section, error) {
+	if err := ValidatePassOrder(opts.PassOrder); err != nil {
+		return nil, err
 	}
 
 	section := &Section{
-		Name:         name,
-		Instructions: make([]*bpf.Instruction, 0),
-		Dependencies: make([]DependencyInfo, 0),
+		Name:           name,
+		Instructions:   make([]*bpf.Instruction, 0),
+		Dependencies:   make([]DependencyInfo, 0),
+		InputNOPs:      make(map[int]bool),
+		FunctionStarts: opts.FunctionStarts,
+	}
+
+	if len(hexData)%16 != 0 {
+		if !opts.KeepTrailingData {
+			return nil, &ErrSectionLengthNotMultiple{Length: len(hexData)}
+		}
+
+		end := len(hexData) - len(hexData)%16
+		trailing, err := hex.DecodeString(hexData[end:])
+		if err != nil {
+			return nil, &ErrSectionLengthNotMultiple{Length: len(hexData)}
+		}
+		section.Trailing = trailing
+		hexData = hexData[:end]
 	}
 
-	// Parse instructions (16 hex chars each)
-	for i := 0; i < len(hexData); i += 16 {
-		inst, err := bpf.NewInstruction(hexData[i : i+16])
+	parseSlot := func(idx int) (*bpf.Instruction, error) {
+		raw := hexData[idx*2*bpf.InstructionSize : (idx+1)*2*bpf.InstructionSize]
+		inst, err := bpf.NewInstruction(raw)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse instruction at %d: %v", i/16, err)
+			return nil, &ErrMalformedInstruction{Index: idx, Raw: raw, Err: err}
+		}
+		if !isSupportedOpcode(inst.Opcode) {
+			return nil, &ErrUnsupportedOpcode{Opcode: inst.Opcode, Index: idx}
+		}
+		if inst.IsNOP() {
+			section.InputNOPs[idx] = true
 		}
 		section.Instructions = append(section.Instructions, inst)
 		section.Dependencies = append(section.Dependencies, DependencyInfo{
 			Dependencies: make([]int, 0),
 			DependedBy:   make([]int, 0),
 		})
+		return inst, nil
+	}
+
+	// Parse instructions by byte offset, each as wide as its first slot says. Every slot
+	// still gets its own entry in Instructions, the passes index them by slot.
+	size := len(hexData) / 2
+	for offset := 0; offset < size; {
+		first, err := parseSlot(offset / bpf.InstructionSize)
+		if err != nil {
+			return nil, err
+		}
+
+		// A wide instruction cut off by the end of the section is left to Verify
+		end := min(offset+first.Width(), size)
+		for next := offset + bpf.InstructionSize; next < end; next += bpf.InstructionSize {
+			if _, err := parseSlot(next / bpf.InstructionSize); err != nil {
+				return nil, err
+			}
+		}
+		offset = end
 	}
 
-	// Build dependency graph and apply optimizations
-	section.buildDependencies()
-	if !skipOptimization {
-		section.applyOptimizations()
+	// Reject invalid programs before analysis builds on them
+	if err := section.Verify(); err != nil {
+		return nil, err
+	}
+
+	// Build dependency graph, or load it from the cache, and apply optimizations
+	if opts.CacheDir == "" {
+		section.buildDependencies()
+	} else if hash := sectionHash(hexData); !section.loadDependencyCache(opts.CacheDir, hash) {
+		section.buildDependencies()
+		if err := section.saveDependencyCache(opts.CacheDir, hash); err != nil {
+			section.Diagnostics = append(section.Diagnostics, Diagnostic{
+				Section:  name,
+				Message:  fmt.Sprintf("failed to cache dependencies: %v", err),
+				Severity: SeverityWarning,
+			})
+		}
+	}
+	if !opts.SkipOptimization {
+		section.applyOptimizations(opts.PassOrder, opts.FixpointIterations)
 	}
 
 	return section, nil
@@ -94,6 +197,7 @@ func (s *Section) buildDependencies() {
 	// Build control flow graph
 	cfg := s.buildControlFlowGraph()
 	s.ControlFlowGraph = cfg
+	s.EntryPoints = s.findEntryPoints(cfg)
 
 	// Initialize register state
 	initialState := NewRegisterState()
@@ -112,33 +216,30 @@ func (s *Section) buildDependencies() {
 		deps4813 := s.Dependencies[4813].Deduplication()
 
 		isCorrect := len(deps4810.Dependencies) == 0 && len(deps4810.DependedBy) == 0
-		fmt.Printf("DEBUG: Dependency analysis results:\n")
-		fmt.Printf("  4810 - Correct: %v, Deps: %v, DependedBy: %v\n",
+		logger.Debugf("DEBUG: Dependency analysis results:")
+		logger.Debugf("  4810 - Correct: %v, Deps: %v, DependedBy: %v",
 			isCorrect, deps4810.Dependencies, deps4810.DependedBy)
-		fmt.Printf("  4811 - Deps: %v, DependedBy: %v\n",
+		logger.Debugf("  4811 - Deps: %v, DependedBy: %v",
 			deps4811.Dependencies, deps4811.DependedBy)
-		fmt.Printf("  4812 - Deps: %v, DependedBy: %v\n",
+		logger.Debugf("  4812 - Deps: %v, DependedBy: %v",
 			deps4812.Dependencies, deps4812.DependedBy)
-		fmt.Printf("  4813 - Deps: %v, DependedBy: %v\n",
+		logger.Debugf("  4813 - Deps: %v, DependedBy: %v",
 			deps4813.Dependencies, deps4813.DependedBy)
 	}
 }
 
-// applyOptimizations applies all optimization techniques
-func (s *Section) applyOptimizations() {
+// applyOptimizations applies the passes in order, or defaultPassOrder when order is empty
+func (s *Section) applyOptimizations(order []string, rounds int) {
 	if s.Name == "uprobe" && len(s.Instructions) > 4810 {
-		fmt.Printf("DEBUG: Before optimization - 4810: %s, 4811: %s, 4812: %s, 4813: %s\n",
+		logger.Debugf("DEBUG: Before optimization - 4810: %s, 4811: %s, 4812: %s, 4813: %s",
 			s.Instructions[4810].Raw, s.Instructions[4811].Raw,
 			s.Instructions[4812].Raw, s.Instructions[4813].Raw)
 	}
 
-	s.applyConstantPropagation()
-	s.applyCompaction()
-	s.applyPeepholeOptimization()
-	//s.applySuperwordMerge(storeCandidates)
+	s.applyPasses(context.Background(), order, rounds)
 
 	if s.Name == "uprobe" && len(s.Instructions) > 4810 {
-		fmt.Printf("DEBUG: After optimization - 4810: %s, 4811: %s, 4812: %s, 4813: %s\n",
+		logger.Debugf("DEBUG: After optimization - 4810: %s, 4811: %s, 4812: %s, 4813: %s",
 			s.Instructions[4810].Raw, s.Instructions[4811].Raw,
 			s.Instructions[4812].Raw, s.Instructions[4813].Raw)
 
@@ -153,7 +254,7 @@ func (s *Section) applyOptimizations() {
 			s.Instructions[4812].Raw == expected4812 &&
 			s.Instructions[4813].Raw == expected4813
 
-		fmt.Printf("DEBUG: Optimization success: %v\n", optimizationSuccess)
+		logger.Debugf("DEBUG: Optimization success: %v", optimizationSuccess)
 	}
 }
 
@@ -164,54 +265,128 @@ func isMemoryOperation(inst *bpf.Instruction) bool {
 		class == bpf.BPF_ST || class == bpf.BPF_STX
 }
 
-// canMergeMemoryOps checks if two memory operations can be merged
-func canMergeMemoryOps(inst1, inst2 *bpf.Instruction) bool {
-	// Simplified check - full implementation would need address analysis
-	return inst1.DstReg == inst2.DstReg &&
-		inst1.SrcReg == inst2.SrcReg &&
-		inst2.Offset == inst1.Offset+getMemorySize(inst1)
+// ToBytes encodes the instructions directly from their decoded fields, followed by the trailing bytes
+func (s *Section) ToBytes() ([]byte, error) {
+	data := make([]byte, 0, len(s.Instructions)*bpf.InstructionSize)
+
+	var err error
+	for i, inst := range s.Instructions {
+		data, err = inst.AppendBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode instruction at %d: %v", i, err)
+		}
+	}
+
+	return append(data, s.Trailing...), nil
+}
+
+// Dump converts the section back to bytes, ignoring encoding errors.
+// Use ToBytes when the error matters.
+func (s *Section) Dump() []byte {
+	data, _ := s.ToBytes()
+	return data
 }
 
-// createMergedMemoryOp creates a merged memory operation
-func createMergedMemoryOp(inst1, inst2 *bpf.Instruction) *bpf.Instruction {
-	// Simplified implementation
-	return inst1.Clone()
+// ResultInstruction is one slot of the section as the passes left it
+type ResultInstruction struct {
+	Index       int    // slot in Instructions
+	Hex         string // encoding, 16 hex chars
+	IsNOP       bool   // a no-op, whether from the input or written by a pass
+	IsSynthetic bool   // a no-op written by a pass in place of a removed instruction
+	Origins     []int  // input instructions a rewritten instruction was built from, nil if it was not rewritten
 }
 
-// getMemorySize returns the memory size for an instruction
-func getMemorySize(inst *bpf.Instruction) int16 {
-	sizeMask := inst.Opcode & 0x18
-	switch sizeMask {
-	case bpf.SIZE_B:
-		return 1
-	case bpf.SIZE_H:
-		return 2
-	case bpf.SIZE_W:
-		return 4
-	case bpf.SIZE_DW:
-		return 8
-	default:
-		return 1
+// Result returns the instruction stream after optimization, one entry per slot in order,
+// telling the live instructions from the filler left by the passes
+func (s *Section) Result() []ResultInstruction {
+	result := make([]ResultInstruction, len(s.Instructions))
+	for i, inst := range s.Instructions {
+		nop := inst.IsNOP()
+		result[i] = ResultInstruction{
+			Index:       i,
+			Hex:         inst.Raw,
+			IsNOP:       nop,
+			IsSynthetic: nop && !s.InputNOPs[i],
+			Origins:     append([]int(nil), s.Origins[i]...),
+		}
 	}
+	return result
 }
 
-// Dump converts the section back to hex string
-func (s *Section) Dump() []byte {
-	var result strings.Builder
+// sectionStringEdge is how many instructions String shows at each end of a long section
+const sectionStringEdge = 3
+
+// String summarizes the section for debugging: name, instruction and NOP counts,
+// and the first and last few instructions
+func (s *Section) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "section %s: %d instructions, %d NOPs", s.Name, len(s.Instructions), len(s.NOPIndices()))
+
+	truncated := len(s.Instructions) > 2*sectionStringEdge
+	for i, inst := range s.Instructions {
+		if truncated && i >= sectionStringEdge && i < len(s.Instructions)-sectionStringEdge {
+			if i == sectionStringEdge {
+				b.WriteString("\n  ...")
+			}
+			continue
+		}
+		fmt.Fprintf(&b, "\n  %d: %s", i, inst)
+	}
+
+	return b.String()
+}
 
-	for _, inst := range s.Instructions {
-		result.WriteString(inst.ToHex())
+// InstructionAt returns the instruction at idx, counting from the end for a negative idx
+// like Python and calculateActualIndex (-1 is the last instruction)
+func (s *Section) InstructionAt(idx int) (*bpf.Instruction, bool) {
+	actual := calculateActualIndex(idx, len(s.Instructions))
+	if actual < 0 || actual >= len(s.Instructions) {
+		return nil, false
+	}
+	return s.Instructions[actual], true
+}
+
+// ReplaceInstruction puts inst at idx. With recomputeDeps the dependency graph is rebuilt so the
+// edges reflect the operands of inst; this covers the whole section since a new definition can
+// reach uses far from idx. Without it the edges are left as they were, for a replacement reading
+// and writing the same registers and stack slots.
+func (s *Section) ReplaceInstruction(idx int, inst *bpf.Instruction, recomputeDeps bool) {
+	s.Instructions[idx] = inst
+	if !recomputeDeps {
+		return
 	}
 
-	hexStr := result.String()
-	data := make([]byte, len(hexStr)/2)
+	s.resetDependencies()
+	s.buildDependencies()
+}
 
-	for i := 0; i < len(hexStr); i += 2 {
-		b, _ := strconv.ParseUint(hexStr[i:i+2], 16, 8)
-		data[i/2] = byte(b)
+// resetDependencies clears every edge before the dependency graph is built again
+func (s *Section) resetDependencies() {
+	for i := range s.Dependencies {
+		s.Dependencies[i] = DependencyInfo{
+			Dependencies: make([]int, 0),
+			DependedBy:   make([]int, 0),
+		}
 	}
+}
 
-	return data
+// recordOrigins notes that the instruction at idx was built from the instructions at sources.
+// Sources already rewritten by an earlier pass contribute their own origins instead.
+func (s *Section) recordOrigins(idx int, sources ...int) {
+	origins := make([]int, 0, len(sources))
+	for _, src := range sources {
+		if srcOrigins, ok := s.Origins[src]; ok {
+			origins = append(origins, srcOrigins...)
+		} else {
+			origins = append(origins, src)
+		}
+	}
+	origins = removeDuplicateInts(origins)
+
+	if s.Origins == nil {
+		s.Origins = make(map[int][]int)
+	}
+	s.Origins[idx] = origins
 }
 
 func (s *Section) FoundDependency(instIdx int, depInstIdx int) bool {
@@ -0,0 +1,47 @@
+package optimizer
+
+import (
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+func FuzzOptimizeSection(f *testing.F) {
+	for _, seed := range []string{
+		strings.Join([]string{"b701000000000000", "731af8ff00000000", "731af9ff00000000", "b700000000000000", "9500000000000000"}, ""),
+		strings.Join([]string{"61a1f8ff00000000", "6701000020000000", "7701000020000000", "7b1af0ff00000000", "9500000000000000"}, ""),
+		strings.Join([]string{"1801000001000000", "0000000002000000", "1802000001000000", "0000000002000000", "9500000000000000"}, ""),
+	} {
+		data, _ := hex.DecodeString(seed)
+		f.Add(data)
+	}
+	for _, path := range []string{"../../testdata/section_data", "../../testdata/section_data_uprobe_raw"} {
+		if hexData, err := os.ReadFile(path); err == nil {
+			data, _ := hex.DecodeString(strings.TrimSpace(string(hexData)))
+			f.Add(data)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		data = data[:len(data)/bpf.InstructionSize*bpf.InstructionSize]
+
+		section, err := NewSection(hex.EncodeToString(data), "fuzz", false)
+		if err != nil {
+			return
+		}
+
+		if err := section.Verify(); err != nil {
+			t.Errorf("Verify() after optimization error = %v", err)
+		}
+		out, err := section.ToBytes()
+		if err != nil {
+			t.Fatalf("ToBytes() error = %v", err)
+		}
+		if len(out) != len(data) {
+			t.Errorf("optimization changed the size from %d to %d bytes", len(data), len(out))
+		}
+	})
+}
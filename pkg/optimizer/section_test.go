@@ -1,10 +1,15 @@
 package optimizer
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
 	"github.com/beepfd/bpf-optimizer/tool"
 )
 
@@ -118,6 +123,7 @@ func TestNewSection(t *testing.T) {
 		hexDataFile       string
 		name              string
 		optimizedDataFile string
+		defaultDataFile   string
 		depsFile          string
 	}
 	tests := []struct {
@@ -131,6 +137,7 @@ func TestNewSection(t *testing.T) {
 			args: args{
 				hexDataFile:       "../../testdata/section_data",
 				optimizedDataFile: "../../testdata/section_data_optimized",
+				defaultDataFile:   "../../testdata/section_data_default_optimized",
 				name:              ".text",
 				depsFile:          "../../testdata/section_data_text_deps",
 			},
@@ -140,6 +147,7 @@ func TestNewSection(t *testing.T) {
 			args: args{
 				hexDataFile:       "../../testdata/section_data_uprobe_raw",
 				optimizedDataFile: "../../testdata/section_data_uprobe_optimized",
+				defaultDataFile:   "../../testdata/section_data_uprobe_default_optimized",
 				depsFile:          "../../testdata/section_data_uprobe_deps",
 				name:              "uprobe",
 			},
@@ -165,7 +173,10 @@ func TestNewSection(t *testing.T) {
 				return
 			}
 
-			got, err := NewSection(string(hexData), tt.args.name, false)
+			// The optimized fixtures are Merlin's output, which runs no superword merge or later pass
+			got, err := NewSectionWithOptions(string(hexData), tt.args.name, SectionOptions{
+				PassOrder: []string{PassConstantPropagation, PassCompaction, PassPeephole},
+			})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewSection() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -213,6 +224,32 @@ func TestNewSection(t *testing.T) {
 				}
 			}
 
+			// The default pipeline, superword merge included, against its own golden output
+			defaultData, err := os.ReadFile(tt.args.defaultDataFile)
+			if err != nil {
+				t.Errorf("NewSection() error = %v", err)
+				return
+			}
+			defaultInstRaws, err := tool.ParsePythonSliceInt(string(defaultData))
+			if err != nil {
+				t.Errorf("ParsePythonSliceInt() error = %v", err)
+				return
+			}
+
+			got, err = NewSection(string(hexData), tt.args.name, false)
+			if err != nil {
+				t.Errorf("NewSection() error = %v", err)
+				return
+			}
+			if len(got.Instructions) != len(defaultInstRaws) {
+				t.Fatalf("Test %s: got %d instructions, expected %d", tt.name, len(got.Instructions), len(defaultInstRaws))
+			}
+			for i, instRaw := range defaultInstRaws {
+				if got.Instructions[i].Raw != instRaw {
+					t.Errorf("Test %s: default pipeline mismatch, ins index %d, got: %s, expected: %s",
+						tt.name, i, got.Instructions[i].Raw, instRaw)
+				}
+			}
 		})
 	}
 }
@@ -360,3 +397,369 @@ func TestSection2(t *testing.T) {
 	}
 
 }
+
+func TestSectionToBytesRoundTrip(t *testing.T) {
+	hexData, err := os.ReadFile("../../testdata/section_data")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	want, err := hex.DecodeString(string(hexData))
+	if err != nil {
+		t.Fatalf("DecodeString() error = %v", err)
+	}
+
+	section, err := NewSection(string(hexData), ".text", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	got, err := section.ToBytes()
+	if err != nil {
+		t.Fatalf("ToBytes() error = %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("ToBytes() does not round trip the original %d bytes, got %d bytes", len(want), len(got))
+	}
+
+	if !bytes.Equal(section.Dump(), want) {
+		t.Errorf("Dump() does not match ToBytes()")
+	}
+
+	section.Instructions[0].DstReg = 16
+	if _, err := section.ToBytes(); err == nil {
+		t.Errorf("ToBytes() expected error for out of range register")
+	}
+}
+
+func TestLogicalInstructionsWideLoad(t *testing.T) {
+	// r1 = 0x1122334455667788 ll; r0 = 0; exit
+	hexData := "1801000088776655" + "0000000044332211" + "b700000000000000" + "9500000000000000"
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	insts := section.LogicalInstructions()
+	if len(insts) != 3 {
+		t.Fatalf("got %d logical instructions, want 3", len(insts))
+	}
+	if lddw := insts[0]; lddw.Index != 0 || lddw.Offset != 0 || lddw.Width() != 16 || len(lddw.Slots) != 2 {
+		t.Errorf("lddw = %+v, want both slots at offset 0", lddw)
+	}
+	if mov := insts[1]; mov.Index != 2 || mov.Offset != 16 || mov.Width() != 8 {
+		t.Errorf("mov = %+v, want slot 2 at offset 16", mov)
+	}
+
+	want, _ := hex.DecodeString(hexData)
+	got, err := insts[0].Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	if !bytes.Equal(got, want[:16]) {
+		t.Errorf("Bytes() = %x, want %x", got, want[:16])
+	}
+	if !bytes.Equal(section.Dump(), want) {
+		t.Errorf("Dump() = %x, want %x", section.Dump(), want)
+	}
+}
+
+func TestSectionResultCompaction(t *testing.T) {
+	section, err := NewSection("6701000020000000"+ // lsh r1, 32
+		"7701000020000000"+ // rsh r1, 32
+		"0500000000000000"+ // goto +0, already in the input
+		"9500000000000000", // exit
+		"test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	section.ApplyPass(PassCompaction)
+
+	want := []ResultInstruction{
+		{Index: 0, Hex: "bc11000000000000", Origins: []int{0, 1}}, // w1 = w1
+		{Index: 1, Hex: bpf.NOP, IsNOP: true, IsSynthetic: true},
+		{Index: 2, Hex: "0500000000000000", IsNOP: true},
+		{Index: 3, Hex: "9500000000000000"},
+	}
+	if got := section.Result(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Result() = %+v, want %+v", got, want)
+	}
+}
+
+func TestNewSectionErrorTypes(t *testing.T) {
+	t.Run("odd length", func(t *testing.T) {
+		_, err := NewSection("b70000000000000095", "test", true)
+		var lengthErr *ErrSectionLengthNotMultiple
+		if !errors.As(err, &lengthErr) {
+			t.Fatalf("NewSection() error = %v, want ErrSectionLengthNotMultiple", err)
+		}
+		if lengthErr.Length != 18 {
+			t.Errorf("ErrSectionLengthNotMultiple.Length = %d, want 18", lengthErr.Length)
+		}
+	})
+
+	t.Run("malformed instruction", func(t *testing.T) {
+		_, err := NewSection("b700000000000000zz00000000000000", "test", true)
+		var malformedErr *ErrMalformedInstruction
+		if !errors.As(err, &malformedErr) {
+			t.Fatalf("NewSection() error = %v, want ErrMalformedInstruction", err)
+		}
+		if malformedErr.Index != 1 || malformedErr.Raw != "zz00000000000000" {
+			t.Errorf("ErrMalformedInstruction = {%d %s}, want {1 zz00000000000000}", malformedErr.Index, malformedErr.Raw)
+		}
+	})
+
+	t.Run("unsupported opcode", func(t *testing.T) {
+		_, err := NewSection("b700000000000000f700000000000000", "test", true)
+		var opcodeErr *ErrUnsupportedOpcode
+		if !errors.As(err, &opcodeErr) {
+			t.Fatalf("NewSection() error = %v, want ErrUnsupportedOpcode", err)
+		}
+		if opcodeErr.Opcode != 0xf7 || opcodeErr.Index != 1 {
+			t.Errorf("ErrUnsupportedOpcode = {0x%02x %d}, want {0xf7 1}", opcodeErr.Opcode, opcodeErr.Index)
+		}
+	})
+
+	t.Run("write to r10", func(t *testing.T) {
+		_, err := NewSection("b701000000000000bf1a0000000000009500000000000000", "test", true)
+		var r10Err *ErrReadOnlyRegisterWrite
+		if !errors.As(err, &r10Err) {
+			t.Fatalf("NewSection() error = %v, want ErrReadOnlyRegisterWrite", err)
+		}
+		if r10Err.Index != 1 || r10Err.Raw != "bf1a000000000000" {
+			t.Errorf("ErrReadOnlyRegisterWrite = {%d %s}, want {1 bf1a000000000000}", r10Err.Index, r10Err.Raw)
+		}
+	})
+}
+
+func TestNewSectionMergesStoreRun(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000000000000", // r1 = 0
+		"731af8ff00000000", // *(u8 *)(r10 - 8) = r1
+		"731af9ff00000000", // *(u8 *)(r10 - 7) = r1
+		"731afaff00000000", // *(u8 *)(r10 - 6) = r1
+		"731afbff00000000", // *(u8 *)(r10 - 5) = r1
+		"b700000000000000", // r0 = 0
+		"9500000000000000", // exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", false)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	// Constant propagation turns the stores into immediates, which superword merge folds into one u32 store
+	expected := []string{
+		bpf.NOP,
+		"620af8ff00000000",
+		bpf.NOP,
+		bpf.NOP,
+		bpf.NOP,
+		"b700000000000000",
+		"9500000000000000",
+	}
+	for i, want := range expected {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+	}
+}
+
+func TestNewSectionNegativeImmediate(t *testing.T) {
+	tests := []struct {
+		name     string
+		mov      string
+		expected string
+	}{
+		{"mov64", "b7010000ffffffff", "7a0af8ffffffffff"},
+		// w1 = -1 leaves 0x00000000ffffffff in r1, which a stdw imm cannot encode
+		{"mov32", "b4010000ffffffff", "7b1af8ff00000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hexData := strings.Join([]string{
+				tt.mov,             // r1 = -1
+				"7b1af8ff00000000", // *(u64 *)(r10 - 8) = r1
+				"b700000000000000", // r0 = 0
+				"9500000000000000", // exit
+			}, "")
+
+			section, err := NewSection(hexData, "test", false)
+			if err != nil {
+				t.Fatalf("NewSection() error = %v", err)
+			}
+
+			if got := section.Instructions[1].Raw; got != tt.expected {
+				t.Errorf("store = %s, expected %s", got, tt.expected)
+			}
+			if err := section.Verify(); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCompactedMovKeepsSourceDependency(t *testing.T) {
+	hexData := strings.Join([]string{
+		"61a1f8ff00000000", // r1 = *(u32 *)(r10 - 8)
+		"6701000020000000", // r1 <<= 32
+		"7701000020000000", // r1 >>= 32
+		"7b1af0ff00000000", // *(u64 *)(r10 - 16) = r1
+		"b700000000000000", // r0 = 0
+		"9500000000000000", // exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	if err := section.ApplyPass(PassCompaction); err != nil {
+		t.Fatalf("ApplyPass() error = %v", err)
+	}
+	if got := section.Instructions[1].Raw; got != "bc11000000000000" {
+		t.Fatalf("instruction 1 = %s, expected w1 = w1", got)
+	}
+
+	// Rebuild the graph over the compacted code: w1 = w1 reads r1 and defines it for the store
+	compacted, err := NewSection(hex.EncodeToString(section.Dump()), "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	if !compacted.FoundDependency(1, 0) {
+		t.Errorf("expected w1 = w1 to depend on the load at 0, got %v", compacted.Dependencies[1])
+	}
+	if !compacted.FoundDependency(3, 1) {
+		t.Errorf("expected the store to depend on w1 = w1, got %v", compacted.Dependencies[3])
+	}
+}
+
+func TestNewSectionKeepTrailingData(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000000000000", // r1 = 0
+		"631afcff00000000", // *(u32 *)(r10 - 4) = r1
+		"9500000000000000", // exit
+		"deadbeef",         // not an instruction
+	}, "")
+
+	var lengthErr *ErrSectionLengthNotMultiple
+	if _, err := NewSection(hexData, "test", false); !errors.As(err, &lengthErr) {
+		t.Fatalf("NewSection() error = %v, want *ErrSectionLengthNotMultiple", err)
+	}
+
+	section, err := NewSectionWithOptions(hexData, "test", SectionOptions{KeepTrailingData: true})
+	if err != nil {
+		t.Fatalf("NewSectionWithOptions() error = %v", err)
+	}
+	if len(section.Instructions) != 3 {
+		t.Fatalf("got %d instructions, want 3", len(section.Instructions))
+	}
+
+	expected := bpf.NOP + "620afcff00000000" + "9500000000000000" + "deadbeef"
+	if got := hex.EncodeToString(section.Dump()); got != expected {
+		t.Errorf("Dump() = %s, expected %s", got, expected)
+	}
+}
+
+func TestInstructionAt(t *testing.T) {
+	section := createTestSection([]string{
+		"b701000001000000", // r1 = 1
+		"b702000002000000", // r2 = 2
+		"bf10000000000000", // r0 = r1
+		"9500000000000000", // exit
+	})
+
+	tests := []struct {
+		idx  int
+		want string // empty when out of range
+	}{
+		{idx: 0, want: "b701000001000000"},
+		{idx: 3, want: "9500000000000000"},
+		{idx: -1, want: "9500000000000000"},
+		{idx: -3, want: "b702000002000000"},
+		{idx: -4, want: "b701000001000000"},
+		{idx: 4},
+		{idx: -5},
+	}
+
+	for _, tt := range tests {
+		inst, ok := section.InstructionAt(tt.idx)
+		if ok != (tt.want != "") {
+			t.Errorf("InstructionAt(%d) ok = %v, expected %v", tt.idx, ok, tt.want != "")
+			continue
+		}
+		if ok && inst.Raw != tt.want {
+			t.Errorf("InstructionAt(%d) = %s, expected %s", tt.idx, inst.Raw, tt.want)
+		}
+	}
+}
+
+func TestSectionString(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000001000000", // r1 = 1
+		"b702000002000000", // r2 = 2
+		"0500000000000000", // goto +0
+		"b703000003000000", // r3 = 3
+		"b704000004000000", // r4 = 4
+		"b705000005000000", // r5 = 5
+		"b706000006000000", // r6 = 6
+		"b700000000000000", // r0 = 0
+		"9500000000000000", // exit
+	}, "")
+
+	section, err := NewSection(hexData, "xdp", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	got := section.String()
+	for _, want := range []string{"section xdp", "9 instructions", "1 NOPs", "0: ", "8: ", "..."} {
+		if !strings.Contains(got, want) {
+			t.Errorf("String() = %q, expected it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "4: ") {
+		t.Errorf("String() = %q, expected the middle instructions to be left out", got)
+	}
+}
+
+func TestReplaceInstruction(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000001000000", // 0: r1 = 1
+		"b702000002000000", // 1: r2 = 2
+		"bf10000000000000", // 2: r0 = r1
+		"9500000000000000", // 3: exit
+	}, "")
+
+	for _, recompute := range []bool{false, true} {
+		section, err := NewSection(hexData, "test", true)
+		if err != nil {
+			t.Fatalf("NewSection() error = %v", err)
+		}
+		if !section.FoundDependency(2, 0) {
+			t.Fatalf("Expected instruction 2 to depend on 0 before the replace, got %v", section.Dependencies[2])
+		}
+
+		inst, err := bpf.NewInstruction("bf20000000000000") // r0 = r2
+		if err != nil {
+			t.Fatalf("NewInstruction() error = %v", err)
+		}
+		section.ReplaceInstruction(2, inst, recompute)
+
+		if section.Instructions[2] != inst {
+			t.Errorf("recompute = %v: instruction 2 was not replaced", recompute)
+		}
+		if got := section.FoundDependency(2, 1); got != recompute {
+			t.Errorf("recompute = %v: instruction 2 depends on 1 = %v, got %v", recompute, got, section.Dependencies[2])
+		}
+		if got := section.FoundDependency(2, 0); got == recompute {
+			t.Errorf("recompute = %v: instruction 2 depends on 0 = %v, got %v", recompute, got, section.Dependencies[2])
+		}
+		if got := section.FoundDependedBy(0, 2); got == recompute {
+			t.Errorf("recompute = %v: instruction 0 depended by 2 = %v, got %v", recompute, got, section.Dependencies[0])
+		}
+		if got := section.FoundDependedBy(1, 2); got != recompute {
+			t.Errorf("recompute = %v: instruction 1 depended by 2 = %v, got %v", recompute, got, section.Dependencies[1])
+		}
+	}
+}
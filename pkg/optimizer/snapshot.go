@@ -0,0 +1,75 @@
+package optimizer
+
+import (
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// sectionSnapshot is the state captured by Snapshot. Dependencies are kept too,
+// since constant propagation clears the edges of the instructions it rewrites,
+// and so is the control flow graph, which branch folding removes edges from.
+type sectionSnapshot struct {
+	instructions     []*bpf.Instruction
+	dependencies     []DependencyInfo
+	controlFlowGraph *ControlFlowGraph
+	entryPoints      []int
+}
+
+// Snapshot captures a deep copy of the instructions, their dependencies and the control flow
+// graph for Reset. Taking a new snapshot replaces the previous one.
+func (s *Section) Snapshot() {
+	snapshot := &sectionSnapshot{
+		instructions: make([]*bpf.Instruction, len(s.Instructions)),
+		dependencies: make([]DependencyInfo, len(s.Dependencies)),
+		entryPoints:  append([]int(nil), s.EntryPoints...),
+	}
+	if s.ControlFlowGraph != nil {
+		snapshot.controlFlowGraph = s.ControlFlowGraph.Clone()
+	}
+
+	for i, inst := range s.Instructions {
+		snapshot.instructions[i] = inst.Clone()
+	}
+	for i, dep := range s.Dependencies {
+		snapshot.dependencies[i] = DependencyInfo{
+			Dependencies: append([]int(nil), dep.Dependencies...),
+			DependedBy:   append([]int(nil), dep.DependedBy...),
+		}
+	}
+
+	s.snapshot = snapshot
+}
+
+// Reset restores the section to the last Snapshot and drops the state left by passes
+// (store candidates, origins, moved relocations, trace, stats and candidate counts). It does nothing if no snapshot was taken.
+// The snapshot is kept, so the section can be reset again after the next attempt.
+func (s *Section) Reset() {
+	if s.snapshot == nil {
+		return
+	}
+
+	s.Instructions = make([]*bpf.Instruction, len(s.snapshot.instructions))
+	for i, inst := range s.snapshot.instructions {
+		s.Instructions[i] = inst.Clone()
+	}
+
+	s.Dependencies = make([]DependencyInfo, len(s.snapshot.dependencies))
+	for i, dep := range s.snapshot.dependencies {
+		s.Dependencies[i] = DependencyInfo{
+			Dependencies: append([]int(nil), dep.Dependencies...),
+			DependedBy:   append([]int(nil), dep.DependedBy...),
+		}
+	}
+
+	s.ControlFlowGraph = nil
+	if s.snapshot.controlFlowGraph != nil {
+		s.ControlFlowGraph = s.snapshot.controlFlowGraph.Clone()
+	}
+	s.EntryPoints = append([]int(nil), s.snapshot.entryPoints...)
+
+	s.StoreCandidates = nil
+	s.Origins = nil
+	s.MovedRelocations = nil
+	s.Trace = nil
+	s.Stats = OptimizationStats{}
+	s.Candidates = nil
+}
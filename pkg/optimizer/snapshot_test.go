@@ -0,0 +1,95 @@
+package optimizer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+func TestSectionSnapshotReset(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000000000000", // r1 = 0
+		"731af8ff00000000", // *(u8 *)(r10 - 8) = r1
+		"731af9ff00000000", // *(u8 *)(r10 - 7) = r1
+		"731afaff00000000", // *(u8 *)(r10 - 6) = r1
+		"731afbff00000000", // *(u8 *)(r10 - 5) = r1
+		"b700000000000000", // r0 = 0
+		"9500000000000000", // exit
+	}, "")
+
+	section, err := NewSection(hexData, "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	section.Snapshot()
+	original := section.Dump()
+
+	section.applyOptimizations(nil, 0)
+	if bytes.Equal(section.Dump(), original) {
+		t.Fatalf("applyOptimizations() left the section unchanged")
+	}
+
+	section.Reset()
+	if !bytes.Equal(section.Dump(), original) {
+		t.Errorf("Reset() = %x, expected %x", section.Dump(), original)
+	}
+	if len(section.Trace) != 0 || section.StoreCandidates != nil {
+		t.Errorf("Reset() kept pass state: trace %v, store candidates %v", section.Trace, section.StoreCandidates)
+	}
+
+	// Without superword merge the propagated byte stores stay separate
+	if err := section.ApplyPass(PassConstantPropagation); err != nil {
+		t.Fatalf("ApplyPass() error = %v", err)
+	}
+	expected := []string{
+		bpf.NOP,
+		"720af8ff00000000",
+		"720af9ff00000000",
+		"720afaff00000000",
+		"720afbff00000000",
+		"b700000000000000",
+		"9500000000000000",
+	}
+	for i, want := range expected {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+	}
+}
+
+func TestSectionResetRestoresControlFlowGraph(t *testing.T) {
+	hexData := strings.Join([]string{
+		"b701000000000000", // 0: r1 = 0
+		"1501020000000000", // 1: if r1 == 0 goto +2 (always taken)
+		"b700000001000000", // 2: r0 = 1
+		"9500000000000000", // 3: exit
+		"b700000000000000", // 4: r0 = 0
+		"9500000000000000", // 5: exit
+	}, "")
+
+	section, err := NewSectionWithOptions(hexData, "test", SectionOptions{SkipOptimization: true, FunctionStarts: []int{0}})
+	if err != nil {
+		t.Fatalf("NewSectionWithOptions() error = %v", err)
+	}
+
+	section.Snapshot()
+	original := section.Dump()
+
+	// Branch folding removes the fall-through edge, so dead code drops 2 and 3
+	section.applyOptimizations(nil, 0)
+	if got := section.Instructions[2].Raw; got != bpf.NOP {
+		t.Fatalf("instruction 2 = %s after optimizing, expected %s", got, bpf.NOP)
+	}
+
+	// With the conditional jump back, the fall-through block is reachable again
+	section.Reset()
+	if err := section.ApplyPass(PassDeadCode); err != nil {
+		t.Fatalf("ApplyPass() error = %v", err)
+	}
+	if !bytes.Equal(section.Dump(), original) {
+		t.Errorf("dead code after Reset() = %x, expected %x", section.Dump(), original)
+	}
+}
@@ -0,0 +1,150 @@
+package optimizer
+
+import (
+	"encoding/binary"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// MaxStackDepth returns the number of stack bytes below r10 touched by the section.
+// It takes the ST/STX/LDX accesses made directly through r10 from the instruction analysis;
+// an access at r10+off of size n covers [off, off+n), so the deepest byte is off itself.
+// Accesses through a copy of r10 in another register are not tracked.
+func (s *Section) MaxStackDepth() int {
+	depth := 0
+
+	for _, inst := range s.Instructions {
+		analysis := analyzeInstruction(inst)
+
+		for _, access := range [][]int16{analysis.UpdatedStack, analysis.UsedStack} {
+			if len(access) != 2 {
+				continue
+			}
+
+			// access is [offset, size in bits]
+			if d := -int(access[0]); d > depth {
+				depth = d
+			}
+		}
+	}
+
+	return depth
+}
+
+// DumpStackBuffer replays the immediate stores (BPF_ST) made through baseReg and returns the
+// bytes they leave in [fromOff, toOff). Stores are applied in instruction order, ignoring control
+// flow, and bytes never stored read as zero. It lets tests check that merged stores still write
+// the same bytes, e.g. a string built one byte at a time.
+func (s *Section) DumpStackBuffer(baseReg uint8, fromOff, toOff int16) []byte {
+	if toOff <= fromOff {
+		return []byte{}
+	}
+	buf := make([]byte, int(toOff)-int(fromOff))
+
+	for _, inst := range s.Instructions {
+		if inst.GetInstructionClass() != bpf.BPF_ST || inst.Opcode&0xe0 != bpf.BPF_MEM || inst.DstReg != baseReg {
+			continue
+		}
+
+		// A double word store sign-extends its 32-bit imm
+		var value [8]byte
+		binary.LittleEndian.PutUint64(value[:], uint64(int64(inst.Imm)))
+
+		for i := 0; i < getSize(inst)/8; i++ {
+			off := int(inst.Offset) + i - int(fromOff)
+			if off >= 0 && off < len(buf) {
+				buf[off] = value[i]
+			}
+		}
+	}
+
+	return buf
+}
+
+// MemoryAccess is a load or store made by a memory instruction
+type MemoryAccess struct {
+	Index     int
+	IsLoad    bool
+	IsStore   bool // atomics both load and store
+	BaseReg   uint8
+	Offset    int16
+	SizeBytes int
+	IsStack   bool // BaseReg is r10, or a copy of it moved by constant adds, like RegAlias
+}
+
+// MemoryOps lists the LDX, ST, STX and atomic accesses of the section in instruction order.
+// Copies of r10 are only followed within a basic block, so a stack pointer carried across
+// a jump or call is reported as a non-stack access.
+func (s *Section) MemoryOps() []MemoryAccess {
+	accesses := make([]MemoryAccess, 0)
+
+	blockStarts := make(map[int]bool)
+	for _, start := range s.blockStarts() {
+		blockStarts[start] = true
+	}
+
+	// register -> offset from r10 it holds
+	var stackRegs map[uint8]int16
+	for i, inst := range s.Instructions {
+		if i == 0 || blockStarts[i] {
+			stackRegs = map[uint8]int16{10: 0}
+		}
+
+		class := inst.GetInstructionClass()
+		mode := inst.Opcode & 0xe0
+
+		access := MemoryAccess{Index: i, Offset: inst.Offset, SizeBytes: getSize(inst) / 8}
+		switch {
+		case class == bpf.BPF_LDX && (mode == bpf.BPF_MEM || mode == bpf.BPF_MEMSX):
+			access.IsLoad = true
+			access.BaseReg = inst.SrcReg
+		case (class == bpf.BPF_ST || class == bpf.BPF_STX) && mode == bpf.BPF_MEM:
+			access.IsStore = true
+			access.BaseReg = inst.DstReg
+		case class == bpf.BPF_STX && mode == bpf.BPF_ATOMIC:
+			access.IsLoad = true
+			access.IsStore = true
+			access.BaseReg = inst.DstReg
+		}
+
+		if access.IsLoad || access.IsStore {
+			_, access.IsStack = stackRegs[access.BaseReg]
+			accesses = append(accesses, access)
+		}
+
+		updateStackRegs(stackRegs, inst)
+	}
+
+	return accesses
+}
+
+// updateStackRegs tracks the registers holding r10 plus a constant after inst
+func updateStackRegs(stackRegs map[uint8]int16, inst *bpf.Instruction) {
+	switch {
+	case inst.Opcode == bpf.BPF_ALU64|bpf.ALU_MOV|bpf.BPF_X && inst.Offset == 0:
+		if off, ok := stackRegs[inst.SrcReg]; ok {
+			stackRegs[inst.DstReg] = off
+			return
+		}
+	case inst.Opcode == bpf.BPF_ALU64|bpf.ALU_ADD|bpf.BPF_K:
+		if off, ok := stackRegs[inst.DstReg]; ok {
+			stackRegs[inst.DstReg] = off + int16(inst.Imm)
+			return
+		}
+	case inst.GetInstructionClass() == bpf.BPF_STX && inst.Opcode&0xe0 == bpf.BPF_ATOMIC:
+		// Fetch and cmpxchg write src_reg or r0, which the analysis does not report
+		delete(stackRegs, inst.SrcReg)
+		delete(stackRegs, 0)
+		return
+	}
+
+	analysis := analyzeInstruction(inst)
+	if analysis.UpdatedReg >= 0 {
+		delete(stackRegs, uint8(analysis.UpdatedReg))
+	}
+	if analysis.IsCall || inst.Opcode == bpf.BPF_JMP|bpf.JMP_CALL {
+		for reg := uint8(0); reg <= 5; reg++ {
+			delete(stackRegs, reg)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package optimizer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMaxStackDepth(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  []string
+		want int
+	}{
+		{
+			name: "no stack access",
+			hex:  []string{"b700000000000000", "9500000000000000"},
+			want: 0,
+		},
+		{
+			name: "64-bit store at the deepest offset",
+			hex: []string{
+				"7a0afcff01000000", // *(u64 *)(r10 - 4) = 1 (reaches above r10, not deeper)
+				"7b1af8ff00000000", // *(u64 *)(r10 - 8) = r1
+				"7b1af0ff00000000", // *(u64 *)(r10 - 16) = r1
+				"61a2f4ff00000000", // r2 = *(u32 *)(r10 - 12)
+				"7b119cff00000000", // *(u64 *)(r1 - 100) = r1 (not through r10)
+				"9500000000000000", // exit
+			},
+			want: 16,
+		},
+		{
+			name: "load is the deepest access",
+			hex: []string{
+				"720afeff01000000", // *(u8 *)(r10 - 2) = 1
+				"69a200fe00000000", // r2 = *(u16 *)(r10 - 512)
+				"9500000000000000", // exit
+			},
+			want: 512,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section := createTestSection(tt.hex)
+			if got := section.MaxStackDepth(); got != tt.want {
+				t.Errorf("MaxStackDepth() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDumpStackBuffer(t *testing.T) {
+	section, err := NewSection(strings.Join([]string{
+		"7a0af8ffffffffff", // *(u64 *)(r10 - 8) = -1
+		"620af8ff44332211", // *(u32 *)(r10 - 8) = 0x11223344
+		"7201f8ff55000000", // *(u8 *)(r1 - 8) = 0x55 (other base register)
+		"b700000000000000", // r0 = 0
+		"9500000000000000", // exit
+	}, ""), "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	want := []byte{0x44, 0x33, 0x22, 0x11, 0xff, 0xff, 0xff, 0xff, 0x00}
+	if got := section.DumpStackBuffer(10, -8, 1); !bytes.Equal(got, want) {
+		t.Errorf("DumpStackBuffer() = %x, expected %x", got, want)
+	}
+}
+
+func TestMemoryOps(t *testing.T) {
+	section, err := NewSection(strings.Join([]string{
+		"bfa1000000000000", // 0: r1 = r10
+		"07010000f8ffffff", // 1: r1 += -8
+		"7a01000000000000", // 2: *(u64 *)(r1 + 0) = 0
+		"61a2fcff00000000", // 3: r2 = *(u32 *)(r10 - 4)
+		"6b23020000000000", // 4: *(u16 *)(r3 + 2) = r2
+		"7161010000000000", // 5: r1 = *(u8 *)(r6 + 1)
+		"db21000000000000", // 6: lock *(u64 *)(r1 + 0) += r2
+		"bfa4000000000000", // 7: r4 = r10
+		"7b44f0ff00000000", // 8: *(u64 *)(r4 - 16) = r4
+		"9500000000000000", // 9: exit
+	}, ""), "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	expected := []MemoryAccess{
+		{Index: 2, IsStore: true, BaseReg: 1, Offset: 0, SizeBytes: 8, IsStack: true},
+		{Index: 3, IsLoad: true, BaseReg: 10, Offset: -4, SizeBytes: 4, IsStack: true},
+		{Index: 4, IsStore: true, BaseReg: 3, Offset: 2, SizeBytes: 2},
+		{Index: 5, IsLoad: true, BaseReg: 6, Offset: 1, SizeBytes: 1},
+		{Index: 6, IsLoad: true, IsStore: true, BaseReg: 1, Offset: 0, SizeBytes: 8}, // r1 reloaded at 5
+		{Index: 8, IsStore: true, BaseReg: 4, Offset: -16, SizeBytes: 8, IsStack: true},
+	}
+
+	got := section.MemoryOps()
+	if len(got) != len(expected) {
+		t.Fatalf("MemoryOps() = %+v, expected %d accesses", got, len(expected))
+	}
+	for i, want := range expected {
+		if got[i] != want {
+			t.Errorf("access %d = %+v, expected %+v", i, got[i], want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package optimizer
 
 import (
 	"fmt"
+	"slices"
 	"sort"
 
 	"github.com/beepfd/bpf-optimizer/pkg/bpf"
@@ -10,6 +11,7 @@ import (
 // SuperwordMerger handles superword-level merge optimization
 type SuperwordMerger struct {
 	section *Section
+	targets map[int]bool // instructions a branch lands on, see jumpTargets
 }
 
 // NewSuperwordMerger creates a new SuperwordMerger instance
@@ -83,131 +85,142 @@ type MergeCandidate struct {
 }
 
 // analyse identifies merge candidates from a group of memory operations
-// This implementation closely follows the Python version's logic
+// This implementation closely follows the Python version's logic: each run of consecutive
+// stores is cut into pieces no wider than the alignment of their first offset allows
 func (sm *SuperwordMerger) analyse(group []MemoryOperation) [][]int {
 	if len(group) < 2 {
 		return nil
 	}
 
-	// Create arrays for sorting (similar to Python's numpy arrays)
-	dsts := make([]uint8, len(group))
-	offs := make([]int16, len(group))
-	sizes := make([]int, len(group))
-	indices := make([]int, len(group))
-
-	for i, op := range group {
-		dsts[i] = op.DstReg
-		offs[i] = op.Offset
-		sizes[i] = op.Size
-		indices[i] = op.Index
-	}
+	candidates := [][]int{}
+	runs := consecutiveRuns(group)
+
+	for r, run := range runs {
+		// Stores of the same base and size that stop the previous run are missed opportunities
+		if r > 0 {
+			last := runs[r-1][len(runs[r-1])-1]
+			if last.DstReg == run[0].DstReg && last.Size == run[0].Size {
+				sm.section.countBlocked(BlockedNotConsecutive)
+			}
+		}
 
-	// Sort by destination register and offset (lexsort equivalent)
-	type sortData struct {
-		dst, size int
-		off       int16
-		index     int
-	}
+		size := run[0].Size
+		for j := 0; j < len(run); {
+			cap := getCap(run[j].Offset)
+			n := 1
+			for j+n < len(run) && size*(n+1) <= cap {
+				n++
+			}
 
-	sortSlice := make([]sortData, len(group))
-	for i := range group {
-		sortSlice[i] = sortData{
-			dst:   int(dsts[i]),
-			off:   offs[i],
-			size:  sizes[i],
-			index: indices[i],
-		}
-	}
+			// Unless the piece already fills the widest store
+			if j+n < len(run) && size*(n+1) <= 64 {
+				sm.section.countBlocked(BlockedMisaligned)
+			}
 
-	sort.Slice(sortSlice, func(i, j int) bool {
-		if sortSlice[i].dst != sortSlice[j].dst {
-			return sortSlice[i].dst < sortSlice[j].dst
+			if n >= 2 {
+				sm.processGroup(memoryIndices(run[j:j+n]), &candidates)
+			}
+			j += n
 		}
-		return sortSlice[i].off < sortSlice[j].off
-	})
-
-	// Extract sorted data
-	for i, data := range sortSlice {
-		dsts[i] = uint8(data.dst)
-		offs[i] = data.off
-		sizes[i] = data.size
-		indices[i] = data.index
 	}
 
-	candidates := [][]int{}
-
-	// Implement Python's double loop logic
-	processed := make([]bool, len(dsts))
+	return candidates
+}
 
-	for j := 0; j < len(dsts); j++ {
-		if processed[j] {
-			continue
+// consecutiveRuns sorts the stores by base register and offset and splits them into maximal
+// runs of stores of the same size to the same base, each starting where the previous one ends.
+// A store continuing no run is a run of its own.
+func consecutiveRuns(group []MemoryOperation) [][]MemoryOperation {
+	sorted := slices.Clone(group)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].DstReg != sorted[j].DstReg {
+			return sorted[i].DstReg < sorted[j].DstReg
 		}
+		return sorted[i].Offset < sorted[j].Offset
+	})
 
-		dst := dsts[j]
-		off := offs[j]
-		size := sizes[j]
-		cap := getCap(off)
-
-		currentGroup := []int{}
-		currentGroup = append(currentGroup, indices[j])
-		processed[j] = true
-
-		// Inner loop to find consecutive mergeable operations
-		for k := j + 1; k < len(dsts); k++ {
-			if processed[k] {
+	runs := make([][]MemoryOperation, 0)
+	for i, op := range sorted {
+		if i > 0 {
+			prev := sorted[i-1]
+			if prev.DstReg == op.DstReg && prev.Size == op.Size && prev.Offset+int16(prev.Size/8) == op.Offset {
+				runs[len(runs)-1] = append(runs[len(runs)-1], op)
 				continue
 			}
-
-			if dst == dsts[k] &&
-				off+int16(size/8) == offs[k] &&
-				size == sizes[k] &&
-				size*(k-j+1) <= cap {
-				// Update offset for next iteration
-				off = offs[k]
-				currentGroup = append(currentGroup, indices[k])
-				processed[k] = true
-			} else {
-				// Process current group and break
-				break
-			}
-		}
-
-		// Process the group if it has enough elements
-		if len(currentGroup) >= 2 {
-			sm.processGroup(currentGroup, &candidates)
 		}
+		runs = append(runs, []MemoryOperation{op})
 	}
-
-	return candidates
+	return runs
 }
 
-// analyseGroup analyzes a group of instruction hex strings (matching Python's analyse function)
-func (sm *SuperwordMerger) analyseGroup(group []string, indices []int) [][]int {
-	if len(group) < 2 {
-		return nil
+// memoryIndices returns the instruction indices of the operations
+func memoryIndices(ops []MemoryOperation) []int {
+	indices := make([]int, len(ops))
+	for i, op := range ops {
+		indices[i] = op.Index
 	}
+	return indices
+}
 
-	// Convert hex strings to MemoryOperation objects
-	memOps := make([]MemoryOperation, len(group))
-	for i, hexStr := range group {
-		inst, err := bpf.NewInstruction(hexStr)
-		if err != nil {
-			continue
-		}
-
-		memOps[i] = MemoryOperation{
-			Index:    indices[i],
+// memoryOperations describes the stores at indices
+func (sm *SuperwordMerger) memoryOperations(indices []int) []MemoryOperation {
+	ops := make([]MemoryOperation, len(indices))
+	for i, idx := range indices {
+		inst := sm.section.Instructions[idx]
+		ops[i] = MemoryOperation{
+			Index:    idx,
 			DstReg:   inst.DstReg,
 			Offset:   inst.Offset,
 			Size:     getSize(inst),
 			Capacity: getCap(inst.Offset),
-			Raw:      hexStr,
+			Raw:      inst.Raw,
 		}
 	}
+	return ops
+}
+
+// barrierSegments splits the sorted stores wherever a merge barrier lies between two of them
+func (sm *SuperwordMerger) barrierSegments(stores []int) [][]int {
+	segments := make([][]int, 0)
+	if len(stores) == 0 {
+		return segments
+	}
 
-	// Use the existing analyse function
-	return sm.analyse(memOps)
+	segment := []int{stores[0]}
+	for i := 1; i < len(stores); i++ {
+		if sm.hasInterveningJumpOrLoad(stores[i-1], stores[i]) {
+			if adjacentStores(sm.section.Instructions[stores[i-1]], sm.section.Instructions[stores[i]]) {
+				sm.section.countBlocked(BlockedBarrier)
+			}
+			segments = append(segments, segment)
+			segment = nil
+		}
+		segment = append(segment, stores[i])
+	}
+	return append(segments, segment)
+}
+
+// findStoreRuns returns the maximal runs of two or more immediate stores of the same size to
+// consecutive offsets of the same base register with no merge barrier between them, each in
+// ascending offset order. The stores of a run need not be adjacent instructions.
+func (s *Section) findStoreRuns() [][]int {
+	stores := make([]int, 0)
+	for i, inst := range s.Instructions {
+		if inst.GetInstructionClass() == bpf.BPF_ST && inst.Opcode&0xe0 == bpf.BPF_MEM {
+			stores = append(stores, i)
+		}
+	}
+
+	sm := NewSuperwordMerger(s)
+	runs := make([][]int, 0)
+	for _, segment := range sm.barrierSegments(stores) {
+		for _, run := range consecutiveRuns(sm.memoryOperations(segment)) {
+			if len(run) >= 2 {
+				runs = append(runs, memoryIndices(run))
+			}
+		}
+	}
+	return runs
 }
 
 // processGroup processes a group of indices and adds appropriate candidates
@@ -229,39 +242,82 @@ func (sm *SuperwordMerger) processGroup(group []int, candidates *[][]int) {
 // hasInterveningJumpOrLoad checks if there are jump or load instructions between two indices
 func (sm *SuperwordMerger) hasInterveningJumpOrLoad(start, end int) bool {
 	for i := start + 1; i < end; i++ {
-		inst := sm.section.Instructions[i]
-
-		// Don't skip any instructions when checking for jumps/loads
-		// Even "NOP" instructions like "0500000000000000" are actually jump instructions
-		opcode := inst.Opcode
-		class := opcode & 0x07
-
-		// Check for BPF_LDX, BPF_JMP, and BPF_JMP32 (matching Python logic)
-		if class == bpf.BPF_LDX || class == bpf.BPF_JMP || class == 0x06 { // BPF_JMP32 = 0x06
+		if sm.isMergeBarrier(i) {
 			return true
 		}
 	}
 	return false
 }
 
-// eliminateOverlappingCandidates removes candidates that are subsets of other candidates
+// isMergeBarrier checks if stores may not be merged across the instruction at idx:
+// BPF_LDX, BPF_JMP and BPF_JMP32 (matching Python logic, including a tail call that may never
+// return), and ld_abs/ld_ind, which may end the program before the later stores. Even a `goto +0`
+// counts, unless MergeAcrossNopJumps is set and no branch lands on it or right after it.
+func (sm *SuperwordMerger) isMergeBarrier(idx int) bool {
+	inst := sm.section.Instructions[idx]
+	class := inst.GetInstructionClass()
+
+	if sm.section.MergeAcrossNopJumps && inst.Opcode == bpf.BPF_JMP|bpf.JMP_A && inst.IsNOP() {
+		targets := sm.jumpTargets()
+		return targets[idx] || targets[idx+1]
+	}
+
+	return class == bpf.BPF_LDX || class == bpf.BPF_JMP || class == bpf.BPF_JMP32 || isLegacyPacketLoad(inst)
+}
+
+// jumpTargets returns the instructions some branch or pseudo call lands on, computed once per merger
+func (sm *SuperwordMerger) jumpTargets() map[int]bool {
+	if sm.targets != nil {
+		return sm.targets
+	}
+
+	sm.targets = make(map[int]bool)
+	for i, inst := range sm.section.Instructions {
+		switch {
+		case inst.Opcode == bpf.BPF_GOTOL, inst.IsPseudoCall():
+			sm.targets[i+int(inst.Imm)+1] = true
+		case inst.IsJump() && !inst.IsNOP():
+			sm.targets[i+int(inst.Offset)+1] = true
+		}
+	}
+	return sm.targets
+}
+
+// eliminateOverlappingCandidates keeps candidates that share no instruction with each other.
+// Larger candidates win, so subsets and partial overlaps of a kept candidate are dropped;
+// between candidates of the same size the earlier one wins. Survivors keep their order.
 func (sm *SuperwordMerger) eliminateOverlappingCandidates(candidates [][]int) [][]int {
-	toRemove := make(map[int]bool)
-
-	for i := 0; i < len(candidates); i++ {
-		for j := 0; j < len(candidates); j++ {
-			if i != j {
-				// Check if candidates[i] is a subset of candidates[j]
-				if isSubset(candidates[i], candidates[j]) {
-					toRemove[i] = true
-				}
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return len(candidates[order[a]]) > len(candidates[order[b]])
+	})
+
+	claimed := make(map[int]bool)
+	keep := make(map[int]bool)
+	for _, i := range order {
+		overlaps := false
+		for _, idx := range candidates[i] {
+			if claimed[idx] {
+				overlaps = true
+				break
 			}
 		}
+		if overlaps {
+			continue
+		}
+
+		for _, idx := range candidates[i] {
+			claimed[idx] = true
+		}
+		keep[i] = true
 	}
 
 	result := [][]int{}
 	for i, candidate := range candidates {
-		if !toRemove[i] {
+		if keep[i] {
 			result = append(result, candidate)
 		}
 	}
@@ -269,6 +325,28 @@ func (sm *SuperwordMerger) eliminateOverlappingCandidates(candidates [][]int) []
 	return result
 }
 
+// sortByOffset returns a copy of candidate ordered by store offset, or nil unless the
+// stores share a base register and size and cover adjacent bytes. Offsets are compared
+// as ints so a group running past the end of the 16-bit offset field is not adjacent.
+func (sm *SuperwordMerger) sortByOffset(candidate []int) []int {
+	sorted := append([]int{}, candidate...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sm.section.Instructions[sorted[a]].Offset < sm.section.Instructions[sorted[b]].Offset
+	})
+
+	first := sm.section.Instructions[sorted[0]]
+	size := getSize(first)
+	for i := 1; i < len(sorted); i++ {
+		inst := sm.section.Instructions[sorted[i]]
+		if inst.DstReg != first.DstReg || getSize(inst) != size ||
+			int(inst.Offset) != int(first.Offset)+i*size/8 {
+			return nil
+		}
+	}
+
+	return sorted
+}
+
 // isSubset checks if slice a is a subset of slice b
 func isSubset(a, b []int) bool {
 	if len(a) >= len(b) {
@@ -289,91 +367,96 @@ func isSubset(a, b []int) bool {
 	return true
 }
 
-// ApplySuperwordMergeWithCandidates implements superword merge with provided store candidates
+// ApplySuperwordMergeWithCandidates implements superword merge with provided store candidates.
+// A nil slice falls back to the section's StoreCandidates, scanning for them if not yet populated.
 func (sm *SuperwordMerger) ApplySuperwordMergeWithCandidates(storeCandidates []int) {
-	sm.applySuperwordMergeWithCandidates(storeCandidates)
-}
-
-// applySuperwordMergeWithCandidates internal implementation
-func (sm *SuperwordMerger) applySuperwordMergeWithCandidates(storeCandidates []int) {
-	if len(storeCandidates) < 2 {
-		return
+	if storeCandidates == nil {
+		if sm.section.StoreCandidates == nil {
+			sm.section.FindStoreCandidates()
+		}
+		storeCandidates = sm.section.StoreCandidates
 	}
 
-	// Sort store candidates
-	sort.Ints(storeCandidates)
+	sm.applySuperwordMergeWithCandidates(sm.section.validateStoreCandidatesSorted(storeCandidates))
+}
 
-	// Group consecutive store operations (matching Python's logic)
-	allCandidates := [][]int{}
-	group := []string{} // equivalent to Python's group
-	indices := []int{}  // equivalent to Python's indices
-	flag := false
-
-	// Check all store instructions (Python's main loop)
-	for i := 0; i < len(storeCandidates)-1; i++ {
-		currentIdx := storeCandidates[i]
-		nextIdx := storeCandidates[i+1]
-
-		// Initialize group if empty
-		if len(group) == 0 {
-			inst := sm.section.Instructions[currentIdx]
-			group = append(group, inst.Raw)
-			indices = append(indices, currentIdx)
-			flag = false
+// validateStoreCandidatesSorted returns the store candidates in ascending order, without
+// duplicates or indices outside the section, as findMergeCandidates expects them. Constant
+// propagation collects them by register definition, so they come in any order. A slice already
+// in order is returned as is, any other is sorted into a copy leaving the caller's untouched.
+func (s *Section) validateStoreCandidatesSorted(storeCandidates []int) []int {
+	sorted := true
+	for i, idx := range storeCandidates {
+		if idx < 0 || idx >= len(s.Instructions) || (i > 0 && storeCandidates[i-1] >= idx) {
+			sorted = false
+			break
 		}
+	}
+	if sorted {
+		return storeCandidates
+	}
 
-		// Check if there are jump/load instructions between stores
-		flag = false
-		for j := currentIdx + 1; j < nextIdx; j++ {
-			inst := sm.section.Instructions[j]
-
-			// Don't skip any instructions when checking for jumps/loads
-			// Even "NOP" instructions like "0500000000000000" are actually jump instructions
-			opcode := inst.Opcode
-			class := opcode & 0x07
-
-			if class == bpf.BPF_LDX || class == bpf.BPF_JMP || class == 0x06 { // BPF_JMP32
-				// Stop updating and start analyzing current candidate list
-				if len(group) >= 2 {
-					candidates := sm.analyseGroup(group, indices)
-					if len(candidates) > 0 {
-						allCandidates = append(allCandidates, candidates...)
-					}
-				}
-				group = []string{}
-				indices = []int{}
-				flag = true
-				break
-			}
+	valid := make([]int, 0, len(storeCandidates))
+	for _, idx := range storeCandidates {
+		if idx >= 0 && idx < len(s.Instructions) {
+			valid = append(valid, idx)
 		}
+	}
+	sort.Ints(valid)
+	return slices.Compact(valid)
+}
 
-		if !flag {
-			// Add next instruction to group
-			inst := sm.section.Instructions[nextIdx]
-			group = append(group, inst.Raw)
-			indices = append(indices, nextIdx)
-		}
+// applySuperwordMergeWithCandidates internal implementation
+func (sm *SuperwordMerger) applySuperwordMergeWithCandidates(storeCandidates []int) {
+	sm.applyMerges(sm.findMergeCandidates(storeCandidates))
+}
+
+// findMergeCandidates groups the store candidates and returns the index groups that can be merged
+func (sm *SuperwordMerger) findMergeCandidates(storeCandidates []int) [][]int {
+	if len(storeCandidates) < 2 {
+		return nil
 	}
 
-	// Process remaining group
-	if len(group) >= 2 {
-		candidates := sm.analyseGroup(group, indices)
-		if len(candidates) > 0 {
-			allCandidates = append(allCandidates, candidates...)
+	// The grouping compares neighbouring candidates
+	storeCandidates = sm.section.validateStoreCandidatesSorted(storeCandidates)
+
+	// Group the stores between barriers, then find runs within each group (matching Python's logic)
+	allCandidates := [][]int{}
+	for _, segment := range sm.barrierSegments(storeCandidates) {
+		if len(segment) >= 2 {
+			allCandidates = append(allCandidates, sm.analyse(sm.memoryOperations(segment))...)
 		}
 	}
 
 	// Eliminate overlapping candidates
-	finalCandidates := sm.eliminateOverlappingCandidates(allCandidates)
-
-	// Apply merges
-	sm.applyMerges(finalCandidates)
+	return sm.eliminateOverlappingCandidates(allCandidates)
 }
 
 // applyMerges applies the actual instruction merging
 func (sm *SuperwordMerger) applyMerges(candidates [][]int) {
+	// Instructions already rewritten by a merge, a later candidate reusing one would read a NOP
+	consumed := make(map[int]bool)
+
 	for _, candidate := range candidates {
-		if len(candidate) < 2 {
+		if len(candidate) < 2 || !sm.section.inRange(candidate...) {
+			continue
+		}
+
+		overlaps := false
+		for _, idx := range candidate {
+			if consumed[idx] {
+				overlaps = true
+				break
+			}
+		}
+		if overlaps {
+			continue
+		}
+
+		// The merged store takes the lowest offset and the immediates are laid out from it,
+		// whatever order the candidate lists its indices in
+		candidate = sm.sortByOffset(candidate)
+		if candidate == nil {
 			continue
 		}
 
@@ -404,6 +487,7 @@ func (sm *SuperwordMerger) applyMerges(candidates [][]int) {
 			if len(newImm) > 8 {
 				highBits := newImm[8:]
 				if highBits != "00000000"[:len(highBits)] {
+					sm.section.countBlocked(BlockedOverflow)
 					continue
 				}
 			}
@@ -415,6 +499,13 @@ func (sm *SuperwordMerger) applyMerges(candidates [][]int) {
 			}
 		}
 
+		// A 64-bit store sign-extends its 32-bit imm, which only matches the merged
+		// stores when the bytes above it were zero and the imm is not negative
+		if newSize == 64 && newImm[6] >= '8' {
+			sm.section.countBlocked(BlockedSignExtension)
+			continue
+		}
+
 		// Create new instruction
 		newSizeMask := getSizeMask(newSize)
 		newOpcode := bpf.BPF_MEM | newSizeMask | bpf.BPF_ST
@@ -428,8 +519,23 @@ func (sm *SuperwordMerger) applyMerges(candidates [][]int) {
 
 		// Apply the merge
 		sm.section.Instructions[candidate[0]] = newInst
+		sm.section.recordOrigins(candidate[0], candidate...)
 		for i := 1; i < len(candidate); i++ {
 			sm.section.Instructions[candidate[i]].SetAsNOP()
 		}
+		for _, idx := range candidate {
+			consumed[idx] = true
+		}
+		sm.section.countApplied()
+	}
+}
+
+// adjacentStores checks if two stores share a base register and size and write neighbouring bytes,
+// in either order, so that nothing but what lies between them keeps them from being merged
+func adjacentStores(a, b *bpf.Instruction) bool {
+	if a.DstReg != b.DstReg || getSize(a) != getSize(b) {
+		return false
 	}
+	distance := int(b.Offset) - int(a.Offset)
+	return distance == getSize(a)/8 || distance == -getSize(a)/8
 }
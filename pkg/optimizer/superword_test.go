@@ -1,6 +1,9 @@
 package optimizer
 
 import (
+	"bytes"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/beepfd/bpf-optimizer/pkg/bpf"
@@ -41,14 +44,18 @@ func TestGetCap(t *testing.T) {
 		offset   int16
 		expected int
 	}{
-		{0, 64},  // 8-byte aligned
-		{8, 64},  // 8-byte aligned
-		{4, 32},  // 4-byte aligned
-		{12, 32}, // 4-byte aligned
-		{2, 16},  // 2-byte aligned
-		{6, 16},  // 2-byte aligned
-		{1, 8},   // 1-byte aligned
-		{3, 8},   // 1-byte aligned
+		{0, 64},   // 8-byte aligned
+		{8, 64},   // 8-byte aligned
+		{4, 32},   // 4-byte aligned
+		{12, 32},  // 4-byte aligned
+		{2, 16},   // 2-byte aligned
+		{6, 16},   // 2-byte aligned
+		{1, 8},    // 1-byte aligned
+		{3, 8},    // 1-byte aligned
+		{-56, 64}, // stack slots below r10 follow the same alignment
+		{-52, 32},
+		{-6, 16},
+		{-3, 8},
 	}
 
 	for _, test := range tests {
@@ -123,8 +130,8 @@ func TestHasInterveningJumpOrLoad(t *testing.T) {
 	// Test with jump instruction between stores
 	instructions := []string{
 		"6200000012000000", // ST [r0], 0x12 (index 0)
-		"0500000000000000", // NOP (index 1)
-		"0500000000000000", // NOP (index 2)
+		"b701000000000000", // r1 = 0 (index 1)
+		"b702000000000000", // r2 = 0 (index 2)
 		"6a00000034000000", // ST [r0], 0x34 (index 3)
 	}
 
@@ -137,6 +144,15 @@ func TestHasInterveningJumpOrLoad(t *testing.T) {
 		t.Error("Expected no intervening jump/load, but got true")
 	}
 
+	// A goto +0 filler is still a jump unless MergeAcrossNopJumps is set
+	instructions[1] = bpf.NOP
+	section = createTestSection(instructions)
+	merger = NewSuperwordMerger(section)
+
+	if !merger.hasInterveningJumpOrLoad(0, 3) {
+		t.Error("Expected goto +0 to be a barrier, but got false")
+	}
+
 	// Add a jump instruction
 	instructions[1] = "0500050000000000" // JMP +5
 	section = createTestSection(instructions)
@@ -189,6 +205,48 @@ func TestEliminateOverlappingCandidates(t *testing.T) {
 	}
 }
 
+func TestEliminateOverlappingCandidatesPartialOverlap(t *testing.T) {
+	section := createTestSection([]string{"6200000012000000"})
+	merger := NewSuperwordMerger(section)
+
+	candidates := [][]int{
+		{1087, 1086},             // overlaps the larger group below
+		{1085, 1084, 1083, 1082}, // overlaps the larger group below
+		{1090, 1089, 1088, 1087, 1086, 1085, 1084, 1083}, // largest, kept
+		{1083, 1082}, // overlaps the larger group
+		{1095, 1094}, // same size and overlapping, earlier one kept
+		{1094, 1093},
+		{1092, 1091},
+	}
+
+	result := merger.eliminateOverlappingCandidates(candidates)
+
+	expected := [][]int{
+		{1090, 1089, 1088, 1087, 1086, 1085, 1084, 1083},
+		{1095, 1094},
+		{1092, 1091},
+	}
+
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %v after elimination, got %v", expected, result)
+	}
+	for i := range expected {
+		if !equalIntSlice(result[i], expected[i]) {
+			t.Errorf("Candidate %d: expected %v, got %v", i, expected[i], result[i])
+		}
+	}
+
+	seen := make(map[int]bool)
+	for _, candidate := range result {
+		for _, idx := range candidate {
+			if seen[idx] {
+				t.Errorf("Instruction %d appears in two surviving candidates", idx)
+			}
+			seen[idx] = true
+		}
+	}
+}
+
 func TestAnalyse(t *testing.T) {
 	section := createTestSection([]string{"6200000012000000"})
 	merger := NewSuperwordMerger(section)
@@ -203,24 +261,14 @@ func TestAnalyse(t *testing.T) {
 
 	candidates := merger.analyse(group)
 
-	// With 4 elements, processGroup should create 1 candidate of size 4
-	if len(candidates) != 1 {
-		t.Errorf("Expected 1 candidate group, got %d", len(candidates))
-		for i, candidate := range candidates {
-			t.Logf("Candidate %d: %v", i, candidate)
-		}
+	// A store holds at most 64 bits, so the four words make two stdw candidates
+	expected := [][]int{{0, 1}, {2, 3}}
+	if len(candidates) != len(expected) {
+		t.Fatalf("Expected candidates %v, got %v", expected, candidates)
 	}
-
-	if len(candidates) > 0 {
-		expected := []int{0, 1, 2, 3}
-		if len(candidates[0]) != len(expected) {
-			t.Errorf("Expected candidate length %d, got %d", len(expected), len(candidates[0]))
-		} else {
-			for i, expectedIdx := range expected {
-				if candidates[0][i] != expectedIdx {
-					t.Errorf("Candidate[%d]: expected %d, got %d", i, expectedIdx, candidates[0][i])
-				}
-			}
+	for i := range expected {
+		if !equalIntSlice(candidates[i], expected[i]) {
+			t.Errorf("Candidate %d: expected %v, got %v", i, expected[i], candidates[i])
 		}
 	}
 }
@@ -259,11 +307,36 @@ func TestAnalyseDifferentRegisters(t *testing.T) {
 	}
 }
 
+// BenchmarkAnalyseLargeGroup guards against analyse going quadratic on programs with thousands
+// of consecutive stores, e.g. a large struct zeroed byte by byte
+func BenchmarkAnalyseLargeGroup(b *testing.B) {
+	const stores = 10000
+
+	merger := NewSuperwordMerger(createTestSection([]string{"7a0a000000000000"}))
+
+	// Stored from the highest offset down, so analyse has to sort them back
+	group := make([]MemoryOperation, stores)
+	for i := range group {
+		offset := int16(-stores + (stores - 1 - i))
+		group[i] = MemoryOperation{Index: i, DstReg: 10, Offset: offset, Size: 8, Capacity: getCap(offset)}
+	}
+
+	// Every aligned run of 8 bytes becomes one candidate
+	if got := len(merger.analyse(group)); got != stores/8 {
+		b.Fatalf("analyse() found %d candidates, expected %d", got, stores/8)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		merger.analyse(group)
+	}
+}
+
 func TestApplyMergesSimple(t *testing.T) {
-	// Test with two consecutive 32-bit stores
+	// Test with two consecutive 32-bit stores whose u64 value 0x12 fits a sign-extended imm32
 	instructions := []string{
 		"6200000012000000", // ST [r0+0], 0x12
-		"6200040034000000", // ST [r0+4], 0x34
+		"6200040000000000", // ST [r0+4], 0
 	}
 
 	section := createTestSection(instructions)
@@ -288,16 +361,172 @@ func TestApplyMergesSimple(t *testing.T) {
 	if mergedInst.Opcode&0x18 != 0x18 { // Should be BPF_DW (64-bit)
 		t.Errorf("Merged instruction should be 64-bit, got size mask 0x%02x", mergedInst.Opcode&0x18)
 	}
+	if mergedInst.Raw != "7a00000012000000" {
+		t.Errorf("Merged instruction = %s, expected 7a00000012000000", mergedInst.Raw)
+	}
+}
+
+func TestApplyMergesNegativeDoubleWord(t *testing.T) {
+	// The u64 value is 0x00000000ffffffff, but a stdw of imm 0xffffffff would sign-extend to -1
+	instructions := []string{
+		"62000000ffffffff", // ST [r0+0], 0xffffffff
+		"6200040000000000", // ST [r0+4], 0
+	}
+
+	section := createTestSection(instructions)
+	merger := NewSuperwordMerger(section)
+	merger.applyMerges([][]int{{0, 1}})
+
+	for i, want := range instructions {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+	}
+}
+
+func TestApplyMergesImmediateWidth(t *testing.T) {
+	tests := []struct {
+		name     string
+		insts    []string
+		expected string
+		sizeMask uint8
+		imm      int32
+	}{
+		{
+			name: "8 to 16",
+			insts: []string{
+				"720af8ffffffffff", // *(u8 *)(r10 - 8) = -1
+				"720af9ff34000000", // *(u8 *)(r10 - 7) = 0x34
+			},
+			expected: "6a0af8ffff340000", // *(u16 *)(r10 - 8) = 0x34ff
+			sizeMask: 0x08,
+			imm:      0x34ff,
+		},
+		{
+			name: "8 to 32",
+			insts: []string{
+				"720af8ff12000000", // *(u8 *)(r10 - 8) = 0x12
+				"720af9ff34000000", // *(u8 *)(r10 - 7) = 0x34
+				"720afaff56000000", // *(u8 *)(r10 - 6) = 0x56
+				"720afbff78000000", // *(u8 *)(r10 - 5) = 0x78
+			},
+			expected: "620af8ff12345678", // *(u32 *)(r10 - 8) = 0x78563412
+			sizeMask: 0x00,
+			imm:      0x78563412,
+		},
+		{
+			name: "16 to 32",
+			insts: []string{
+				"6a0af8ff34120000", // *(u16 *)(r10 - 8) = 0x1234
+				"6a0afaff78560000", // *(u16 *)(r10 - 6) = 0x5678
+			},
+			expected: "620af8ff34127856", // *(u32 *)(r10 - 8) = 0x56781234
+			sizeMask: 0x00,
+			imm:      0x56781234,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section := createTestSection(tt.insts)
+			merger := NewSuperwordMerger(section)
+
+			candidate := make([]int, len(tt.insts))
+			for i := range candidate {
+				candidate[i] = i
+			}
+			merger.applyMerges([][]int{candidate})
+
+			merged := section.Instructions[0]
+			if merged.Raw != tt.expected {
+				t.Errorf("merged instruction = %s, expected %s", merged.Raw, tt.expected)
+			}
+			if got := merged.Opcode & 0x18; got != tt.sizeMask {
+				t.Errorf("size mask = 0x%02x, expected 0x%02x", got, tt.sizeMask)
+			}
+			if merged.Imm != tt.imm {
+				t.Errorf("imm = %#x, expected %#x", merged.Imm, tt.imm)
+			}
+			for i := 1; i < len(tt.insts); i++ {
+				if !section.Instructions[i].IsNOP() {
+					t.Errorf("instruction %d = %s, expected a NOP", i, section.Instructions[i].Raw)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyMergesSkipsConsumedIndices(t *testing.T) {
+	instructions := []string{
+		"6a00000012000000", // STH [r0+0], 0x12
+		"6a00020034000000", // STH [r0+2], 0x34
+		"6a00040000000000", // STH [r0+4], 0
+	}
+
+	section := createTestSection(instructions)
+	merger := NewSuperwordMerger(section)
+
+	// The second candidate reuses index 1, which the first merge turns into a NOP
+	merger.applyMerges([][]int{{0, 1}, {1, 2}})
+
+	expected := []string{
+		"6200000012003400", // STW [r0+0], 0x340012
+		bpf.NOP,
+		"6a00040000000000",
+	}
+	for i, want := range expected {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+	}
+}
+
+func TestApplyMergesOffsetOrder(t *testing.T) {
+	// The store at the higher offset comes first in the program
+	instructions := []string{
+		"6a0afaff78560000", // *(u16 *)(r10 - 6) = 0x5678
+		"6a0af8ff34120000", // *(u16 *)(r10 - 8) = 0x1234
+	}
+
+	section := createTestSection(instructions)
+	merger := NewSuperwordMerger(section)
+	merger.applyMerges([][]int{{0, 1}})
+
+	expected := []string{
+		bpf.NOP,
+		"620af8ff34127856", // *(u32 *)(r10 - 8) = 0x56781234
+	}
+	for i, want := range expected {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+	}
+
+	// Offsets wrapping around the 16-bit field are not adjacent
+	instructions = []string{
+		"6a00ff7f12000000", // *(u16 *)(r0 + 32767) = 0x12
+		"6a00018034000000", // *(u16 *)(r0 - 32767) = 0x34
+	}
+
+	section = createTestSection(instructions)
+	merger = NewSuperwordMerger(section)
+	merger.applyMerges([][]int{{0, 1}})
+
+	for i, want := range instructions {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s", i, got, want)
+		}
+	}
 }
 
 func TestApplySuperwordMergeIntegration(t *testing.T) {
 	// Integration test with complete superword merge
 	instructions := []string{
 		"6200000012000000", // ST [r0+0], 0x12
-		"6200040034000000", // ST [r0+4], 0x34
-		"0500000000000000", // NOP (separator)
+		"6200040000000000", // ST [r0+4], 0
+		"b701000000000000", // r1 = 0 (separator)
 		"6200080056000000", // ST [r0+8], 0x56
-		"62000c0078000000", // ST [r0+12], 0x78
+		"62000c0000000000", // ST [r0+12], 0
 	}
 
 	section := createTestSection(instructions)
@@ -322,7 +551,7 @@ func TestApplySuperwordMergeIntegration(t *testing.T) {
 	}
 
 	// Check that separator instruction (index 2) is unchanged
-	if section.Instructions[2].IsNOP() {
+	if section.Instructions[2].Raw != instructions[2] {
 		t.Error("Separator instruction should not be modified")
 	}
 }
@@ -584,6 +813,97 @@ func TestSuperwordMergeNonConsecutiveOffsets(t *testing.T) {
 	}
 }
 
+func TestSuperwordMergeAcrossNopJumps(t *testing.T) {
+	// The Issue #21 offsets, separated by a goto +0
+	issue21 := []string{
+		"7206f70f28000000", // *(u8 *)(r6 + 0xff7) = 0x28
+		"0500000000000000", // goto +0
+		"7206f60f20000000", // *(u8 *)(r6 + 0xff6) = 0x20
+	}
+	// A branch lands right after the goto +0, so the second store is not always run with the first
+	branchTarget := []string{
+		"1501010000000000", // if r1 == 0 goto +1
+		"7206f70f28000000", // *(u8 *)(r6 + 0xff7) = 0x28
+		"0500000000000000", // goto +0
+		"7206f60f20000000", // *(u8 *)(r6 + 0xff6) = 0x20
+	}
+
+	tests := []struct {
+		name            string
+		insts           []string
+		storeCandidates []int
+		mergeAcross     bool
+		expected        []string
+	}{
+		{
+			name:            "default blocks",
+			insts:           issue21,
+			storeCandidates: []int{0, 2},
+			expected:        issue21,
+		},
+		{
+			name:            "merge across goto +0",
+			insts:           issue21,
+			storeCandidates: []int{0, 2},
+			mergeAcross:     true,
+			expected: []string{
+				bpf.NOP,
+				"0500000000000000",
+				"6a06f60f20280000", // *(u16 *)(r6 + 0xff6) = 0x2820
+			},
+		},
+		{
+			name:            "branch target blocks",
+			insts:           branchTarget,
+			storeCandidates: []int{1, 3},
+			mergeAcross:     true,
+			expected:        branchTarget,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section := createTestSection(tt.insts)
+			section.MergeAcrossNopJumps = tt.mergeAcross
+
+			NewSuperwordMerger(section).ApplySuperwordMergeWithCandidates(tt.storeCandidates)
+
+			for i, want := range tt.expected {
+				if got := section.Instructions[i].Raw; got != want {
+					t.Errorf("instruction %d = %s, expected %s", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSuperwordMergeStopsAtTailCall(t *testing.T) {
+	// A successful tail call never returns, so the second store does not always follow the first
+	insts := []string{
+		"6a0af8ff01000000", // 0: *(u16 *)(r10 - 8) = 1
+		"bf61000000000000", // 1: r1 = r6
+		"bf72000000000000", // 2: r2 = r7
+		"b703000000000000", // 3: r3 = 0
+		"850000000c000000", // 4: call bpf_tail_call
+		"6a0afaff02000000", // 5: *(u16 *)(r10 - 6) = 2
+		"b700000000000000", // 6: r0 = 0
+		"9500000000000000", // 7: exit
+	}
+
+	for _, mergeAcross := range []bool{false, true} {
+		section := createTestSection(insts)
+		section.MergeAcrossNopJumps = mergeAcross
+
+		NewSuperwordMerger(section).ApplySuperwordMergeWithCandidates([]int{0, 5})
+
+		for i, want := range insts {
+			if got := section.Instructions[i].Raw; got != want {
+				t.Errorf("mergeAcross=%v: instruction %d = %s, expected %s", mergeAcross, i, got, want)
+			}
+		}
+	}
+}
+
 // TestSuperwordMergeIssue21SpecificOffsets tests the specific offset case from Issue #21
 func TestSuperwordMergeIssue21SpecificOffsets(t *testing.T) {
 	// Test the exact scenario from Issue #21: 0xff7, jump, 0xff6
@@ -642,3 +962,221 @@ func TestSuperwordMergeIssue21SpecificOffsets(t *testing.T) {
 		t.Error("Second store instruction should not be NOP when merge is blocked by jump")
 	}
 }
+
+func TestSuperwordMergePreservesStringBytes(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		merged bool
+	}{
+		{name: "hello", value: "hello", merged: true},
+		{name: "two bytes", value: "hi", merged: true},
+		{name: "word", value: "abcd", merged: true},
+		{name: "double word with high bytes set is not merged", value: "abcdefgh", merged: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// *(u8 *)(r10 - 8 + i) = value[i]
+			instructions := make([]string, 0, len(tt.value))
+			for i := 0; i < len(tt.value); i++ {
+				inst, _ := bpf.NewInstructionFromFields(bpf.BPF_ST|bpf.BPF_MEM|bpf.SIZE_B, 10, 0, int16(-8+i), int32(tt.value[i]))
+				instructions = append(instructions, inst.Raw)
+			}
+
+			section := createTestSection(instructions)
+			NewSuperwordMerger(section).ApplySuperwordMergeWithCandidates(nil)
+
+			if got := section.Instructions[1].IsNOP(); got != tt.merged {
+				t.Errorf("second store merged = %v, expected %v", got, tt.merged)
+			}
+			if got := section.DumpStackBuffer(10, -8, int16(-8+len(tt.value))); string(got) != tt.value {
+				t.Errorf("DumpStackBuffer() = %q, expected %q", got, tt.value)
+			}
+		})
+	}
+}
+
+func TestSuperwordMergeOrigins(t *testing.T) {
+	section, err := NewSection(strings.Join([]string{
+		"b701000001000000", // 0: r1 = 1
+		"b702000000000000", // 1: r2 = 0
+		"631af8ff00000000", // 2: *(u32 *)(r10 - 8) = r1
+		"632afcff00000000", // 3: *(u32 *)(r10 - 4) = r2
+		"6703000020000000", // 4: r3 <<= 32
+		"7703000020000000", // 5: r3 >>= 32
+		"b700000000000000", // 6: r0 = 0
+		"9500000000000000", // 7: exit
+	}, ""), "test", false)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	if got := section.Instructions[2].Raw; got != "7a0af8ff01000000" {
+		t.Fatalf("instruction 2 = %s, expected the merged *(u64 *)(r10 - 8) = 1", got)
+	}
+
+	// The merged store comes from both movs propagated into both stores
+	expected := map[int][]int{
+		2: {0, 1, 2, 3},
+		3: {1, 3},
+		4: {4, 5},
+	}
+	if len(section.Origins) != len(expected) {
+		t.Errorf("Origins = %v, expected %v", section.Origins, expected)
+	}
+	for idx, want := range expected {
+		if got := section.Origins[idx]; !equalIntSlice(got, want) {
+			t.Errorf("Origins[%d] = %v, expected %v", idx, got, want)
+		}
+	}
+}
+
+func TestSuperwordMergeNegativeStackOffsets(t *testing.T) {
+	type store struct {
+		size uint8
+		off  int16
+		imm  int32
+	}
+
+	tests := []struct {
+		name   string
+		stores []store
+		nops   []int
+	}{
+		{
+			name:   "spilled word pair",
+			stores: []store{{bpf.SIZE_W, -56, 1}, {bpf.SIZE_W, -52, 0}},
+			nops:   []int{1},
+		},
+		{
+			name:   "four words merge pairwise",
+			stores: []store{{bpf.SIZE_W, -56, 1}, {bpf.SIZE_W, -52, 0}, {bpf.SIZE_W, -48, 2}, {bpf.SIZE_W, -44, 0}},
+			nops:   []int{1, 3},
+		},
+		{
+			name:   "bytes up to the frame pointer",
+			stores: []store{{bpf.SIZE_B, -4, 0x11}, {bpf.SIZE_B, -3, 0x22}, {bpf.SIZE_B, -2, 0x33}, {bpf.SIZE_B, -1, 0x44}},
+			nops:   []int{1, 2, 3},
+		},
+		{
+			name:   "stores written toward lower addresses",
+			stores: []store{{bpf.SIZE_H, -4, 3}, {bpf.SIZE_H, -6, 2}, {bpf.SIZE_H, -8, 1}},
+			nops:   []int{1},
+		},
+		{
+			name:   "pair starting off an 8-byte boundary",
+			stores: []store{{bpf.SIZE_W, -52, 1}, {bpf.SIZE_W, -48, 0}},
+			nops:   nil,
+		},
+		{
+			name:   "bytes starting off a 4-byte boundary",
+			stores: []store{{bpf.SIZE_B, -3, 1}, {bpf.SIZE_B, -2, 2}},
+			nops:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instructions := make([]string, 0, len(tt.stores))
+			from, to := int16(0), int16(-512)
+			for _, st := range tt.stores {
+				inst, _ := bpf.NewInstructionFromFields(bpf.BPF_ST|bpf.BPF_MEM|st.size, 10, 0, st.off, st.imm)
+				instructions = append(instructions, inst.Raw)
+				from = min(from, st.off)
+				to = max(to, st.off+int16(getSize(inst)/8))
+			}
+
+			section := createTestSection(instructions)
+			before := section.DumpStackBuffer(10, from, to)
+			NewSuperwordMerger(section).ApplySuperwordMergeWithCandidates(nil)
+
+			nops := make([]int, 0)
+			for i, inst := range section.Instructions {
+				if inst.IsNOP() {
+					nops = append(nops, i)
+				}
+			}
+			if !equalIntSlice(nops, tt.nops) {
+				t.Errorf("NOP instructions = %v, expected %v", nops, tt.nops)
+			}
+
+			if after := section.DumpStackBuffer(10, from, to); !bytes.Equal(after, before) {
+				t.Errorf("stack bytes after merge = %x, expected %x", after, before)
+			}
+		})
+	}
+}
+
+func TestSuperwordMergeWithInterveningPacketLoad(t *testing.T) {
+	instructions := []string{
+		"620af8ff01000000", // *(u32 *)(r10 - 8) = 1
+		"3000000017000000", // r0 = *(u8 *)skb[23], may end the program
+		"620afcff00000000", // *(u32 *)(r10 - 4) = 0
+	}
+
+	section := createTestSection(instructions)
+	NewSuperwordMerger(section).ApplySuperwordMergeWithCandidates(nil)
+
+	for i, want := range instructions {
+		if got := section.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s unchanged across ld_abs", i, got, want)
+		}
+	}
+	if !NewSuperwordMerger(section).hasInterveningJumpOrLoad(0, 2) {
+		t.Errorf("hasInterveningJumpOrLoad() = false, expected ld_abs to count as a load")
+	}
+}
+
+func TestSuperwordMergeUnsortedCandidates(t *testing.T) {
+	instructions := []string{
+		"7200000001000000", // *(u8 *)(r0 + 0) = 0x1
+		"7200010002000000", // *(u8 *)(r0 + 1) = 0x2
+		"7200020003000000", // *(u8 *)(r0 + 2) = 0x3
+		"7200030004000000", // *(u8 *)(r0 + 3) = 0x4
+		"0500000000000000", // goto +0
+		"6a01000005000000", // *(u16 *)(r1 + 0) = 0x5
+		"6a01020006000000", // *(u16 *)(r1 + 2) = 0x6
+		"9500000000000000", // exit
+	}
+
+	sorted := createTestSection(instructions)
+	NewSuperwordMerger(sorted).ApplySuperwordMergeWithCandidates([]int{0, 1, 2, 3, 5, 6})
+
+	unsorted := createTestSection(instructions)
+	storeCandidates := []int{6, 2, 0, 5, 3, 1, 2, 42}
+	NewSuperwordMerger(unsorted).ApplySuperwordMergeWithCandidates(storeCandidates)
+
+	for i := range instructions {
+		if got, want := unsorted.Instructions[i].Raw, sorted.Instructions[i].Raw; got != want {
+			t.Errorf("instruction %d = %s, expected %s as with sorted candidates", i, got, want)
+		}
+	}
+	if !sorted.Instructions[1].IsNOP() || !sorted.Instructions[6].IsNOP() {
+		t.Errorf("sorted candidates were not merged: %s, %s", sorted.Instructions[1].Raw, sorted.Instructions[6].Raw)
+	}
+	if want := []int{6, 2, 0, 5, 3, 1, 2, 42}; !equalIntSlice(storeCandidates, want) {
+		t.Errorf("store candidates = %v, expected the caller's slice untouched %v", storeCandidates, want)
+	}
+}
+
+func TestFindStoreRuns(t *testing.T) {
+	section := createTestSection([]string{
+		"7a0af8ff01000000", // *(u64 *)(r10 - 8) = 1
+		"620af0ff02000000", // *(u32 *)(r10 - 16) = 2
+		"620af4ff03000000", // *(u32 *)(r10 - 12) = 3
+		"7201000004000000", // *(u8 *)(r1 + 0) = 4
+		"7201010005000000", // *(u8 *)(r1 + 1) = 5
+		"7201020006000000", // *(u8 *)(r1 + 2) = 6
+		"7100000000000000", // r0 = *(u8 *)(r0 + 0)
+		"7201030007000000", // *(u8 *)(r1 + 3) = 7
+		"7201040008000000", // *(u8 *)(r1 + 4) = 8
+		"9500000000000000", // exit
+	})
+
+	// The u64 store has another size, the load splits the r1 run
+	want := [][]int{{3, 4, 5}, {1, 2}, {7, 8}}
+	if got := section.findStoreRuns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("findStoreRuns() = %v, expected %v", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+package optimizer
+
+import (
+	"math"
+	"sort"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// TailMergeCandidate is the tail of a basic block that repeats the tail of a later block
+// continuing to the same successor
+type TailMergeCandidate struct {
+	From []int // instructions of the redirected tail up to the end of its block, the first becomes a goto
+	To   int   // first instruction of the tail kept in the later block
+}
+
+// tailBlock is a basic block ending in straight-line code with a single successor
+type tailBlock struct {
+	start    int
+	body     []int // instructions before the final goto that are not no-ops
+	end      int   // last instruction of the block
+	jumps    bool  // whether the block ends with a goto rather than falling through
+	target   int   // successor
+	function int   // entry point of the function holding the block
+}
+
+// findTailMergeCandidates finds blocks whose last instructions repeat those of a later block of
+// the same function with the same successor, so the earlier one can jump into the later tail.
+// Both tails compute the same from whatever state they are entered with and continue to the
+// same instruction, so the registers and stack there are what the redirected block would have
+// left. To stay clear of what a loader or verifier tracks per instruction, tails never hold a
+// relocated instruction, a pseudo-call or pseudo-load, or half an lddw, and only forward jumps
+// are added: kernels before bounded loops reject any back-edge.
+func (s *Section) findTailMergeCandidates() []TailMergeCandidate {
+	candidates := make([]TailMergeCandidate, 0)
+	if s.ControlFlowGraph == nil {
+		return candidates
+	}
+
+	// Blocks grouped by function and successor, in order
+	groups := make(map[[2]int][]*tailBlock)
+	for _, block := range s.tailBlocks() {
+		key := [2]int{block.function, block.target}
+		groups[key] = append(groups[key], block)
+	}
+
+	redirected := make(map[int]bool) // blocks whose tail now jumps elsewhere
+	kept := make(map[int]bool)       // blocks whose tail another block jumps into
+	for _, blocks := range groups {
+		for i, from := range blocks {
+			if kept[from.start] {
+				continue
+			}
+
+			best, bestLength := (*tailBlock)(nil), 0
+			for _, to := range blocks[i+1:] {
+				if redirected[to.start] {
+					continue
+				}
+				if length := s.commonTailLength(from, to); length > bestLength {
+					best, bestLength = to, length
+				}
+			}
+
+			// The goto replacing the tail must save at least one instruction
+			saved := bestLength - 1
+			if from.jumps {
+				saved++
+			}
+			if best == nil || saved < 1 {
+				continue
+			}
+
+			first := from.body[len(from.body)-bestLength]
+			indices := make([]int, 0, from.end-first+1)
+			for k := first; k <= from.end; k++ {
+				indices = append(indices, k)
+			}
+			candidates = append(candidates, TailMergeCandidate{From: indices, To: best.body[len(best.body)-bestLength]})
+			redirected[from.start] = true
+			kept[best.start] = true
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].From[0] < candidates[j].From[0] })
+	return candidates
+}
+
+// tailBlocks returns the blocks ending in a goto or falling through, with their successor
+func (s *Section) tailBlocks() []*tailBlock {
+	blocks := make([]*tailBlock, 0)
+	starts := s.blockStarts()
+	entries := append([]int{}, s.EntryPoints...)
+	sort.Ints(entries)
+
+	for n, start := range starts {
+		end := len(s.Instructions)
+		if n+1 < len(starts) {
+			end = starts[n+1]
+		}
+
+		block := &tailBlock{start: start, end: end - 1, target: end, body: make([]int, 0)}
+		for k := start; k < end; k++ {
+			if !s.Instructions[k].IsNOP() {
+				block.body = append(block.body, k)
+			}
+		}
+		if len(block.body) == 0 {
+			continue
+		}
+
+		last := s.Instructions[block.body[len(block.body)-1]]
+		switch {
+		case last.Opcode == bpf.BPF_JMP|bpf.JMP_A:
+			block.jumps = true
+			block.target = unconditionalJumpTarget(last, block.body[len(block.body)-1])
+			block.body = block.body[:len(block.body)-1]
+		case last.IsJump() || last.Opcode == bpf.BPF_JMP|bpf.JMP_EXIT || last.IsTailCall() || end == len(s.Instructions):
+			continue
+		}
+
+		if pos := sort.SearchInts(entries, start+1); pos > 0 {
+			block.function = entries[pos-1]
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+// commonTailLength returns how many of the last body instructions of from can be replaced by a
+// jump into to, 0 when the tails differ or from does not come first
+func (s *Section) commonTailLength(from, to *tailBlock) int {
+	if from.end >= to.start {
+		return 0
+	}
+
+	length := 0
+	for length < len(from.body) && length < len(to.body) {
+		a := from.body[len(from.body)-1-length]
+		b := to.body[len(to.body)-1-length]
+		if s.Instructions[a].Raw != s.Instructions[b].Raw || !s.isTailMergeable(a) || !s.isTailMergeable(b) {
+			break
+		}
+		length++
+	}
+
+	// Never start a tail at the second slot of an lddw
+	for length > 0 {
+		first := from.body[len(from.body)-length]
+		if first == 0 || !s.Instructions[first-1].IsLoadImm64() || s.Instructions[first].Opcode != 0 {
+			break
+		}
+		length--
+	}
+
+	return length
+}
+
+// isTailMergeable checks that the instruction at idx does not depend on where it sits
+func (s *Section) isTailMergeable(idx int) bool {
+	inst := s.Instructions[idx]
+	return !s.Relocations[idx] && !s.CORERelocations[idx] && !inst.IsPseudoCall() && !inst.IsPseudoLoadImm64()
+}
+
+// applyTailMerge replaces the repeated tails with a goto into the later copy and rebuilds the
+// dependency graph, since the kept tail now has the redirected block as a predecessor
+func (s *Section) applyTailMerge() {
+	merged := false
+
+	for _, candidate := range s.findTailMergeCandidates() {
+		if !s.inRange(candidate.From...) || !s.inRange(candidate.To) {
+			continue
+		}
+
+		first := candidate.From[0]
+		if candidate.To-first-1 > math.MaxInt16 {
+			continue
+		}
+		jump, err := bpf.NewInstructionFromFields(bpf.BPF_JMP|bpf.JMP_A, 0, 0, int16(candidate.To-first-1), 0)
+		if err != nil {
+			continue
+		}
+		for _, idx := range candidate.From[1:] {
+			s.Instructions[idx].SetAsNOP()
+		}
+		s.Instructions[first] = jump
+		s.countApplied()
+		merged = true
+	}
+
+	if merged {
+		s.resetDependencies()
+		s.buildDependencies()
+	}
+}
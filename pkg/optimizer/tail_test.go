@@ -0,0 +1,123 @@
+package optimizer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// tailMergeInsts has two blocks, 1-5 and 6-9, ending with the same three instructions and both
+// continuing to 10
+var tailMergeInsts = []string{
+	"1501050000000000", // 0: if r1 == 0 goto +5
+	"b706000007000000", // 1: r6 = 7
+	"b702000001000000", // 2: r2 = 1
+	"b703000002000000", // 3: r3 = 2
+	"b704000004000000", // 4: r4 = 4
+	"0500040000000000", // 5: goto +4
+	"b706000008000000", // 6: r6 = 8
+	"b702000001000000", // 7: r2 = 1
+	"b703000002000000", // 8: r3 = 2
+	"b704000004000000", // 9: r4 = 4
+	"bf20000000000000", // 10: r0 = r2
+	"0f30000000000000", // 11: r0 += r3
+	"0f40000000000000", // 12: r0 += r4
+	"0f60000000000000", // 13: r0 += r6
+	"9500000000000000", // 14: exit
+}
+
+// executedPath follows straight-line code from start, taking gotos and skipping no-ops,
+// and returns the instructions run before reaching stop
+func executedPath(t *testing.T, section *Section, start, stop int) []string {
+	path := make([]string, 0)
+	for i := start; i != stop; i++ {
+		if i < 0 || i >= len(section.Instructions) || len(path) > len(section.Instructions) {
+			t.Fatalf("path from %d does not reach %d", start, stop)
+		}
+
+		inst := section.Instructions[i]
+		switch {
+		case inst.IsNOP():
+		case inst.Opcode == bpf.BPF_JMP|bpf.JMP_A:
+			i += int(inst.Offset)
+		default:
+			path = append(path, inst.Raw)
+		}
+	}
+	return path
+}
+
+func TestApplyTailMerge(t *testing.T) {
+	section, err := NewSection(strings.Join(tailMergeInsts, ""), "test", true)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	wantThen := executedPath(t, section, 1, 10)
+	wantElse := executedPath(t, section, 6, 10)
+
+	section.ApplyPass(PassTailMerge)
+
+	want := append([]string{}, tailMergeInsts...)
+	want[2] = "0500040000000000" // goto +4, into the tail at 7
+	want[3], want[4], want[5] = bpf.NOP, bpf.NOP, bpf.NOP
+	for i, inst := range section.Instructions {
+		if inst.Raw != want[i] {
+			t.Errorf("instruction %d = %s, want %s", i, inst.Raw, want[i])
+		}
+	}
+
+	if got := executedPath(t, section, 1, 10); !reflect.DeepEqual(got, wantThen) {
+		t.Errorf("path from 1 = %v, want %v", got, wantThen)
+	}
+	if got := executedPath(t, section, 6, 10); !reflect.DeepEqual(got, wantElse) {
+		t.Errorf("path from 6 = %v, want %v", got, wantElse)
+	}
+
+	// The rebuilt graph sees the redirected block as a predecessor of the kept tail
+	if !section.FoundDependency(10, 7) || len(section.Dependencies[2].DependedBy) != 0 {
+		t.Errorf("r0 = r2 depends on %v, want the r2 = 1 at 7", section.Dependencies[10].Dependencies)
+	}
+	if got := section.Candidates[PassTailMerge]; got == nil || got.Applied != 1 {
+		t.Errorf("tail-merge candidates = %v, want 1 applied", got)
+	}
+}
+
+func TestFindTailMergeCandidatesSkipped(t *testing.T) {
+	tests := []struct {
+		name        string
+		modify      func(insts []string)
+		relocations map[int]bool
+	}{
+		{
+			name:   "tails differ",
+			modify: func(insts []string) { insts[9] = "b704000005000000" }, // r4 = 5
+		},
+		{
+			name:   "different successors",
+			modify: func(insts []string) { insts[5] = "0500030000000000" }, // goto +3, to 9
+		},
+		{
+			name:        "relocated instruction in the tail",
+			modify:      func(insts []string) {},
+			relocations: map[int]bool{4: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			insts := append([]string{}, tailMergeInsts...)
+			tt.modify(insts)
+			section, err := NewSection(strings.Join(insts, ""), "test", true)
+			if err != nil {
+				t.Fatalf("NewSection() error = %v", err)
+			}
+			section.Relocations = tt.relocations
+			if candidates := section.findTailMergeCandidates(); len(candidates) != 0 {
+				t.Errorf("findTailMergeCandidates() = %v, want none", candidates)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package optimizer
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TraceEntry records one pass invocation on a section and the instructions it rewrote
+type TraceEntry struct {
+	Section    string        `json:"section"`
+	Pass       string        `json:"pass"`
+	Changes    []TraceChange `json:"changes"`
+	Eliminated int           `json:"eliminated"` // active instructions the pass removed, see countActiveInstructions
+}
+
+// TraceChange is a single instruction rewritten by a pass
+type TraceChange struct {
+	Index  int    `json:"index"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// tracePass runs a pass and appends the instructions it changed to the section trace
+func (s *Section) tracePass(pass string, apply func()) {
+	before := make([]string, len(s.Instructions))
+	for i, inst := range s.Instructions {
+		before[i] = inst.Raw
+	}
+	active := countActiveInstructions(s.Instructions)
+
+	apply()
+
+	changes := make([]TraceChange, 0)
+	for i, inst := range s.Instructions {
+		if inst.Raw != before[i] {
+			changes = append(changes, TraceChange{Index: i, Before: before[i], After: inst.Raw})
+		}
+	}
+
+	s.Trace = append(s.Trace, TraceEntry{
+		Section:    s.Name,
+		Pass:       pass,
+		Changes:    changes,
+		Eliminated: active - countActiveInstructions(s.Instructions),
+	})
+}
+
+// EliminatedByPass sums the instructions each pass removed over the trace of the section
+func (s *Section) EliminatedByPass() map[string]int {
+	eliminated := make(map[string]int)
+	for _, entry := range s.Trace {
+		eliminated[entry.Pass] += entry.Eliminated
+	}
+	return eliminated
+}
+
+// Trace returns the pass trace of every section, ordered by section name
+func (prog *BPFProgram) Trace() []TraceEntry {
+	names := make([]string, 0, len(prog.Sections))
+	for name := range prog.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	trace := make([]TraceEntry, 0)
+	for _, name := range names {
+		trace = append(trace, prog.Sections[name].Trace...)
+	}
+	return trace
+}
+
+// CompareTraces reports the first difference between a recorded trace and a fresh one
+func CompareTraces(want, got []TraceEntry) error {
+	if len(want) != len(got) {
+		return fmt.Errorf("trace has %d pass invocations, fresh run has %d", len(want), len(got))
+	}
+
+	for i := range want {
+		w, g := want[i], got[i]
+		if w.Section != g.Section || w.Pass != g.Pass {
+			return fmt.Errorf("entry %d: recorded %s/%s, fresh run %s/%s", i, w.Section, w.Pass, g.Section, g.Pass)
+		}
+		if len(w.Changes) != len(g.Changes) {
+			return fmt.Errorf("entry %d (%s/%s): recorded %d changes, fresh run %d",
+				i, w.Section, w.Pass, len(w.Changes), len(g.Changes))
+		}
+		for j := range w.Changes {
+			if w.Changes[j] != g.Changes[j] {
+				return fmt.Errorf("entry %d (%s/%s): change %d recorded %+v, fresh run %+v",
+					i, w.Section, w.Pass, j, w.Changes[j], g.Changes[j])
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package optimizer
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSectionTrace(t *testing.T) {
+	hexData, err := os.ReadFile("../../testdata/section_data")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	section, err := NewSection(string(hexData), ".text", false)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+
+	wantPasses := []string{PassConstantPropagation, PassCompaction, PassPeephole, PassLoadImm64Dedup, PassBranchFolding, PassSuperword, PassDeadCode, PassRedundantDef, PassSelfMove}
+	if len(section.Trace) != len(wantPasses) {
+		t.Fatalf("got %d trace entries, want %d", len(section.Trace), len(wantPasses))
+	}
+
+	for i, entry := range section.Trace {
+		if entry.Pass != wantPasses[i] || entry.Section != ".text" {
+			t.Errorf("entry %d = %s/%s, want .text/%s", i, entry.Section, entry.Pass, wantPasses[i])
+		}
+		for _, change := range entry.Changes {
+			if change.Before == change.After {
+				t.Errorf("entry %d records an unchanged instruction %d", i, change.Index)
+			}
+		}
+	}
+
+	if len(section.Trace[0].Changes) == 0 {
+		t.Errorf("constant propagation recorded no changes on the fixture")
+	}
+
+	// A fresh run must replay the same trace
+	fresh, err := NewSection(string(hexData), ".text", false)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	if err := CompareTraces(section.Trace, fresh.Trace); err != nil {
+		t.Errorf("CompareTraces() error = %v", err)
+	}
+
+	fresh.Trace[0].Changes = fresh.Trace[0].Changes[1:]
+	if err := CompareTraces(section.Trace, fresh.Trace); err == nil {
+		t.Errorf("CompareTraces() did not report a dropped change")
+	}
+}
+
+func TestEliminatedByPass(t *testing.T) {
+	prog, err := NewBPFProgram(testObjectFile)
+	if err != nil {
+		t.Fatalf("NewBPFProgram() error = %v", err)
+	}
+	defer prog.Close()
+
+	// Each pass accounts for its share of the instructions removed
+	for name, section := range prog.Sections {
+		total := 0
+		for _, count := range section.EliminatedByPass() {
+			total += count
+		}
+		if want := section.Stats.InstructionsBefore - section.Stats.InstructionsAfter; total != want {
+			t.Errorf("%s: passes eliminated %d instructions, expected %d", name, total, want)
+		}
+	}
+
+	summary := prog.GetOptimizationStats()["summary"].(map[string]interface{})
+	eliminated := summary["eliminated"].(map[string]int)
+	want := map[string]int{PassConstantPropagation: 94, PassCompaction: 50, PassPeephole: 3, PassSuperword: 143, PassRedundantDef: 2}
+	for pass, count := range want {
+		if eliminated[pass] != count {
+			t.Errorf("eliminated[%s] = %d, expected %d", pass, eliminated[pass], count)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package optimizer
+
+// DeadCodeAfterExit returns the instructions of the blocks no function reaches, in order: code
+// after an exit or goto that no branch targets. Without symbols such a block cannot be told apart
+// from a function only reached by relocated calls, so this needs FunctionStarts and returns nothing
+// when they are unknown.
+// The CFG is the one built by the dependency analysis; passes only remove edges from it since,
+// so blocks they leave unreachable are not reported.
+func (s *Section) DeadCodeAfterExit() []int {
+	dead := make([]int, 0)
+	cfg := s.ControlFlowGraph
+	if cfg == nil || s.FunctionStarts == nil {
+		return dead
+	}
+
+	reachable := make(map[int]bool)
+	for _, entry := range append([]int{0}, s.FunctionStarts...) {
+		// A function starting inside a block means the CFG does not match the symbols
+		if _, isBlock := cfg.NodesLen[entry]; !isBlock {
+			return dead
+		}
+		for block := range cfg.ReachableBlocks(entry) {
+			reachable[block] = true
+		}
+	}
+
+	for i := range s.Instructions {
+		if length, isBlock := cfg.NodesLen[i]; isBlock && !reachable[i] {
+			for k := i; k < i+length && k < len(s.Instructions); k++ {
+				dead = append(dead, k)
+			}
+		}
+	}
+
+	return dead
+}
+
+// findDeadCodeCandidates groups the unreachable instructions by block and keeps the blocks
+// with something left to remove and no relocation, whose patch would land on a NOP, or pseudo-load
+func (s *Section) findDeadCodeCandidates() [][]int {
+	candidates := make([][]int, 0)
+
+	var block []int
+	flush := func() {
+		if len(block) == 0 {
+			return
+		}
+		removable, relocated := false, false
+		for _, idx := range block {
+			removable = removable || !s.Instructions[idx].IsNOP()
+			relocated = relocated || s.Relocations[idx] || s.CORERelocations[idx] || s.Instructions[idx].IsPseudoLoadImm64()
+		}
+		if removable && relocated {
+			s.countBlocked(BlockedRelocation)
+		} else if removable {
+			candidates = append(candidates, block)
+		}
+		block = nil
+	}
+
+	for _, idx := range s.DeadCodeAfterExit() {
+		if _, isBlock := s.ControlFlowGraph.NodesLen[idx]; isBlock {
+			flush()
+		}
+		block = append(block, idx)
+	}
+	flush()
+
+	return candidates
+}
+
+// applyDeadCodeElimination replaces the unreachable blocks with NOPs
+func (s *Section) applyDeadCodeElimination() {
+	for _, candidate := range s.findDeadCodeCandidates() {
+		if !s.inRange(candidate...) {
+			continue
+		}
+
+		for _, idx := range candidate {
+			s.Instructions[idx].SetAsNOP()
+		}
+		s.countApplied()
+	}
+}
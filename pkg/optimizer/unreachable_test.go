@@ -0,0 +1,85 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+func TestApplyDeadCodeElimination(t *testing.T) {
+	tests := []struct {
+		name           string
+		insts          []string
+		functionStarts []int
+		expected       []string
+	}{
+		{
+			name: "code after exit",
+			insts: []string{
+				"b700000000000000", // 0: r0 = 0
+				"9500000000000000", // 1: exit
+				"b700000001000000", // 2: r0 = 1, nothing jumps here
+				"9500000000000000", // 3: exit
+			},
+			functionStarts: []int{0},
+			expected: []string{
+				"b700000000000000",
+				"9500000000000000",
+				bpf.NOP,
+				bpf.NOP,
+			},
+		},
+		{
+			name: "branch target after exit",
+			insts: []string{
+				"1501020000000000", // 0: if r1 == 0 goto +2
+				"b700000000000000", // 1: r0 = 0
+				"9500000000000000", // 2: exit
+				"b700000001000000", // 3: r0 = 1
+				"9500000000000000", // 4: exit
+			},
+			functionStarts: []int{0},
+		},
+		{
+			name: "function after exit",
+			insts: []string{
+				"b700000000000000", // 0: r0 = 0
+				"9500000000000000", // 1: exit
+				"b700000001000000", // 2: r0 = 1, start of another function
+				"9500000000000000", // 3: exit
+			},
+			functionStarts: []int{0, 2},
+		},
+		{
+			name: "function symbols unknown",
+			insts: []string{
+				"b700000000000000", // 0: r0 = 0
+				"9500000000000000", // 1: exit
+				"b700000001000000", // 2: r0 = 1
+				"9500000000000000", // 3: exit
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section, err := NewSection(strings.Join(tt.insts, ""), "test", true)
+			if err != nil {
+				t.Fatalf("NewSection() error = %v", err)
+			}
+			section.FunctionStarts = tt.functionStarts
+			section.applyDeadCodeElimination()
+
+			expected := tt.expected
+			if expected == nil {
+				expected = tt.insts
+			}
+			for i, want := range expected {
+				if got := section.Instructions[i].Raw; got != want {
+					t.Errorf("instruction %d = %s, expected %s", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package optimizer
+
+import (
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// frameRegister is r10, the read-only stack frame pointer
+const frameRegister = 10
+
+// Verify checks the section for instructions no valid BPF program contains, or whose
+// Raw and decoded fields disagree.
+// It reports the first offending instruction.
+func (s *Section) Verify() error {
+	for i, inst := range s.Instructions {
+		if err := inst.CheckIntegrity(); err != nil {
+			return &ErrInconsistentInstruction{Index: i, Raw: inst.Raw, Err: err}
+		}
+
+		// Register state is indexed by number, so this must come before any analysis
+		for _, reg := range []uint8{inst.DstReg, inst.SrcReg} {
+			if reg > frameRegister {
+				return &ErrInvalidRegister{Index: i, Raw: inst.Raw, Reg: reg}
+			}
+		}
+
+		if !isValidALUOffset(inst) {
+			return &ErrUnusedOffset{Index: i, Raw: inst.Raw, Offset: inst.Offset}
+		}
+
+		if analyzeInstruction(inst).UpdatedReg == frameRegister {
+			return &ErrReadOnlyRegisterWrite{Index: i, Raw: inst.Raw}
+		}
+	}
+
+	if errs := s.ValidateJumpTargets(); len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+// isValidALUOffset checks the offset of an ALU instruction, which only signed division and
+// modulo (1) and sign-extending moves (8, 16 and, for 64 bits, 32) use. Any other nonzero
+// offset is ignored by the analysis but points at a corrupt object.
+func isValidALUOffset(inst *bpf.Instruction) bool {
+	class := inst.GetInstructionClass()
+	if (class != bpf.BPF_ALU && class != bpf.BPF_ALU64) || inst.Offset == 0 {
+		return true
+	}
+
+	switch op := inst.GetALUOp(); {
+	case op == bpf.ALU_DIV || op == bpf.ALU_MOD:
+		return inst.Offset == 1
+	case op == bpf.ALU_MOV && inst.Opcode&bpf.BPF_X != 0:
+		return inst.Offset == 8 || inst.Offset == 16 || (inst.Offset == 32 && class == bpf.BPF_ALU64)
+	}
+	return false
+}
+
+// ValidateJumpTargets checks that every jump and branch lands inside the section.
+// It returns an *ErrJumpOutOfBounds for each violation, in instruction order.
+// BPF-to-BPF calls are left out: a relocation may point them into another section.
+func (s *Section) ValidateJumpTargets() []error {
+	errs := make([]error, 0)
+
+	for i, inst := range s.Instructions {
+		if !inst.IsJump() {
+			continue
+		}
+
+		if target := unconditionalJumpTarget(inst, i); target < 0 || target >= len(s.Instructions) {
+			errs = append(errs, &ErrJumpOutOfBounds{Index: i, Raw: inst.Raw, Target: target})
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,131 @@
+package optimizer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		hex       []string
+		wantIndex int // -1 when the section is valid
+	}{
+		{
+			name:      "reads of r10 are valid",
+			hex:       []string{"bfa1000000000000", "7b1af8ff00000000", "9500000000000000"}, // r1 = r10; *(u64 *)(r10 - 8) = r1; exit
+			wantIndex: -1,
+		},
+		{
+			name:      "mov r10, r1",
+			hex:       []string{"b701000000000000", "bf1a000000000000", "9500000000000000"},
+			wantIndex: 1,
+		},
+		{
+			name:      "load into r10",
+			hex:       []string{"791a000000000000", "9500000000000000"}, // r10 = *(u64 *)(r1 + 0)
+			wantIndex: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section := createTestSection(tt.hex)
+			err := section.Verify()
+
+			if tt.wantIndex < 0 {
+				if err != nil {
+					t.Errorf("Verify() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var r10Err *ErrReadOnlyRegisterWrite
+			if !errors.As(err, &r10Err) {
+				t.Fatalf("Verify() error = %v, want ErrReadOnlyRegisterWrite", err)
+			}
+			if r10Err.Index != tt.wantIndex {
+				t.Errorf("ErrReadOnlyRegisterWrite.Index = %d, want %d", r10Err.Index, tt.wantIndex)
+			}
+		})
+	}
+}
+
+func TestVerifyInvalidRegister(t *testing.T) {
+	// r11 = 1 and the fuzzer-found 0x30 0x2b: register nibbles only go up to r10
+	for _, raw := range []string{"b70b000001000000", "302b303030303030"} {
+		section := createTestSection([]string{raw, "9500000000000000"})
+
+		var regErr *ErrInvalidRegister
+		if err := section.Verify(); !errors.As(err, &regErr) || regErr.Index != 0 {
+			t.Errorf("Verify(%s) error = %v, want ErrInvalidRegister at 0", raw, err)
+		}
+	}
+}
+
+func TestVerifyUnusedOffset(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		valid bool
+	}{
+		{"add with an offset", "0401030005000000", false},            // w1 += 5, off 3
+		{"64-bit mov imm with an offset", "b701080005000000", false}, // r1 = 5, off 8
+		{"div with offset 2", "3f21020000000000", false},             // r1 /= r2, off 2
+		{"sdiv", "3f21010000000000", true},                           // r1 s/= r2
+		{"smod32", "9401010003000000", true},                         // w1 s%= 3
+		{"movsx 8", "bf21080000000000", true},                        // r1 = (s8)r2
+		{"movsx32 32", "bc21200000000000", false},                    // w1 = (s32)w2 does not exist
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section := createTestSection([]string{tt.raw, "9500000000000000"})
+			err := section.Verify()
+
+			var offErr *ErrUnusedOffset
+			if tt.valid && err != nil {
+				t.Errorf("Verify() error = %v, want nil", err)
+			}
+			if !tt.valid && (!errors.As(err, &offErr) || offErr.Index != 0) {
+				t.Errorf("Verify() error = %v, want ErrUnusedOffset at 0", err)
+			}
+		})
+	}
+}
+
+func TestVerifyInconsistentInstruction(t *testing.T) {
+	section := createTestSection([]string{"b701000001000000", "9500000000000000"}) // r1 = 1; exit
+	section.Instructions[0].DstReg = 2
+
+	var incErr *ErrInconsistentInstruction
+	if err := section.Verify(); !errors.As(err, &incErr) || incErr.Index != 0 {
+		t.Errorf("Verify() error = %v, want ErrInconsistentInstruction at 0", err)
+	}
+}
+
+func TestValidateJumpTargets(t *testing.T) {
+	section := createTestSection([]string{
+		"0500050000000000", // 0: goto +5, past the end
+		"1501fdffffff0000", // 1: if r1 == 0xffff goto -3, before the start
+		"0500000000000000", // 2: goto +0
+		"9500000000000000", // 3: exit
+	})
+
+	errs := section.ValidateJumpTargets()
+	want := []ErrJumpOutOfBounds{{Index: 0, Target: 6}, {Index: 1, Target: -1}}
+	if len(errs) != len(want) {
+		t.Fatalf("ValidateJumpTargets() = %v, want %d violations", errs, len(want))
+	}
+	for i, err := range errs {
+		var jumpErr *ErrJumpOutOfBounds
+		if !errors.As(err, &jumpErr) || jumpErr.Index != want[i].Index || jumpErr.Target != want[i].Target {
+			t.Errorf("violation %d = %v, want index %d target %d", i, err, want[i].Index, want[i].Target)
+		}
+	}
+
+	var jumpErr *ErrJumpOutOfBounds
+	if err := section.Verify(); !errors.As(err, &jumpErr) || jumpErr.Target != 6 {
+		t.Errorf("Verify() error = %v, want ErrJumpOutOfBounds with target 6", err)
+	}
+}
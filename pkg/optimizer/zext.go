@@ -0,0 +1,98 @@
+package optimizer
+
+import (
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+// findZeroExtensionCandidates finds zero-extensions of a register whose only reaching
+// definition is the 32-bit ALU op right before it. 32-bit ALU ops already zero the upper
+// 32 bits, so `r &= 0xffffffff`, `w = w` and the `r <<= 32; r >>= 32` pair are redundant.
+// Each candidate lists the instructions to NOP.
+func (s *Section) findZeroExtensionCandidates() [][]int {
+	candidates := make([][]int, 0)
+
+	for i := 1; i < len(s.Instructions); i++ {
+		def := s.Instructions[i-1]
+		if !isZeroExtendingALU32(def) {
+			continue
+		}
+
+		inst := s.Instructions[i]
+		if inst.DstReg != def.DstReg {
+			continue
+		}
+
+		// Another path may reach the extension with a full 64-bit value
+		deps := s.Dependencies[i].Deduplication().Dependencies
+		if len(deps) != 1 || deps[0] != i-1 {
+			continue
+		}
+
+		switch {
+		case inst.Opcode == bpf.BPF_ALU64|bpf.ALU_AND|bpf.BPF_K && inst.Raw[8:] == "ffffffff":
+			candidates = append(candidates, []int{i})
+		case inst.Opcode == bpf.BPF_ALU|bpf.ALU_MOV|bpf.BPF_X && inst.SrcReg == inst.DstReg && inst.Offset == 0:
+			candidates = append(candidates, []int{i})
+		case i+1 < len(s.Instructions) && isShift32(inst, 0x67) && isShift32(s.Instructions[i+1], 0x77) &&
+			s.Instructions[i+1].DstReg == inst.DstReg:
+			candidates = append(candidates, []int{i, i + 1})
+			i++
+		}
+	}
+
+	return candidates
+}
+
+// applyZeroExtensionElimination NOPs the redundant zero-extensions
+func (s *Section) applyZeroExtensionElimination() {
+	for _, candidate := range s.findZeroExtensionCandidates() {
+		if !s.inRange(candidate...) {
+			continue
+		}
+		for _, idx := range candidate {
+			s.Instructions[idx].SetAsNOP()
+		}
+		s.countApplied()
+	}
+}
+
+// findAllOnesMaskCandidates finds `w &= 0xffffffff`, which keeps the lower half and zeroes the
+// upper one just like `w = w`. The 64-bit `r &= 0xffffffff` is not a zero-extension: its imm is
+// sign-extended to all ones and the AND changes nothing. Zero-extending takes an lddw mask, the
+// case findMaskCandidates handles.
+func (s *Section) findAllOnesMaskCandidates() []int {
+	candidates := make([]int, 0)
+
+	for i, inst := range s.Instructions {
+		if inst.Opcode == bpf.BPF_ALU|bpf.ALU_AND|bpf.BPF_K && inst.Imm == -1 {
+			candidates = append(candidates, i)
+		}
+	}
+
+	return candidates
+}
+
+// applyAllOnesMaskRewrite replaces `w &= 0xffffffff` with `w = w`
+func (s *Section) applyAllOnesMaskRewrite() {
+	for _, idx := range s.findAllOnesMaskCandidates() {
+		if !s.inRange(idx) {
+			continue
+		}
+
+		dst := s.Instructions[idx].DstReg
+		s.Instructions[idx] = newMov32(dst, dst)
+		s.recordOrigins(idx, idx)
+		s.countApplied()
+	}
+}
+
+// isZeroExtendingALU32 checks if a 32-bit ALU op writes the lower half of dst and clears the upper half.
+// Byte swaps are excluded since `le64`/`be64` keep all 64 bits in the ALU class.
+func isZeroExtendingALU32(inst *bpf.Instruction) bool {
+	return inst.GetInstructionClass() == bpf.BPF_ALU && inst.GetALUOp() != bpf.ALU_END
+}
+
+// isShift32 checks for a 64-bit shift of 32 with the given opcode (0x67 lsh, 0x77 rsh)
+func isShift32(inst *bpf.Instruction, opcode uint8) bool {
+	return inst.Opcode == opcode && inst.Raw[8:] == "20000000"
+}
@@ -0,0 +1,180 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/beepfd/bpf-optimizer/pkg/bpf"
+)
+
+func TestApplyZeroExtensionElimination(t *testing.T) {
+	tests := []struct {
+		name     string
+		hex      []string
+		expected []string
+	}{
+		{
+			name: "add32 followed by AND mask",
+			hex: []string{
+				"0401000005000000", // w1 += 5
+				"57010000ffffffff", // r1 &= 0xffffffff
+				"bf10000000000000", // r0 = r1
+				"9500000000000000", // exit
+			},
+			expected: []string{
+				"0401000005000000",
+				bpf.NOP,
+				"bf10000000000000",
+				"9500000000000000",
+			},
+		},
+		{
+			name: "add32 followed by LSH/RSH pair",
+			hex: []string{
+				"0401000005000000", // w1 += 5
+				"6701000020000000", // r1 <<= 32
+				"7701000020000000", // r1 >>= 32
+				"bf10000000000000", // r0 = r1
+				"9500000000000000", // exit
+			},
+			expected: []string{
+				"0401000005000000",
+				bpf.NOP,
+				bpf.NOP,
+				"bf10000000000000",
+				"9500000000000000",
+			},
+		},
+		{
+			name: "64-bit add keeps the extension",
+			hex: []string{
+				"0701000005000000", // r1 += 5
+				"57010000ffffffff", // r1 &= 0xffffffff
+				"bf10000000000000", // r0 = r1
+				"9500000000000000", // exit
+			},
+			expected: []string{
+				"0701000005000000",
+				"57010000ffffffff",
+				"bf10000000000000",
+				"9500000000000000",
+			},
+		},
+		{
+			name: "extension of a different register",
+			hex: []string{
+				"0401000005000000", // w1 += 5
+				"57020000ffffffff", // r2 &= 0xffffffff
+				"bf10000000000000", // r0 = r1
+				"9500000000000000", // exit
+			},
+			expected: []string{
+				"0401000005000000",
+				"57020000ffffffff",
+				"bf10000000000000",
+				"9500000000000000",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section, err := NewSection(strings.Join(tt.hex, ""), "test", true)
+			if err != nil {
+				t.Fatalf("NewSection() error = %v", err)
+			}
+
+			section.applyZeroExtensionElimination()
+
+			for i, want := range tt.expected {
+				if got := section.Instructions[i].Raw; got != want {
+					t.Errorf("instruction %d = %s, expected %s", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyAllOnesMaskRewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		hex      []string
+		expected []string
+	}{
+		{
+			name: "32-bit AND becomes a mov",
+			hex: []string{
+				"54010000ffffffff", // w1 &= 0xffffffff
+				"bf10000000000000", // r0 = r1
+				"9500000000000000", // exit
+			},
+			expected: []string{
+				"bc11000000000000",
+				"bf10000000000000",
+				"9500000000000000",
+			},
+		},
+		{
+			name: "64-bit AND is kept",
+			hex: []string{
+				"57010000ffffffff", // r1 &= 0xffffffff
+				"bf10000000000000", // r0 = r1
+				"9500000000000000", // exit
+			},
+			expected: []string{
+				"57010000ffffffff",
+				"bf10000000000000",
+				"9500000000000000",
+			},
+		},
+		{
+			name: "other masks are kept",
+			hex: []string{
+				"54010000ffff0000", // w1 &= 0xffff
+				"57020000ffffff7f", // r2 &= 0x7fffffff
+				"bf10000000000000", // r0 = r1
+				"9500000000000000", // exit
+			},
+			expected: []string{
+				"54010000ffff0000",
+				"57020000ffffff7f",
+				"bf10000000000000",
+				"9500000000000000",
+			},
+		},
+		{
+			name: "lddw mask is left to the mask path",
+			hex: []string{
+				"18020000ffffffff", // r2 = 0xffffffff ll
+				"0000000000000000",
+				"5f21000000000000", // r1 &= r2
+				"bf10000000000000", // r0 = r1
+				"9500000000000000", // exit
+			},
+			expected: []string{
+				"18020000ffffffff",
+				"0000000000000000",
+				"5f21000000000000",
+				"bf10000000000000",
+				"9500000000000000",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section, err := NewSection(strings.Join(tt.hex, ""), "test", true)
+			if err != nil {
+				t.Fatalf("NewSection() error = %v", err)
+			}
+
+			section.applyAllOnesMaskRewrite()
+
+			for i, want := range tt.expected {
+				if got := section.Instructions[i].Raw; got != want {
+					t.Errorf("instruction %d = %s, expected %s", i, got, want)
+				}
+			}
+		})
+	}
+}